@@ -84,6 +84,11 @@ type TiKVClient struct {
 	// TTLRefreshedTxnSize controls whether a transaction should update its TTL or not.
 	TTLRefreshedTxnSize      int64  `toml:"ttl-refreshed-txn-size" json:"ttl-refreshed-txn-size"`
 	ResolveLockLiteThreshold uint64 `toml:"resolve-lock-lite-threshold" json:"resolve-lock-lite-threshold"`
+	// AsyncResolveLockForRead makes the read requests proceed while the locks of the
+	// committed or rolled back transactions they meet are resolved in the background.
+	AsyncResolveLockForRead bool `toml:"async-resolve-lock-for-read" json:"async-resolve-lock-for-read"`
+	// ResolvedCache is the cache of the resolved transaction statuses in LockResolver.
+	ResolvedCache ResolvedCache `toml:"resolved-cache" json:"resolved-cache"`
 }
 
 // AsyncCommit is the config for the async commit feature. The switch to enable it is a system variable.
@@ -115,6 +120,17 @@ type CoprocessorCache struct {
 	AdmissionMinProcessMs uint64 `toml:"admission-min-process-ms" json:"-"`
 }
 
+// ResolvedCache is the config for the cache of the resolved transaction statuses.
+type ResolvedCache struct {
+	// Capacity is the max number of the cached transactions. Zero means the
+	// default capacity of the LockResolver, which is 2048.
+	Capacity uint `toml:"capacity" json:"capacity"`
+	// TTL is how long a transaction status is cached. Zero means no limit.
+	TTL time.Duration `toml:"ttl" json:"ttl"`
+	// Shared makes the stores connected to the same cluster share one cache.
+	Shared bool `toml:"shared" json:"shared"`
+}
+
 // DefaultTiKVClient returns default config for TiKVClient.
 func DefaultTiKVClient() TiKVClient {
 	return TiKVClient{
@@ -152,6 +168,12 @@ func DefaultTiKVClient() TiKVClient {
 		},
 
 		ResolveLockLiteThreshold: 16,
+		AsyncResolveLockForRead:  true,
+
+		ResolvedCache: ResolvedCache{
+			Capacity: 2048,
+			TTL:      10 * time.Minute,
+		},
 	}
 }
 
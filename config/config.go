@@ -109,12 +109,16 @@ func DefaultConfig() Config {
 type PDClient struct {
 	// PDServerTimeout is the max time which PD client will wait for the PD server in seconds.
 	PDServerTimeout uint `toml:"pd-server-timeout" json:"pd-server-timeout"`
+	// DisableEtcdSafePointKV makes the client read and update the GC safepoint
+	// with the PD APIs instead of a separate etcd connection to the PD endpoints.
+	DisableEtcdSafePointKV bool `toml:"disable-etcd-safe-point-kv" json:"disable-etcd-safe-point-kv"`
 }
 
 // DefaultPDClient returns the default configuration for PDClient
 func DefaultPDClient() PDClient {
 	return PDClient{
-		PDServerTimeout: 3,
+		PDServerTimeout:        3,
+		DisableEtcdSafePointKV: false,
 	}
 }
 
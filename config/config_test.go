@@ -74,3 +74,9 @@ func TestTxnScopeValue(t *testing.T) {
 	err = failpoint.Disable("tikvclient/injectTxnScope")
 	assert.Nil(t, err)
 }
+
+func TestResolvedCacheCapacity(t *testing.T) {
+	conf := DefaultTiKVClient()
+	conf.ResolvedCache.Capacity = 0
+	assert.Nil(t, conf.Valid())
+}
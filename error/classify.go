@@ -0,0 +1,283 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package error
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Category classifies errors by how the caller is expected to handle them.
+type Category int
+
+const (
+	// CategoryFatal means the operation failed and retrying it won't help.
+	// Errors that are not classified also belong to this category.
+	CategoryFatal Category = iota
+	// CategoryRetryable means the same request can be retried.
+	CategoryRetryable
+	// CategoryConflict means the transaction conflicts with other transactions,
+	// the whole transaction should be restarted with a new start ts.
+	CategoryConflict
+	// CategoryUndetermined means the result of the operation is unknown, the
+	// transaction may or may not be committed.
+	CategoryUndetermined
+	// CategoryTimeout means the operation did not finish in time.
+	CategoryTimeout
+	// CategoryThrottled means the server is overloaded, the request can be retried after a backoff.
+	CategoryThrottled
+)
+
+func (c Category) String() string {
+	switch c {
+	case CategoryFatal:
+		return "fatal"
+	case CategoryRetryable:
+		return "retryable"
+	case CategoryConflict:
+		return "conflict"
+	case CategoryUndetermined:
+		return "undetermined"
+	case CategoryTimeout:
+		return "timeout"
+	case CategoryThrottled:
+		return "throttled"
+	}
+	return fmt.Sprintf("category(%d)", int(c))
+}
+
+// Code is the stable numeric code of an error.
+// The value of a code never changes once it is released.
+type Code int
+
+// Codes of the errors defined in this package.
+const (
+	CodeUnknown                   Code = 9000
+	CodePDServerTimeout           Code = 9001
+	CodeTiKVServerTimeout         Code = 9002
+	CodeTiKVServerBusy            Code = 9003
+	CodeResolveLockTimeout        Code = 9004
+	CodeRegionUnavailable         Code = 9005
+	CodeGCTooEarly                Code = 9006
+	CodeWriteConflict             Code = 9007
+	CodeTokenLimit                Code = 9008
+	CodeTiKVStaleCommand          Code = 9010
+	CodeTiKVMaxTimestampNotSynced Code = 9011
+	CodeTiFlashServerTimeout      Code = 9012
+	CodeTiFlashServerBusy         Code = 9013
+	CodeRegionDataNotReady        Code = 9014
+	CodeRegionNotInitialized      Code = 9015
+	CodeTiKVDiskFull              Code = 9016
+	CodeWriteConflictInLatch      Code = 9017
+	CodeRetryable                 Code = 9018
+	CodeDeadlock                  Code = 9019
+	CodeLockWaitTimeout           Code = 9020
+	CodeLockAcquireFailAndNoWait  Code = 9021
+	CodeKeyExist                  Code = 9022
+	CodeAssertionFailed           Code = 9023
+	CodeResultUndetermined        Code = 9024
+	CodeTxnTooLarge               Code = 9025
+	CodeEntryTooLarge             Code = 9026
+	CodeNotExist                  Code = 9027
+	CodeInvalidTxn                Code = 9028
+	CodeCannotSetNilValue         Code = 9029
+	CodeQueryInterrupted          Code = 9030
+	CodeBodyMissing               Code = 9031
+	CodeTiDBShuttingDown          Code = 9032
+	CodePDError                   Code = 9033
+	CodeWriteInStaleReadTxn       Code = 9034
+	CodeContextCanceled           Code = 9035
+	CodeContextDeadlineExceeded   Code = 9036
+)
+
+// ClassifiedError is implemented by the errors which know their code and category.
+type ClassifiedError interface {
+	error
+	Code() Code
+	Category() Category
+}
+
+type sentinelClass struct {
+	err      error
+	code     Code
+	category Category
+}
+
+// sentinelClasses classifies the sentinel errors, which can't carry methods.
+var sentinelClasses = []sentinelClass{
+	{ErrBodyMissing, CodeBodyMissing, CategoryRetryable},
+	{ErrTiDBShuttingDown, CodeTiDBShuttingDown, CategoryFatal},
+	{ErrNotExist, CodeNotExist, CategoryFatal},
+	{ErrCannotSetNilValue, CodeCannotSetNilValue, CategoryFatal},
+	{ErrInvalidTxn, CodeInvalidTxn, CategoryFatal},
+	{ErrTiKVServerTimeout, CodeTiKVServerTimeout, CategoryTimeout},
+	{ErrTiFlashServerTimeout, CodeTiFlashServerTimeout, CategoryTimeout},
+	{ErrQueryInterrupted, CodeQueryInterrupted, CategoryFatal},
+	{ErrTiKVStaleCommand, CodeTiKVStaleCommand, CategoryRetryable},
+	{ErrTiKVMaxTimestampNotSynced, CodeTiKVMaxTimestampNotSynced, CategoryRetryable},
+	{ErrLockAcquireFailAndNoWaitSet, CodeLockAcquireFailAndNoWait, CategoryConflict},
+	{ErrResolveLockTimeout, CodeResolveLockTimeout, CategoryTimeout},
+	{ErrLockWaitTimeout, CodeLockWaitTimeout, CategoryTimeout},
+	{ErrTiKVServerBusy, CodeTiKVServerBusy, CategoryThrottled},
+	{ErrTiFlashServerBusy, CodeTiFlashServerBusy, CategoryThrottled},
+	{ErrRegionUnavailable, CodeRegionUnavailable, CategoryRetryable},
+	{ErrRegionDataNotReady, CodeRegionDataNotReady, CategoryRetryable},
+	{ErrRegionNotInitialized, CodeRegionNotInitialized, CategoryRetryable},
+	{ErrTiKVDiskFull, CodeTiKVDiskFull, CategoryThrottled},
+	{ErrUnknown, CodeUnknown, CategoryFatal},
+	{ErrResultUndetermined, CodeResultUndetermined, CategoryUndetermined},
+	{ErrWriteInStaleReadTxn, CodeWriteInStaleReadTxn, CategoryFatal},
+	{context.DeadlineExceeded, CodeContextDeadlineExceeded, CategoryTimeout},
+	{context.Canceled, CodeContextCanceled, CategoryFatal},
+}
+
+// Classify returns the code and category of the error. It looks through the
+// wrapped errors, so the errors returned by this client can be classified no
+// matter how they are wrapped. Unclassified errors are CodeUnknown and CategoryFatal.
+func Classify(err error) (Code, Category) {
+	var ce ClassifiedError
+	if errors.As(err, &ce) {
+		return ce.Code(), ce.Category()
+	}
+	for _, c := range sentinelClasses {
+		if errors.Is(err, c.err) {
+			return c.code, c.category
+		}
+	}
+	return CodeUnknown, CategoryFatal
+}
+
+// GetCode returns the code of the error.
+func GetCode(err error) Code {
+	code, _ := Classify(err)
+	return code
+}
+
+// GetCategory returns the category of the error.
+func GetCategory(err error) Category {
+	_, category := Classify(err)
+	return category
+}
+
+// ErrLockWaitTimeoutDetail is the error that wait for the lock is timeout.
+// It carries the information of the lock that blocks the waiter. Its message is
+// the same as ErrLockWaitTimeout, and it matches ErrLockWaitTimeout with both
+// errors.Is and errors.Cause, so the callers comparing with the sentinel keep
+// working.
+type ErrLockWaitTimeoutDetail struct {
+	// Key is the key that the waiter is blocked on.
+	Key []byte
+	// Primary is the primary key of the blocker transaction.
+	Primary []byte
+	// BlockerStartTS is the start ts of the blocker transaction.
+	BlockerStartTS uint64
+	// WaitDuration is how long the waiter has waited.
+	WaitDuration time.Duration
+}
+
+func (e *ErrLockWaitTimeoutDetail) Error() string {
+	return ErrLockWaitTimeout.Error()
+}
+
+// Unwrap returns ErrLockWaitTimeout.
+func (e *ErrLockWaitTimeoutDetail) Unwrap() error {
+	return ErrLockWaitTimeout
+}
+
+// Cause returns ErrLockWaitTimeout, which is used by errors.Cause.
+func (e *ErrLockWaitTimeoutDetail) Cause() error {
+	return ErrLockWaitTimeout
+}
+
+// Code implements ClassifiedError.
+func (e *ErrLockWaitTimeoutDetail) Code() Code { return CodeLockWaitTimeout }
+
+// Category implements ClassifiedError.
+func (e *ErrLockWaitTimeoutDetail) Category() Category { return CategoryTimeout }
+
+// Code implements ClassifiedError.
+func (d *ErrDeadlock) Code() Code { return CodeDeadlock }
+
+// Category implements ClassifiedError.
+func (d *ErrDeadlock) Category() Category { return CategoryConflict }
+
+// Code implements ClassifiedError.
+func (d *PDError) Code() Code { return CodePDError }
+
+// Category implements ClassifiedError.
+func (d *PDError) Category() Category { return CategoryFatal }
+
+// Code implements ClassifiedError.
+func (k *ErrKeyExist) Code() Code { return CodeKeyExist }
+
+// Category implements ClassifiedError.
+func (k *ErrKeyExist) Category() Category { return CategoryFatal }
+
+// Code implements ClassifiedError.
+func (k *ErrWriteConflict) Code() Code { return CodeWriteConflict }
+
+// Category implements ClassifiedError.
+func (k *ErrWriteConflict) Category() Category { return CategoryConflict }
+
+// Code implements ClassifiedError.
+func (e *ErrWriteConflictInLatch) Code() Code { return CodeWriteConflictInLatch }
+
+// Category implements ClassifiedError.
+func (e *ErrWriteConflictInLatch) Category() Category { return CategoryConflict }
+
+// Code implements ClassifiedError.
+func (k *ErrRetryable) Code() Code { return CodeRetryable }
+
+// Category implements ClassifiedError.
+func (k *ErrRetryable) Category() Category { return CategoryRetryable }
+
+// Code implements ClassifiedError.
+func (e *ErrTxnTooLarge) Code() Code { return CodeTxnTooLarge }
+
+// Category implements ClassifiedError.
+func (e *ErrTxnTooLarge) Category() Category { return CategoryFatal }
+
+// Code implements ClassifiedError.
+func (e *ErrEntryTooLarge) Code() Code { return CodeEntryTooLarge }
+
+// Category implements ClassifiedError.
+func (e *ErrEntryTooLarge) Category() Category { return CategoryFatal }
+
+// Code implements ClassifiedError.
+func (e *ErrPDServerTimeout) Code() Code { return CodePDServerTimeout }
+
+// Category implements ClassifiedError.
+func (e *ErrPDServerTimeout) Category() Category { return CategoryTimeout }
+
+// Code implements ClassifiedError.
+func (e *ErrGCTooEarly) Code() Code { return CodeGCTooEarly }
+
+// Category implements ClassifiedError.
+func (e *ErrGCTooEarly) Category() Category { return CategoryFatal }
+
+// Code implements ClassifiedError.
+func (e *ErrTokenLimit) Code() Code { return CodeTokenLimit }
+
+// Category implements ClassifiedError.
+func (e *ErrTokenLimit) Category() Category { return CategoryThrottled }
+
+// Code implements ClassifiedError.
+func (e *ErrAssertionFailed) Code() Code { return CodeAssertionFailed }
+
+// Category implements ClassifiedError.
+func (e *ErrAssertionFailed) Category() Category { return CategoryFatal }
@@ -0,0 +1,67 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package error
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassify(t *testing.T) {
+	lockWaitErr := &ErrLockWaitTimeoutDetail{Key: []byte("k"), BlockerStartTS: 100, WaitDuration: time.Second}
+	cases := []struct {
+		err      error
+		code     Code
+		category Category
+	}{
+		{nil, CodeUnknown, CategoryFatal},
+		{errors.New("unknown"), CodeUnknown, CategoryFatal},
+		{ErrTiKVServerBusy, CodeTiKVServerBusy, CategoryThrottled},
+		{errors.WithStack(ErrRegionUnavailable), CodeRegionUnavailable, CategoryRetryable},
+		{errors.WithStack(ErrResultUndetermined), CodeResultUndetermined, CategoryUndetermined},
+		{errors.WithStack(lockWaitErr), CodeLockWaitTimeout, CategoryTimeout},
+		{errors.WithStack(ErrLockWaitTimeout), CodeLockWaitTimeout, CategoryTimeout},
+		{&ErrWriteConflict{WriteConflict: &kvrpcpb.WriteConflict{}}, CodeWriteConflict, CategoryConflict},
+		{errors.WithStack(&ErrDeadlock{Deadlock: &kvrpcpb.Deadlock{}}), CodeDeadlock, CategoryConflict},
+		{errors.WithMessage(&ErrTokenLimit{StoreID: 1}, "send request"), CodeTokenLimit, CategoryThrottled},
+		{fmt.Errorf("get: %w", &ErrKeyExist{AlreadyExist: &kvrpcpb.AlreadyExist{}}), CodeKeyExist, CategoryFatal},
+		{errors.WithStack(context.DeadlineExceeded), CodeContextDeadlineExceeded, CategoryTimeout},
+	}
+	for _, c := range cases {
+		code, category := Classify(c.err)
+		assert.Equal(t, c.code, code, "%v", c.err)
+		assert.Equal(t, c.category, category, "%v", c.err)
+	}
+	assert.Equal(t, CodeDeadlock, GetCode(&ErrDeadlock{Deadlock: &kvrpcpb.Deadlock{}}))
+	assert.Equal(t, CategoryRetryable, GetCategory(&ErrRetryable{Retryable: "retry"}))
+}
+
+func TestLockWaitTimeoutDetail(t *testing.T) {
+	err := errors.WithStack(&ErrLockWaitTimeoutDetail{Key: []byte("k"), Primary: []byte("p"), BlockerStartTS: 100, WaitDuration: time.Second})
+	assert.True(t, errors.Is(err, ErrLockWaitTimeout))
+	var detail *ErrLockWaitTimeoutDetail
+	assert.True(t, errors.As(err, &detail))
+	assert.Equal(t, []byte("k"), detail.Key)
+	assert.Equal(t, uint64(100), detail.BlockerStartTS)
+	assert.Equal(t, ErrLockWaitTimeout.Error(), err.Error())
+	assert.Equal(t, ErrLockWaitTimeout, errors.Cause(err))
+	assert.Equal(t, ErrLockWaitTimeout, errors.Unwrap(detail))
+}
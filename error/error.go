@@ -98,6 +98,8 @@ var (
 	ErrUnknown = errors.New("unknow")
 	// ErrResultUndetermined is the error when execution result is unknown.
 	ErrResultUndetermined = errors.New("execution result undetermined")
+	// ErrWriteInStaleReadTxn is the error when a stale read transaction tries to commit writes.
+	ErrWriteInStaleReadTxn = errors.New("write is not allowed in stale read transaction")
 )
 
 // MismatchClusterID represents the message that the cluster ID of the PD client does not match the PD.
@@ -110,6 +112,8 @@ func IsErrNotFound(err error) bool {
 
 // ErrDeadlock wraps *kvrpcpb.Deadlock to implement the error interface.
 // It also marks if the deadlock is retryable.
+// The WaitChain of the deadlock holds the wait-for relations that form the cycle,
+// including the start ts of the transactions, the keys and the resource group tags.
 type ErrDeadlock struct {
 	*kvrpcpb.Deadlock
 	IsRetryable bool
@@ -240,12 +244,15 @@ func (e *ErrTokenLimit) Error() string {
 }
 
 // ErrAssertionFailed is the error that assertion on data failed.
+// Key is the key whose assertion failed, and ExistingCommitTs is the commit ts of the
+// existing value which fails the assertion, it's 0 if the value does not exist or is unknown.
 type ErrAssertionFailed struct {
 	*kvrpcpb.AssertionFailed
 }
 
 func (e *ErrAssertionFailed) Error() string {
-	return fmt.Sprintf("assertion failed { %s }", e.AssertionFailed.String())
+	return fmt.Sprintf("assertion failed, key: %x, assertion: %s, start ts: %d, existing start ts: %d, existing commit ts: %d",
+		e.Key, e.Assertion, e.StartTs, e.ExistingStartTs, e.ExistingCommitTs)
 }
 
 // ExtractKeyErr extracts a KeyError.
@@ -266,7 +273,7 @@ func ExtractKeyErr(keyErr *kvrpcpb.KeyError) error {
 	}
 
 	if keyErr.AssertionFailed != nil {
-		return &ErrAssertionFailed{AssertionFailed: keyErr.AssertionFailed}
+		return errors.WithStack(&ErrAssertionFailed{AssertionFailed: keyErr.AssertionFailed})
 	}
 
 	if keyErr.Abort != "" {
@@ -18,29 +18,48 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 <<<<<<< HEAD
 	"github.com/JK1Zhang/client-go/v3/oracle"
 	"github.com/JK1Zhang/client-go/v3/tikv"
+	"github.com/JK1Zhang/client-go/v3/txnkv"
 =======
 	"github.com/tikv/client-go/v2/oracle"
 	"github.com/tikv/client-go/v2/tikv"
+	"github.com/tikv/client-go/v2/txnkv"
 >>>>>>> 7683491695d090758b4274eccd76d6c975704324
 )
 
 var (
 	pdAddr    = flag.String("pd", "127.0.0.1:2379", "pd address")
 	safepoint = flag.Uint64("safepoint", oracle.GoTimeToTS(time.Now().Add(-24*7*time.Hour)), "safepoint")
+	daemon    = flag.Bool("daemon", false, "run GC periodically until interrupted, the safepoint is decided by -life-time")
+	lifeTime  = flag.Duration("life-time", 10*time.Minute, "GC life time in daemon mode")
+	interval  = flag.Duration("interval", 10*time.Minute, "GC run interval in daemon mode")
 )
 
 func main() {
 	flag.Parse()
-	client, err := tikv.NewTxnClient([]string{*pdAddr})
+	client, err := txnkv.NewClient([]string{*pdAddr})
 	if err != nil {
 		panic(err)
 	}
 
+	if *daemon {
+		w := tikv.NewGCWorker(client.KVStore, tikv.WithGCLifeTime(*lifeTime), tikv.WithGCRunInterval(*interval))
+		w.Start()
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
+		w.Close()
+		fmt.Printf("GC worker stopped, status: %+v\n", w.Status())
+		return
+	}
+
 	sysSafepoint, err := client.GC(context.Background(), *safepoint)
 	if err != nil {
 		panic(err)
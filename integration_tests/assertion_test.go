@@ -296,3 +296,58 @@ func (s *testAssertionSuite) TestAssertionErrorLessPriorToOtherError() {
 	testOnce([]byte("kr3"), []byte("ki3"), true, true, false)
 	testOnce([]byte("kr4"), []byte("ki4"), true, false, true)
 }
+
+func (s *testAssertionSuite) TestSetWithAssertion() {
+	ctx := context.Background()
+	prepareTxn, err := s.store.Begin()
+	s.Nil(err)
+	s.Nil(prepareTxn.Set([]byte("swa_exist"), []byte("v0")))
+	s.Nil(prepareTxn.Commit(ctx))
+	prepareCommitTS := prepareTxn.GetCommitTS()
+
+	// The assertions are rejected if the assertion level is off.
+	txn, err := s.store.Begin()
+	s.Nil(err)
+	s.NotNil(txn.SetWithAssertion([]byte("swa_exist"), []byte("v1"), kv.SetAssertExist))
+	s.NotNil(txn.DeleteWithAssertion([]byte("swa_exist"), kv.SetAssertExist))
+	s.Nil(txn.Rollback())
+
+	// The assertions are satisfied.
+	txn, err = s.store.Begin()
+	s.Nil(err)
+	txn.SetAssertionLevel(kvrpcpb.AssertionLevel_Strict)
+	s.Nil(txn.SetWithAssertion([]byte("swa_exist"), []byte("v1"), kv.SetAssertExist))
+	s.Nil(txn.SetWithAssertion([]byte("swa_not_exist"), []byte("v1"), kv.SetAssertNotExist))
+	s.Nil(txn.Commit(ctx))
+
+	// Asserting not exist on an existing key.
+	txn, err = s.store.Begin()
+	s.Nil(err)
+	txn.SetAssertionLevel(kvrpcpb.AssertionLevel_Strict)
+	s.Nil(txn.SetWithAssertion([]byte("swa_exist"), []byte("v2"), kv.SetAssertNotExist))
+	err = txn.Commit(ctx)
+	assertionErr, ok := errors.Cause(err).(*tikverr.ErrAssertionFailed)
+	s.Require().True(ok)
+	s.Equal([]byte("swa_exist"), assertionErr.Key)
+	s.Equal(kvrpcpb.Assertion_NotExist, assertionErr.Assertion)
+	s.Greater(assertionErr.ExistingCommitTs, prepareCommitTS)
+
+	// Asserting exist on a missing key.
+	txn, err = s.store.Begin()
+	s.Nil(err)
+	txn.SetAssertionLevel(kvrpcpb.AssertionLevel_Strict)
+	s.Nil(txn.DeleteWithAssertion([]byte("swa_missing"), kv.SetAssertExist))
+	err = txn.Commit(ctx)
+	assertionErr, ok = errors.Cause(err).(*tikverr.ErrAssertionFailed)
+	s.Require().True(ok)
+	s.Equal([]byte("swa_missing"), assertionErr.Key)
+	s.Equal(kvrpcpb.Assertion_Exist, assertionErr.Assertion)
+	s.Zero(assertionErr.ExistingCommitTs)
+
+	// Only the existence assertions are accepted.
+	txn, err = s.store.Begin()
+	s.Nil(err)
+	txn.SetAssertionLevel(kvrpcpb.AssertionLevel_Strict)
+	s.NotNil(txn.SetWithAssertion([]byte("swa_exist"), []byte("v3"), kv.SetPresumeKeyNotExists))
+	s.Nil(txn.Rollback())
+}
@@ -0,0 +1,177 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/JK1Zhang/client-go/v3/oracle"
+	"github.com/JK1Zhang/client-go/v3/rawkv"
+	"github.com/JK1Zhang/client-go/v3/testutils"
+	"github.com/JK1Zhang/client-go/v3/tikv"
+	"github.com/JK1Zhang/client-go/v3/txnkv"
+	"github.com/JK1Zhang/client-go/v3/txnkv/backup"
+	"github.com/stretchr/testify/suite"
+)
+
+const backupRowNum = 500
+
+func TestBackup(t *testing.T) {
+	suite.Run(t, new(testBackupSuite))
+}
+
+type testBackupSuite struct {
+	suite.Suite
+	cluster testutils.Cluster
+	store   tikv.StoreProbe
+	raw     rawkv.ClientProbe
+}
+
+func (s *testBackupSuite) SetupTest() {
+	client, cluster, pdClient, err := testutils.NewMockTiKV("", nil)
+	s.Require().Nil(err)
+	testutils.BootstrapWithSingleStore(cluster)
+	s.cluster = cluster
+	store, err := tikv.NewTestTiKVStore(client, pdClient, nil, nil, 0)
+	s.Require().Nil(err)
+	s.store = tikv.StoreProbe{KVStore: store}
+	s.raw = rawkv.ClientProbe{Client: &rawkv.Client{}}
+	s.raw.SetPDClient(pdClient)
+	s.raw.SetRegionCache(tikv.NewRegionCache(pdClient))
+	s.raw.SetRPCClient(client)
+
+	txn, err := s.store.Begin()
+	s.Require().Nil(err)
+	for i := 0; i < backupRowNum; i++ {
+		s.Require().Nil(txn.Set(s.makeKey(i), s.makeValue(i, "v1")))
+	}
+	s.Require().Nil(txn.Commit(context.Background()))
+
+	bo := tikv.NewBackofferWithVars(context.Background(), 5000, nil)
+	for _, i := range []int{100, 250, 400} {
+		loc, err := s.store.GetRegionCache().LocateKey(bo, s.makeKey(i))
+		s.Require().Nil(err)
+		newRegionID, peerID := s.cluster.AllocID(), s.cluster.AllocID()
+		s.cluster.Split(loc.Region.GetID(), newRegionID, s.makeKey(i), []uint64{peerID}, peerID)
+		s.store.GetRegionCache().InvalidateCachedRegion(loc.Region)
+	}
+}
+
+func (s *testBackupSuite) TearDownTest() {
+	s.raw.GetRegionCache().Close()
+	s.Require().Nil(s.store.Close())
+}
+
+func (s *testBackupSuite) makeKey(i int) []byte {
+	return []byte(fmt.Sprintf("backup_%05d", i))
+}
+
+func (s *testBackupSuite) makeValue(i int, version string) []byte {
+	return []byte(fmt.Sprintf("%s_%d", version, i))
+}
+
+func (s *testBackupSuite) TestBackupRestore() {
+	ctx := context.Background()
+	ts, err := s.store.CurrentTimestamp(oracle.GlobalTxnScope)
+	s.Require().Nil(err)
+
+	// Overwrite the rows after the snapshot ts, the backup should not see them.
+	txn, err := s.store.Begin()
+	s.Require().Nil(err)
+	for i := 0; i < backupRowNum; i++ {
+		s.Require().Nil(txn.Set(s.makeKey(i), s.makeValue(i, "v2")))
+	}
+	s.Require().Nil(txn.Commit(ctx))
+
+	dir := s.T().TempDir()
+	m, err := backup.Backup(ctx, s.store.KVStore, dir, s.makeKey(50), s.makeKey(450),
+		backup.WithSnapshotTS(ts), backup.WithScanConcurrency(3), backup.WithChunkSize(64, math.MaxInt32))
+	s.Require().Nil(err)
+	s.Equal(ts, m.SnapshotTS)
+	s.Equal(int64(400), m.TotalKVs)
+	s.Len(m.Chunks, 7)
+	for i, f := range m.Chunks {
+		s.Equal(s.makeKey(50+i*64), f.FirstKey)
+	}
+	m2, err := backup.ReadManifest(dir)
+	s.Require().Nil(err)
+	s.Equal(m, m2)
+
+	// The service safepoint is removed after the backup.
+	minSafePoint, err := s.store.GetPDClient().UpdateServiceGCSafePoint(ctx, "probe", 1, 0)
+	s.Nil(err)
+	s.Zero(minSafePoint)
+
+	_, err = backup.Backup(ctx, s.store.KVStore, dir, nil, nil)
+	s.NotNil(err)
+
+	_, err = backup.Restore(ctx, s.store.KVStore, dir, txnkv.WithBulkMaxKeys(32))
+	s.Require().Nil(err)
+	txn, err = s.store.Begin()
+	s.Require().Nil(err)
+	for i := 0; i < backupRowNum; i++ {
+		v, err := txn.Get(ctx, s.makeKey(i))
+		s.Require().Nil(err)
+		if i >= 50 && i < 450 {
+			s.Equal(s.makeValue(i, "v1"), v)
+		} else {
+			s.Equal(s.makeValue(i, "v2"), v)
+		}
+	}
+
+	_, err = backup.RestoreRaw(ctx, s.raw.Client, dir, 100)
+	s.Require().Nil(err)
+	for _, i := range []int{49, 50, 200, 449, 450} {
+		v, err := s.raw.Get(ctx, s.makeKey(i))
+		s.Nil(err)
+		if i >= 50 && i < 450 {
+			s.Equal(s.makeValue(i, "v1"), v)
+		} else {
+			s.Nil(v)
+		}
+	}
+}
+
+func (s *testBackupSuite) TestCorruptedChunk() {
+	ctx := context.Background()
+	dir := s.T().TempDir()
+	m, err := backup.Backup(ctx, s.store.KVStore, dir, nil, nil, backup.WithChunkSize(100, math.MaxInt32))
+	s.Require().Nil(err)
+	s.Len(m.Chunks, 5)
+
+	path := filepath.Join(dir, m.Chunks[3].Name)
+	data, err := ioutil.ReadFile(path)
+	s.Require().Nil(err)
+	data[len(data)/2] ^= 0xff
+	s.Require().Nil(ioutil.WriteFile(path, data, 0644))
+	_, err = backup.RestoreRaw(ctx, s.raw.Client, dir, 0)
+	s.NotNil(err)
+	s.Contains(err.Error(), "checksum mismatch")
+}
+
+func (s *testBackupSuite) TestGCTooEarly() {
+	ctx := context.Background()
+	ts, err := s.store.CurrentTimestamp(oracle.GlobalTxnScope)
+	s.Require().Nil(err)
+	_, err = s.store.GetPDClient().UpdateServiceGCSafePoint(ctx, "gc_worker", 100, ts+1)
+	s.Require().Nil(err)
+	_, err = backup.Backup(ctx, s.store.KVStore, s.T().TempDir(), nil, nil, backup.WithSnapshotTS(ts))
+	s.NotNil(err)
+}
@@ -0,0 +1,244 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/JK1Zhang/client-go/v3/tikv"
+	"github.com/JK1Zhang/client-go/v3/txnkv/binlog"
+	"github.com/JK1Zhang/client-go/v3/txnkv/transaction"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestFileBinlog(t *testing.T) {
+	suite.Run(t, new(testFileBinlogSuite))
+}
+
+type testFileBinlogSuite struct {
+	suite.Suite
+	store tikv.StoreProbe
+	dir   string
+	log   *binlog.FileLog
+}
+
+func (s *testFileBinlogSuite) SetupTest() {
+	s.store = tikv.StoreProbe{KVStore: NewTestStore(s.T())}
+	s.dir = s.T().TempDir()
+	var err error
+	s.log, err = binlog.OpenFileLog(s.dir, binlog.WithMaxSegmentSize(256))
+	s.Require().Nil(err)
+}
+
+func (s *testFileBinlogSuite) TearDownTest() {
+	s.Nil(s.log.Close())
+	s.store.Close()
+}
+
+func (s *testFileBinlogSuite) begin() transaction.TxnProbe {
+	txn, err := s.store.Begin()
+	s.Require().Nil(err)
+	s.log.Attach(txn.KVTxn)
+	return txn
+}
+
+func (s *testFileBinlogSuite) readCommitted(afterTS uint64) []*binlog.Txn {
+	txns, err := binlog.ReadCommitted(s.dir, afterTS)
+	s.Require().Nil(err)
+	return txns
+}
+
+func (s *testFileBinlogSuite) TestCommitted() {
+	ctx := context.Background()
+	var commitTSs []uint64
+	for i := 0; i < 10; i++ {
+		txn := s.begin()
+		s.Nil(txn.Set([]byte(fmt.Sprintf("binlog_%d", i)), []byte(fmt.Sprintf("v%d", i))))
+		s.Nil(txn.Delete([]byte(fmt.Sprintf("binlog_del_%d", i))))
+		s.Nil(txn.Commit(ctx))
+		commitTSs = append(commitTSs, txn.GetCommitTS())
+	}
+
+	// A rolled back transaction and a read-only transaction are not returned.
+	txn := s.begin()
+	s.Nil(txn.Set([]byte("binlog_rollback"), []byte("v")))
+	s.Nil(txn.Rollback())
+	txn = s.begin()
+	s.Nil(txn.LockKeysWithWaitTime(ctx, 0, []byte("binlog_0")))
+	s.Nil(txn.Commit(ctx))
+
+	txns := s.readCommitted(0)
+	s.Require().Len(txns, 10)
+	for i, t := range txns {
+		s.Equal(commitTSs[i], t.CommitTS)
+		s.Less(t.StartTS, t.CommitTS)
+		s.Len(t.Mutations, 2)
+		for _, m := range t.Mutations {
+			if m.Op == kvrpcpb.Op_Put {
+				s.Equal([]byte(fmt.Sprintf("binlog_%d", i)), m.Key)
+				s.Equal([]byte(fmt.Sprintf("v%d", i)), m.Value)
+			} else {
+				s.Equal(kvrpcpb.Op_Del, m.Op)
+				s.Equal([]byte(fmt.Sprintf("binlog_del_%d", i)), m.Key)
+			}
+		}
+	}
+	s.Len(s.readCommitted(commitTSs[6]), 3)
+
+	files, err := ioutil.ReadDir(s.dir)
+	s.Nil(err)
+	s.Greater(len(files), 1)
+}
+
+func (s *testFileBinlogSuite) TestPendingTxn() {
+	ctx := context.Background()
+	pending := s.begin()
+	s.Nil(pending.Set([]byte("binlog_pending"), []byte("v")))
+	prewritten, resume := make(chan struct{}), make(chan struct{})
+	pending.AddPostPrewriteHook(func(context.Context, uint64, transaction.CommitterMutations) {
+		close(prewritten)
+		<-resume
+	})
+	done := make(chan error)
+	go func() {
+		done <- pending.Commit(ctx)
+	}()
+	<-prewritten
+
+	txn := s.begin()
+	s.Nil(txn.Set([]byte("binlog_later"), []byte("v")))
+	s.Nil(txn.Commit(ctx))
+	// The later transaction is held back by the pending one.
+	s.Empty(s.readCommitted(0))
+
+	close(resume)
+	s.Nil(<-done)
+	txns := s.readCommitted(0)
+	s.Require().Len(txns, 2)
+	s.Equal(txn.GetCommitTS(), txns[0].CommitTS)
+	s.Equal(pending.GetCommitTS(), txns[1].CommitTS)
+}
+
+// pausePrewritten commits the transaction in the background and waits until it's
+// prewritten, the commit continues after resume is called.
+func (s *testFileBinlogSuite) pausePrewritten(txn transaction.TxnProbe) (resume func(), done <-chan error) {
+	prewritten, resumeCh := make(chan struct{}), make(chan struct{})
+	txn.AddPostPrewriteHook(func(context.Context, uint64, transaction.CommitterMutations) {
+		close(prewritten)
+		<-resumeCh
+	})
+	doneCh := make(chan error, 1)
+	go func() {
+		doneCh <- txn.Commit(context.Background())
+	}()
+	<-prewritten
+	return func() { close(resumeCh) }, doneCh
+}
+
+func (s *testFileBinlogSuite) TestOrphanPrewrite() {
+	ctx := context.Background()
+	pending := s.begin()
+	s.Nil(pending.Set([]byte("binlog_orphan"), []byte("v")))
+	resume, done := s.pausePrewritten(pending)
+
+	txn := s.begin()
+	s.Nil(txn.Set([]byte("binlog_later"), []byte("v")))
+	s.Nil(txn.Commit(ctx))
+	s.Empty(s.readCommitted(0))
+
+	// The process crashes before the pending transaction finishes, it's rolled
+	// back when the log is opened again.
+	s.Nil(s.log.Close())
+	var err error
+	s.log, err = binlog.OpenFileLog(s.dir)
+	s.Require().Nil(err)
+	txns := s.readCommitted(0)
+	s.Require().Len(txns, 1)
+	s.Equal(txn.GetCommitTS(), txns[0].CommitTS)
+
+	resume()
+	s.Nil(<-done)
+	s.Len(s.readCommitted(0), 1)
+}
+
+func (s *testFileBinlogSuite) TestKeepPendingSegment() {
+	ctx := context.Background()
+	s.Nil(s.log.Close())
+	var err error
+	s.log, err = binlog.OpenFileLog(s.dir, binlog.WithMaxSegmentSize(128), binlog.WithMaxSegments(2))
+	s.Require().Nil(err)
+
+	pending := s.begin()
+	s.Nil(pending.Set([]byte("binlog_pending"), []byte("v")))
+	resume, done := s.pausePrewritten(pending)
+	for i := 0; i < 10; i++ {
+		txn := s.begin()
+		s.Nil(txn.Set([]byte(fmt.Sprintf("binlog_%d", i)), []byte("v")))
+		s.Nil(txn.Commit(ctx))
+	}
+	// The file of the pending prewrite and the files after it are kept.
+	files, err := ioutil.ReadDir(s.dir)
+	s.Nil(err)
+	s.Greater(len(files), 2)
+
+	resume()
+	s.Nil(<-done)
+	txns := s.readCommitted(0)
+	s.Require().Len(txns, 11)
+	s.Equal(pending.GetCommitTS(), txns[10].CommitTS)
+
+	// The files are removed once the transaction finishes.
+	for i := 0; i < 3; i++ {
+		txn := s.begin()
+		s.Nil(txn.Set([]byte(fmt.Sprintf("binlog_%d", i)), []byte("v")))
+		s.Nil(txn.Commit(ctx))
+	}
+	files, err = ioutil.ReadDir(s.dir)
+	s.Nil(err)
+	s.Len(files, 2)
+}
+
+func (s *testFileBinlogSuite) TestTornRecord() {
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		txn := s.begin()
+		s.Nil(txn.Set([]byte(fmt.Sprintf("binlog_%d", i)), []byte("v")))
+		s.Nil(txn.Commit(ctx))
+	}
+	s.Nil(s.log.Close())
+
+	// Append half of a record to the last file as if the process crashed.
+	files, err := ioutil.ReadDir(s.dir)
+	s.Require().Nil(err)
+	f, err := os.OpenFile(filepath.Join(s.dir, files[len(files)-1].Name()), os.O_WRONLY|os.O_APPEND, 0644)
+	s.Require().Nil(err)
+	_, err = f.Write([]byte{1, 2, 3, 4, 100, 0})
+	s.Nil(err)
+	s.Nil(f.Close())
+	s.Len(s.readCommitted(0), 2)
+
+	s.log, err = binlog.OpenFileLog(s.dir)
+	s.Require().Nil(err)
+	txn := s.begin()
+	s.Nil(txn.Set([]byte("binlog_2"), []byte("v")))
+	s.Nil(txn.Commit(ctx))
+	s.Len(s.readCommitted(0), 3)
+}
@@ -0,0 +1,252 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	tikverr "github.com/JK1Zhang/client-go/v3/error"
+	"github.com/JK1Zhang/client-go/v3/testutils"
+	"github.com/JK1Zhang/client-go/v3/tikv"
+	"github.com/JK1Zhang/client-go/v3/tikvrpc"
+	"github.com/JK1Zhang/client-go/v3/txnkv"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestBulkWriter(t *testing.T) {
+	suite.Run(t, new(testBulkWriterSuite))
+}
+
+type testBulkWriterSuite struct {
+	suite.Suite
+	store tikv.StoreProbe
+
+	mu struct {
+		sync.Mutex
+		// conflictKey fails the prewrites writing it with a write conflict, for
+		// conflicts times, or always if conflicts is negative.
+		conflictKey   []byte
+		conflicts     int
+		prewriteDelay time.Duration
+	}
+}
+
+// bulkPrewriteClient injects write conflicts and delays into the prewrites.
+type bulkPrewriteClient struct {
+	tikv.Client
+	s *testBulkWriterSuite
+}
+
+func (c bulkPrewriteClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	if req.Type == tikvrpc.CmdPrewrite {
+		if resp := c.s.onPrewrite(req.Prewrite()); resp != nil {
+			return &tikvrpc.Response{Resp: resp}, nil
+		}
+	}
+	return c.Client.SendRequest(ctx, addr, req, timeout)
+}
+
+func (s *testBulkWriterSuite) SetupTest() {
+	client, cluster, pdClient, err := testutils.NewMockTiKV("", nil)
+	s.Require().Nil(err)
+	testutils.BootstrapWithSingleStore(cluster)
+	hijack := func(c tikv.Client) tikv.Client {
+		return bulkPrewriteClient{Client: c, s: s}
+	}
+	store, err := tikv.NewTestTiKVStore(client, pdClient, hijack, nil, 0)
+	s.Require().Nil(err)
+	s.store = tikv.StoreProbe{KVStore: store}
+	s.injectConflict(nil, 0)
+	s.setPrewriteDelay(0)
+}
+
+func (s *testBulkWriterSuite) injectConflict(key []byte, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.conflictKey, s.mu.conflicts = key, times
+}
+
+func (s *testBulkWriterSuite) setPrewriteDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.prewriteDelay = d
+}
+
+func (s *testBulkWriterSuite) onPrewrite(req *kvrpcpb.PrewriteRequest) *kvrpcpb.PrewriteResponse {
+	s.mu.Lock()
+	delay := s.mu.prewriteDelay
+	var conflict bool
+	if s.mu.conflictKey != nil && s.mu.conflicts != 0 {
+		for _, m := range req.Mutations {
+			if bytes.Equal(m.Key, s.mu.conflictKey) {
+				conflict = true
+				s.mu.conflicts--
+				break
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	time.Sleep(delay)
+	if !conflict {
+		return nil
+	}
+	return &kvrpcpb.PrewriteResponse{Errors: []*kvrpcpb.KeyError{{
+		Conflict: &kvrpcpb.WriteConflict{StartTs: req.StartVersion, Key: s.mu.conflictKey, Primary: req.PrimaryLock},
+	}}}
+}
+
+func (s *testBulkWriterSuite) TearDownTest() {
+	s.store.Close()
+}
+
+func (s *testBulkWriterSuite) makeKey(i int) []byte {
+	return []byte(fmt.Sprintf("bulk_%05d", i))
+}
+
+func (s *testBulkWriterSuite) checkValues(from, to int, value func(i int) []byte) {
+	txn, err := s.store.Begin()
+	s.Require().Nil(err)
+	for i := from; i < to; i++ {
+		v, err := txn.Get(context.Background(), s.makeKey(i))
+		if value(i) == nil {
+			s.True(tikverr.IsErrNotFound(err), "key %d", i)
+			continue
+		}
+		s.Require().Nil(err, "key %d", i)
+		s.Equal(value(i), v)
+	}
+}
+
+func (s *testBulkWriterSuite) TestWrite() {
+	ctx := context.Background()
+	var (
+		mu     sync.Mutex
+		chunks []txnkv.BulkChunk
+		last   txnkv.BulkCheckpoint
+	)
+	w := txnkv.NewBulkWriter(s.store.KVStore,
+		txnkv.WithBulkMaxKeys(64),
+		txnkv.WithBulkMaxBytes(1024),
+		txnkv.WithBulkConcurrency(4),
+		txnkv.WithBulkCommitCallback(func(chunk txnkv.BulkChunk, checkpoint txnkv.BulkCheckpoint) {
+			mu.Lock()
+			defer mu.Unlock()
+			chunks = append(chunks, chunk)
+			s.GreaterOrEqual(checkpoint.Offset, last.Offset)
+			last = checkpoint
+		}))
+	for i := 0; i < 1000; i++ {
+		s.Require().Nil(w.Set(ctx, s.makeKey(i), []byte(fmt.Sprintf("v%d", i))))
+	}
+	// Flush before rewriting the keys, chunks are not ordered when committed concurrently.
+	s.Require().Nil(w.Flush(ctx))
+	for i := 0; i < 1000; i += 3 {
+		s.Require().Nil(w.Delete(ctx, s.makeKey(i)))
+	}
+	s.NotNil(w.Set(ctx, s.makeKey(0), nil))
+	s.Require().Nil(w.Close(ctx))
+	s.NotNil(w.Set(ctx, s.makeKey(0), []byte("v")))
+
+	s.checkValues(0, 1000, func(i int) []byte {
+		if i%3 == 0 {
+			return nil
+		}
+		return []byte(fmt.Sprintf("v%d", i))
+	})
+
+	total := 1000 + 334
+	cp := w.Checkpoint()
+	s.Equal(uint64(total), cp.Offset)
+	s.Equal(uint64(len(chunks)), cp.Chunks)
+	s.Equal(last, cp)
+	count := 0
+	for _, chunk := range chunks {
+		s.LessOrEqual(chunk.Count, 64)
+		s.Less(chunk.Bytes, 1024+32)
+		s.NotZero(chunk.CommitTS)
+		count += chunk.Count
+	}
+	s.Equal(total, count)
+}
+
+func (s *testBulkWriterSuite) TestRetryConflict() {
+	// The first key of the third chunk conflicts once.
+	s.injectConflict(s.makeKey(20), 1)
+
+	ctx := context.Background()
+	w := txnkv.NewBulkWriter(s.store.KVStore, txnkv.WithBulkMaxKeys(10))
+	for i := 0; i < 100; i++ {
+		s.Require().Nil(w.Set(ctx, s.makeKey(i), []byte("v")))
+	}
+	s.Require().Nil(w.Flush(ctx))
+	s.Equal(uint64(100), w.Checkpoint().Offset)
+	s.checkValues(0, 100, func(int) []byte { return []byte("v") })
+}
+
+func (s *testBulkWriterSuite) TestResume() {
+	// The fourth chunk always conflicts.
+	s.injectConflict(s.makeKey(30), -1)
+	ctx := context.Background()
+	w := txnkv.NewBulkWriter(s.store.KVStore, txnkv.WithBulkMaxKeys(10), txnkv.WithBulkConcurrency(1), txnkv.WithBulkMaxRetries(1))
+	var err error
+	for i := 0; i < 100 && err == nil; i++ {
+		err = w.Set(ctx, s.makeKey(i), []byte("v1"))
+	}
+	if err == nil {
+		err = w.Flush(ctx)
+	}
+	s.NotNil(err)
+	s.Equal(tikverr.CategoryConflict, tikverr.GetCategory(err))
+	s.injectConflict(nil, 0)
+
+	// The chunks before the failed one are committed.
+	cp := w.Checkpoint()
+	s.Equal(uint64(30), cp.Offset)
+	s.Equal(s.makeKey(29), cp.LastKey)
+
+	w = txnkv.NewBulkWriter(s.store.KVStore, txnkv.WithBulkMaxKeys(10), txnkv.WithBulkStartOffset(cp.Offset))
+	for i := int(cp.Offset); i < 100; i++ {
+		s.Require().Nil(w.Set(ctx, s.makeKey(i), []byte("v2")))
+	}
+	s.Require().Nil(w.Close(ctx))
+	s.Equal(uint64(100), w.Checkpoint().Offset)
+	s.checkValues(0, 100, func(i int) []byte {
+		if i < 30 {
+			return []byte("v1")
+		}
+		return []byte("v2")
+	})
+}
+
+func (s *testBulkWriterSuite) TestCancelWrite() {
+	s.setPrewriteDelay(100 * time.Millisecond)
+	w := txnkv.NewBulkWriter(s.store.KVStore, txnkv.WithBulkMaxKeys(10))
+	ctx, cancel := context.WithCancel(context.Background())
+	for i := 0; i < 10; i++ {
+		s.Require().Nil(w.Set(ctx, s.makeKey(i), []byte("v")))
+	}
+	// The chunk submitted by the last Set is committed with the ctx of the writer.
+	cancel()
+	s.Require().Nil(w.Close(context.Background()))
+	s.Equal(uint64(10), w.Checkpoint().Offset)
+	s.checkValues(0, 10, func(int) []byte { return []byte("v") })
+}
@@ -0,0 +1,130 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	tikverr "github.com/JK1Zhang/client-go/v3/error"
+	"github.com/JK1Zhang/client-go/v3/kv"
+	"github.com/JK1Zhang/client-go/v3/txnkv/transaction"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestCommitHook(t *testing.T) {
+	suite.Run(t, new(testCommitHookSuite))
+}
+
+type testCommitHookSuite struct {
+	testAsyncCommitCommon
+}
+
+func (s *testCommitHookSuite) SetupTest() {
+	s.testAsyncCommitCommon.setUpTest()
+}
+
+func (s *testCommitHookSuite) TearDownTest() {
+	s.testAsyncCommitCommon.tearDownTest()
+}
+
+func (s *testCommitHookSuite) TestPrePrewriteVeto() {
+	errVeto := errors.New("veto")
+	for _, pessimistic := range []bool{false, true} {
+		key := []byte("veto_key")
+		txn := s.begin()
+		txn.SetPessimistic(pessimistic)
+		if pessimistic {
+			lockCtx := &kv.LockCtx{ForUpdateTS: txn.StartTS(), WaitStartTime: time.Now()}
+			s.Nil(txn.LockKeys(context.Background(), lockCtx, key))
+		}
+		s.Nil(txn.Set(key, []byte("v")))
+		var postCalled bool
+		txn.AddPrePrewriteHook(func(ctx context.Context, startTS uint64, mutations transaction.CommitterMutations) error {
+			s.Equal(txn.StartTS(), startTS)
+			s.Equal(1, mutations.Len())
+			s.Equal(key, mutations.GetKey(0))
+			return errVeto
+		})
+		txn.AddPostPrewriteHook(func(context.Context, uint64, transaction.CommitterMutations) { postCalled = true })
+		txn.AddPostCommitHook(func(context.Context, transaction.CommitInfo) { postCalled = true })
+		err := txn.Commit(context.Background())
+		s.True(errors.Is(err, errVeto))
+		s.False(postCalled)
+
+		// Nothing is written and the key is not locked.
+		txn2 := s.begin()
+		_, err = txn2.Get(context.Background(), key)
+		s.True(errors.Is(err, tikverr.ErrNotExist))
+		s.Nil(txn2.Set(key, []byte("v2")))
+		s.Nil(txn2.Commit(context.Background()))
+		s.mustPointGet(key, []byte("v2"))
+
+		txn3 := s.begin()
+		s.Nil(txn3.Delete(key))
+		s.Nil(txn3.Commit(context.Background()))
+	}
+}
+
+func (s *testCommitHookSuite) TestHooks() {
+	for _, protocol := range []transaction.CommitProtocol{transaction.CommitProtocol2PC, transaction.CommitProtocolAsyncCommit, transaction.CommitProtocol1PC} {
+		var txn transaction.TxnProbe
+		switch protocol {
+		case transaction.CommitProtocol2PC:
+			txn = s.begin()
+		case transaction.CommitProtocolAsyncCommit:
+			txn = s.beginAsyncCommit()
+		case transaction.CommitProtocol1PC:
+			txn = s.begin1PC()
+		}
+		k1, k2 := []byte("hook_k1_"+protocol.String()), []byte("hook_k2_"+protocol.String())
+		s.Nil(txn.Set(k1, []byte("v1")))
+		s.Nil(txn.Delete(k2))
+
+		var calls []string
+		txn.AddPrePrewriteHook(func(ctx context.Context, startTS uint64, mutations transaction.CommitterMutations) error {
+			calls = append(calls, "pre-prewrite-1")
+			return nil
+		})
+		txn.AddPrePrewriteHook(func(ctx context.Context, startTS uint64, mutations transaction.CommitterMutations) error {
+			calls = append(calls, "pre-prewrite-2")
+			return nil
+		})
+		txn.AddPostPrewriteHook(func(ctx context.Context, startTS uint64, mutations transaction.CommitterMutations) {
+			calls = append(calls, "post-prewrite")
+			s.Equal(2, mutations.Len())
+		})
+		var info transaction.CommitInfo
+		txn.AddPostCommitHook(func(ctx context.Context, i transaction.CommitInfo) {
+			calls = append(calls, "post-commit")
+			info = i
+		})
+		s.Nil(txn.Commit(context.Background()))
+
+		s.Equal([]string{"pre-prewrite-1", "pre-prewrite-2", "post-prewrite", "post-commit"}, calls)
+		s.Equal(txn.StartTS(), info.StartTS)
+		s.Equal(txn.GetCommitTS(), info.CommitTS)
+		s.Equal(protocol, info.Protocol)
+		s.Require().Equal(2, info.Mutations.Len())
+		values := make(map[string][]byte)
+		for i := 0; i < info.Mutations.Len(); i++ {
+			values[string(info.Mutations.GetKey(i))] = info.Mutations.GetValue(i)
+		}
+		s.Equal([]byte("v1"), values[string(k1)])
+		s.Empty(values[string(k2)])
+	}
+}
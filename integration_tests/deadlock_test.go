@@ -0,0 +1,96 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	tikverr "github.com/JK1Zhang/client-go/v3/error"
+	"github.com/JK1Zhang/client-go/v3/kv"
+	"github.com/JK1Zhang/client-go/v3/tikv"
+	"github.com/JK1Zhang/client-go/v3/txnkv/transaction"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestDeadlock(t *testing.T) {
+	suite.Run(t, new(testDeadlockSuite))
+}
+
+type testDeadlockSuite struct {
+	suite.Suite
+	store tikv.StoreProbe
+}
+
+func (s *testDeadlockSuite) SetupTest() {
+	s.store = tikv.StoreProbe{KVStore: NewTestStore(s.T())}
+}
+
+func (s *testDeadlockSuite) TearDownTest() {
+	s.store.Close()
+}
+
+func (s *testDeadlockSuite) begin(key []byte) transaction.TxnProbe {
+	txn, err := s.store.Begin()
+	s.Require().Nil(err)
+	txn.SetPessimistic(true)
+	s.Require().Nil(txn.LockKeys(context.Background(), s.lockCtx(txn, "init"), key))
+	return txn
+}
+
+func (s *testDeadlockSuite) lockCtx(txn transaction.TxnProbe, tag string) *kv.LockCtx {
+	lockCtx := kv.NewLockCtx(txn.StartTS(), 2000, time.Now())
+	lockCtx.ResourceGroupTag = []byte(tag)
+	return lockCtx
+}
+
+func (s *testDeadlockSuite) TestWaitChain() {
+	k1, k2 := []byte("k1"), []byte("k2")
+	txn1, txn2 := s.begin(k1), s.begin(k2)
+
+	// txn1 waits for txn2 on k2.
+	waitDone := make(chan error, 1)
+	go func() {
+		waitDone <- txn1.LockKeys(context.Background(), s.lockCtx(txn1, "tag-1"), k2)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// txn2 waits for txn1 on k1, which forms a deadlock.
+	err := txn2.LockKeys(context.Background(), s.lockCtx(txn2, "tag-2"), k1)
+	var dl *tikverr.ErrDeadlock
+	s.Require().True(errors.As(err, &dl), "%v", err)
+	s.Equal(tikverr.CodeDeadlock, tikverr.GetCode(err))
+	waitChain := dl.GetWaitChain()
+	s.Require().Len(waitChain, 2)
+	s.Equal(txn1.StartTS(), waitChain[0].Txn)
+	s.Equal(txn2.StartTS(), waitChain[0].WaitForTxn)
+	s.Equal(k2, waitChain[0].Key)
+	s.Equal([]byte("tag-1"), waitChain[0].ResourceGroupTag)
+	s.Equal(txn2.StartTS(), waitChain[1].Txn)
+	s.Equal(txn1.StartTS(), waitChain[1].WaitForTxn)
+	s.Equal(k1, waitChain[1].Key)
+	s.Equal([]byte("tag-2"), waitChain[1].ResourceGroupTag)
+
+	// txn1 stops waiting once txn2 is rolled back.
+	s.Nil(txn2.Rollback())
+	select {
+	case <-waitDone:
+	case <-time.After(5 * time.Second):
+		s.Fail("txn1 is still waiting for the lock")
+	}
+	s.Nil(txn1.Rollback())
+}
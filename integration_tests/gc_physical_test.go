@@ -0,0 +1,183 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/JK1Zhang/client-go/v3/testutils"
+	"github.com/JK1Zhang/client-go/v3/tikv"
+	"github.com/JK1Zhang/client-go/v3/tikvrpc"
+	"github.com/JK1Zhang/client-go/v3/txnkv/transaction"
+	"github.com/pingcap/failpoint"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/stretchr/testify/suite"
+)
+
+// longLockTTL keeps the prewritten locks alive for the whole test.
+const longLockTTL = uint64(time.Hour / time.Millisecond)
+
+func TestGCPhysical(t *testing.T) {
+	suite.Run(t, new(testGCPhysicalSuite))
+}
+
+type testGCPhysicalSuite struct {
+	suite.Suite
+	cluster  *testutils.MockCluster
+	store    tikv.StoreProbe
+	storeIDs []uint64
+}
+
+func (s *testGCPhysicalSuite) SetupTest() {
+	client, cluster, pdClient, err := testutils.NewMockTiKV("", nil)
+	s.Require().Nil(err)
+	storeIDs, _, regionID, _ := testutils.BootstrapWithMultiStores(cluster, 3)
+	newRegionID, newPeerIDs := cluster.AllocID(), cluster.AllocIDs(3)
+	cluster.Split(regionID, newRegionID, []byte("m"), newPeerIDs, newPeerIDs[0])
+	s.cluster = cluster
+	s.storeIDs = storeIDs
+	store, err := tikv.NewTestTiKVStore(client, pdClient, nil, nil, 0)
+	s.Require().Nil(err)
+	s.store = tikv.StoreProbe{KVStore: store}
+}
+
+func (s *testGCPhysicalSuite) TearDownTest() {
+	s.store.Close()
+}
+
+func (s *testGCPhysicalSuite) begin() transaction.TxnProbe {
+	txn, err := s.store.Begin()
+	s.Require().Nil(err)
+	return txn
+}
+
+func (s *testGCPhysicalSuite) send(storeID uint64, req *tikvrpc.Request) *tikvrpc.Response {
+	addr := s.cluster.GetStore(storeID).GetAddress()
+	resp, err := s.store.GetTiKVClient().SendRequest(context.Background(), addr, req, time.Second)
+	s.Require().Nil(err)
+	return resp
+}
+
+func (s *testGCPhysicalSuite) checkLockObserver(storeID, maxTS uint64) *kvrpcpb.CheckLockObserverResponse {
+	req := tikvrpc.NewRequest(tikvrpc.CmdCheckLockObserver, &kvrpcpb.CheckLockObserverRequest{MaxTs: maxTS})
+	return s.send(storeID, req).Resp.(*kvrpcpb.CheckLockObserverResponse)
+}
+
+func (s *testGCPhysicalSuite) physicalScanLock(storeID, maxTS uint64, startKey []byte, limit uint32) []*kvrpcpb.LockInfo {
+	req := tikvrpc.NewRequest(tikvrpc.CmdPhysicalScanLock, &kvrpcpb.PhysicalScanLockRequest{MaxTs: maxTS, StartKey: startKey, Limit: limit})
+	return s.send(storeID, req).Resp.(*kvrpcpb.PhysicalScanLockResponse).GetLocks()
+}
+
+func (s *testGCPhysicalSuite) mustLocks(safePoint uint64, expected int) {
+	plan, err := s.store.GCPlan(context.Background(), safePoint)
+	s.Require().Nil(err)
+	s.Equal(expected, plan.TotalLocks)
+}
+
+func (s *testGCPhysicalSuite) registerLockObservers(maxTS uint64) {
+	for _, storeID := range s.storeIDs {
+		req := tikvrpc.NewRequest(tikvrpc.CmdRegisterLockObserver, &kvrpcpb.RegisterLockObserverRequest{MaxTs: maxTS})
+		s.Empty(s.send(storeID, req).Resp.(*kvrpcpb.RegisterLockObserverResponse).GetError())
+	}
+}
+
+func (s *testGCPhysicalSuite) TestLockObserver() {
+	maxTS := mustCurrentTS(s.T(), s.store)
+	s.registerLockObservers(maxTS)
+	mustPrewriteTxn(s.T(), s.store, s.begin(), longLockTTL, false, "a", "a", "z")
+	for _, storeID := range s.storeIDs {
+		// The locks of the transaction started after maxTS are not observed.
+		resp := s.checkLockObserver(storeID, maxTS)
+		s.True(resp.GetIsClean())
+		s.Empty(resp.GetLocks())
+		s.False(s.checkLockObserver(storeID, maxTS+1).GetIsClean())
+
+		// All the stores have the locks because each region has a peer on each store.
+		locks := s.physicalScanLock(storeID, mustCurrentTS(s.T(), s.store), nil, 1)
+		s.Require().Len(locks, 1)
+		s.Equal([]byte("a"), locks[0].GetKey())
+		locks = s.physicalScanLock(storeID, mustCurrentTS(s.T(), s.store), []byte("b"), 0)
+		s.Require().Len(locks, 1)
+		s.Equal([]byte("z"), locks[0].GetKey())
+	}
+
+	// The observer becomes dirty when it's full.
+	s.cluster.SetLockObserverCapacity(2)
+	txn1, txn2 := s.begin(), s.begin()
+	maxTS = mustCurrentTS(s.T(), s.store)
+	s.registerLockObservers(maxTS)
+	mustPrewriteTxn(s.T(), s.store, txn1, longLockTTL, false, "d", "d", "n")
+	for _, storeID := range s.storeIDs {
+		resp := s.checkLockObserver(storeID, maxTS)
+		s.True(resp.GetIsClean())
+		s.Len(resp.GetLocks(), 2)
+	}
+	mustPrewriteTxn(s.T(), s.store, txn2, longLockTTL, false, "f", "f")
+	for _, storeID := range s.storeIDs {
+		resp := s.checkLockObserver(storeID, maxTS)
+		s.False(resp.GetIsClean())
+		s.Empty(resp.GetLocks())
+
+		req := tikvrpc.NewRequest(tikvrpc.CmdRemoveLockObserver, &kvrpcpb.RemoveLockObserverRequest{MaxTs: maxTS})
+		s.Empty(s.send(storeID, req).Resp.(*kvrpcpb.RemoveLockObserverResponse).GetError())
+		s.False(s.checkLockObserver(storeID, maxTS).GetIsClean())
+	}
+}
+
+func (s *testGCPhysicalSuite) TestGC() {
+	ctx := context.Background()
+	mustPrewriteTxn(s.T(), s.store, s.begin(), longLockTTL, false, "a", "a", "b", "x")
+	mustPrewriteTxn(s.T(), s.store, s.begin(), longLockTTL, false, "n", "n", "c")
+	safePoint := mustCurrentTS(s.T(), s.store)
+	mustPrewriteTxn(s.T(), s.store, s.begin(), longLockTTL, false, "y", "y", "d")
+	s.mustLocks(mustCurrentTS(s.T(), s.store), 7)
+
+	_, err := s.store.GC(ctx, safePoint, tikv.WithPhysicalScanLock(true))
+	s.Require().Nil(err)
+	s.mustLocks(safePoint, 0)
+	for _, storeID := range s.storeIDs {
+		// The locks newer than the safepoint are kept.
+		s.Len(s.physicalScanLock(storeID, mustCurrentTS(s.T(), s.store), nil, 0), 2)
+		s.False(s.checkLockObserver(storeID, safePoint).GetIsClean())
+	}
+}
+
+func (s *testGCPhysicalSuite) TestObservedLocks() {
+	ctx := context.Background()
+	// The observed locks are resolved if the observers are clean, otherwise GC falls
+	// back to scanning the regions.
+	for _, capacity := range []int{1024, 0} {
+		s.cluster.SetLockObserverCapacity(capacity)
+		mustPrewriteTxn(s.T(), s.store, s.begin(), longLockTTL, false, "a", "a", "z")
+		txn := s.begin()
+		safePoint := mustCurrentTS(s.T(), s.store)
+
+		s.Require().Nil(failpoint.Enable("tikvclient/beforeCheckLockObservers", "pause"))
+		errCh := make(chan error, 1)
+		go func() {
+			_, err := s.store.GC(ctx, safePoint, tikv.WithPhysicalScanLock(true))
+			errCh <- err
+		}()
+		time.Sleep(200 * time.Millisecond)
+		// The locks are written after the physical scan.
+		mustPrewriteTxn(s.T(), s.store, txn, longLockTTL, false, "b", "b", "y")
+		s.mustLocks(safePoint, 2)
+		s.Require().Nil(failpoint.Disable("tikvclient/beforeCheckLockObservers"))
+		s.Require().Nil(<-errCh)
+		s.mustLocks(safePoint, 0)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/JK1Zhang/client-go/v3/oracle"
+	"github.com/JK1Zhang/client-go/v3/tikv"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestGCPlan(t *testing.T) {
+	suite.Run(t, new(testGCPlanSuite))
+}
+
+type testGCPlanSuite struct {
+	suite.Suite
+	store tikv.StoreProbe
+}
+
+func (s *testGCPlanSuite) SetupTest() {
+	s.store = tikv.StoreProbe{KVStore: NewTestStore(s.T())}
+}
+
+func (s *testGCPlanSuite) TearDownTest() {
+	s.store.Close()
+}
+
+func (s *testGCPlanSuite) mustPlan(safePoint uint64) *tikv.GCPlan {
+	plan, err := s.store.GCPlan(context.Background(), safePoint, tikv.WithConcurrency(2))
+	s.Require().Nil(err)
+	return plan
+}
+
+func (s *testGCPlanSuite) TestGCPlan() {
+	ctx := context.Background()
+	before, err := s.store.CurrentTimestamp(oracle.GlobalTxnScope)
+	s.Require().Nil(err)
+	alive := mustPrewrite(s.T(), s.store, uint64(time.Hour/time.Millisecond), false, "a1", "a1", "a2")
+	committed := mustPrewrite(s.T(), s.store, uint64(time.Hour/time.Millisecond), true, "b1", "b1", "b2", "b3")
+	expired := mustPrewrite(s.T(), s.store, 1, false, "c1", "c1", "c2")
+	time.Sleep(10 * time.Millisecond)
+	safePoint, err := s.store.CurrentTimestamp(oracle.GlobalTxnScope)
+	s.Require().Nil(err)
+
+	for i := 0; i < 2; i++ {
+		// Nothing is resolved, so the plan is the same the second time.
+		plan := s.mustPlan(safePoint)
+		s.Equal(safePoint, plan.SafePoint)
+		s.Equal(1, plan.TotalRegions)
+		s.Equal(6, plan.TotalLocks)
+		s.Require().Len(plan.Regions, 1)
+		s.Equal(6, plan.Regions[0].Locks)
+		s.Equal([]uint64{alive, committed, expired}, plan.Regions[0].TxnIDs)
+		s.Require().Len(plan.Txns, 3)
+
+		s.Equal(tikv.GCTxnAlive, plan.Txns[0].State)
+		s.Equal(2, plan.Txns[0].Locks)
+		s.Equal([]byte("a1"), plan.Txns[0].Primary)
+		s.NotZero(plan.Txns[0].TTL)
+		s.Equal(tikv.GCTxnCommitted, plan.Txns[1].State)
+		s.Equal(2, plan.Txns[1].Locks)
+		s.Greater(plan.Txns[1].CommitTS, committed)
+		s.Equal(tikv.GCTxnExpired, plan.Txns[2].State)
+		s.Equal(1, plan.TxnsInState(tikv.GCTxnExpired))
+		s.True(plan.EstimatedDuration >= plan.Duration)
+	}
+
+	// The locks are not older than the safepoint.
+	plan := s.mustPlan(before)
+	s.Zero(plan.TotalLocks)
+	s.Empty(plan.Txns)
+
+	// The safepoint is blocked by the service safepoint.
+	s.Require().Nil(s.store.RegisterServiceSafePoint(ctx, "svc", before, time.Minute))
+	plan = s.mustPlan(safePoint)
+	s.Equal(safePoint, plan.RequestedSafePoint)
+	s.Equal(before, plan.SafePoint)
+	s.Zero(plan.TotalLocks)
+	s.Nil(s.store.UnregisterServiceSafePoint(ctx, "svc"))
+
+	// The service safepoint of the GC worker isn't moved by the plan, so the plan
+	// doesn't pass it.
+	_, err = s.store.GetPDClient().UpdateServiceGCSafePoint(ctx, "gc_worker", math.MaxInt64, before)
+	s.Require().Nil(err)
+	plan = s.mustPlan(safePoint)
+	s.Equal(before, plan.SafePoint)
+	minSafePoint, err := s.store.GetPDClient().UpdateServiceGCSafePoint(ctx, "probe", 0, 0)
+	s.Nil(err)
+	s.Equal(before, minSafePoint)
+}
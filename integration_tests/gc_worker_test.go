@@ -0,0 +1,206 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	tikverr "github.com/JK1Zhang/client-go/v3/error"
+	"github.com/JK1Zhang/client-go/v3/oracle"
+	"github.com/JK1Zhang/client-go/v3/tikv"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestGCWorker(t *testing.T) {
+	suite.Run(t, new(testGCWorkerSuite))
+}
+
+type testGCWorkerSuite struct {
+	suite.Suite
+	store tikv.StoreProbe
+}
+
+func (s *testGCWorkerSuite) SetupTest() {
+	s.store = tikv.StoreProbe{KVStore: NewTestStore(s.T())}
+}
+
+func (s *testGCWorkerSuite) TearDownTest() {
+	s.store.Close()
+}
+
+func (s *testGCWorkerSuite) newWorker(owner string, opts ...tikv.GCWorkerOption) *tikv.GCWorker {
+	opts = append([]tikv.GCWorkerOption{
+		tikv.WithGCOwnerID(owner),
+		tikv.WithGCTickInterval(10 * time.Millisecond),
+		tikv.WithGCLeaderLease(time.Minute),
+		tikv.WithGCRunInterval(time.Hour),
+		tikv.WithGCLifeTime(time.Minute),
+	}, opts...)
+	return tikv.NewGCWorker(s.store.KVStore, opts...)
+}
+
+func (s *testGCWorkerSuite) waitJob(w *tikv.GCWorker) *tikv.GCJobInfo {
+	var job *tikv.GCJobInfo
+	s.Require().Eventually(func() bool {
+		job = w.Status().LastJob
+		return job != nil && job.Finished
+	}, 5*time.Second, 10*time.Millisecond)
+	return job
+}
+
+func (s *testGCWorkerSuite) sameJob(expected, actual *tikv.GCJobInfo) {
+	s.Require().NotNil(actual)
+	s.Equal(expected.Owner, actual.Owner)
+	s.Equal(expected.SafePoint, actual.SafePoint)
+	s.True(expected.StartTime.Equal(actual.StartTime))
+}
+
+func (s *testGCWorkerSuite) TestRunGC() {
+	ctx := context.Background()
+	before := time.Now()
+	w1, w2 := s.newWorker("a"), s.newWorker("b")
+	w1.Start()
+	job := s.waitJob(w1)
+	w2.Start()
+	defer w2.Close()
+
+	status := w1.Status()
+	s.True(status.IsLeader)
+	s.Equal("a", status.Leader)
+	s.Nil(status.LastError)
+	s.Equal("a", job.Owner)
+	safePointTime := oracle.GetTimeFromTS(job.SafePoint)
+	s.False(safePointTime.Before(before.Add(-time.Minute).Add(-time.Second)))
+	s.True(safePointTime.Before(time.Now().Add(-time.Minute)))
+
+	// The safepoint is saved for CheckVisibility and uploaded to PD.
+	saved, err := s.store.LoadSafePoint()
+	s.Nil(err)
+	s.Equal(job.SafePoint, saved)
+	pdSafePoint, err := s.store.GetPDClient().UpdateGCSafePoint(ctx, 0)
+	s.Nil(err)
+	s.Equal(job.SafePoint, pdSafePoint)
+
+	// The follower knows the leader and the last job, but doesn't run GC.
+	s.Eventually(func() bool {
+		return w2.Status().Leader == "a"
+	}, 5*time.Second, 10*time.Millisecond)
+	status = w2.Status()
+	s.False(status.IsLeader)
+	s.False(status.Running)
+	s.sameJob(job, s.waitJob(w2))
+
+	// The leadership is taken over once the leader is closed. The new leader
+	// doesn't run GC again until the run interval passes.
+	w1.Close()
+	s.Eventually(func() bool {
+		return w2.Status().IsLeader
+	}, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	s.sameJob(job, w2.Status().LastJob)
+}
+
+func (s *testGCWorkerSuite) TestServiceSafePoint() {
+	ctx := context.Background()
+	now, err := s.store.CurrentTimestamp(oracle.GlobalTxnScope)
+	s.Require().Nil(err)
+	serviceSafePoint := oracle.GoTimeToTS(oracle.GetTimeFromTS(now).Add(-time.Hour))
+	_, err = s.store.GetPDClient().UpdateServiceGCSafePoint(ctx, "backup", 3600, serviceSafePoint)
+	s.Require().Nil(err)
+
+	w := s.newWorker("a")
+	w.Start()
+	defer w.Close()
+	job := s.waitJob(w)
+	s.Equal(serviceSafePoint, job.SafePoint)
+}
+
+func (s *testGCWorkerSuite) TestDeleteRange() {
+	ctx := context.Background()
+	txn, err := s.store.Begin()
+	s.Require().Nil(err)
+	for _, k := range []string{"a", "b", "c", "d"} {
+		s.Nil(txn.Set([]byte(k), []byte(k)))
+	}
+	s.Require().Nil(txn.Commit(ctx))
+
+	_, err = s.store.DeleteRangeLater(ctx, []byte("c"), []byte("b"))
+	s.NotNil(err)
+	ts, err := s.store.DeleteRangeLater(ctx, []byte("b"), []byte("d"))
+	s.Require().Nil(err)
+	records, err := s.store.ListDeleteRanges(ctx)
+	s.Nil(err)
+	s.Require().Len(records, 1)
+	s.Equal(tikv.DeleteRangeRecord{StartKey: []byte("b"), EndKey: []byte("d"), TS: ts}, *records[0])
+
+	// The range is destroyed once the safepoint passes the deletion ts. The safepoint
+	// has no logical part, so wait for the oracle to reach a later millisecond.
+	s.Eventually(func() bool {
+		now, err := s.store.CurrentTimestamp(oracle.GlobalTxnScope)
+		return err == nil && oracle.ExtractPhysical(now) > oracle.ExtractPhysical(ts)
+	}, 5*time.Second, time.Millisecond)
+	w := s.newWorker("a", tikv.WithGCLifeTime(0))
+	w.Start()
+	defer w.Close()
+	job := s.waitJob(w)
+	s.Equal(1, job.DeletedRanges)
+	s.Less(ts, job.SafePoint)
+
+	txn, err = s.store.Begin()
+	s.Require().Nil(err)
+	for _, k := range []string{"a", "b", "c", "d"} {
+		_, err = txn.Get(ctx, []byte(k))
+		if k == "b" || k == "c" {
+			s.True(tikverr.IsErrNotFound(err), k)
+		} else {
+			s.Nil(err, k)
+		}
+	}
+
+	ts2, err := s.store.DeleteRangeLater(ctx, []byte("x"), nil)
+	s.Require().Nil(err)
+	records, err = s.store.ListDeleteRanges(ctx)
+	s.Nil(err)
+	s.Require().Len(records, 2)
+	s.Equal(ts, records[0].TS)
+	s.True(records[0].Done)
+	s.False(records[0].DoneTime.IsZero())
+	s.Equal(ts2, records[1].TS)
+	s.False(records[1].Done)
+	// The pending record of the destroyed range is deleted.
+	kvs, err := s.store.GetSafePointKV().GetWithPrefix(tikv.GcDeleteRangePrefix)
+	s.Nil(err)
+	s.Require().Len(kvs, 1)
+	s.Contains(string(kvs[0].Value), fmt.Sprint(ts2))
+
+	// The done markers are deleted once the safepoint passes the time the ranges
+	// are destroyed.
+	w.Close()
+	w2 := s.newWorker("b", tikv.WithGCLifeTime(0), tikv.WithGCRunInterval(time.Millisecond))
+	w2.Start()
+	defer w2.Close()
+	s.Eventually(func() bool {
+		records, err = s.store.ListDeleteRanges(ctx)
+		return err == nil && len(records) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	for _, prefix := range []string{tikv.GcDeleteRangePrefix, tikv.GcDeleteRangeDonePrefix} {
+		kvs, err = s.store.GetSafePointKV().GetWithPrefix(prefix)
+		s.Nil(err)
+		s.Empty(kvs)
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	tikverr "github.com/JK1Zhang/client-go/v3/error"
+	"github.com/JK1Zhang/client-go/v3/tikv"
+	"github.com/JK1Zhang/client-go/v3/txnkv/index"
+	"github.com/JK1Zhang/client-go/v3/txnkv/transaction"
+	"github.com/JK1Zhang/client-go/v3/util/codec"
+	"github.com/pingcap/errors"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestIndex(t *testing.T) {
+	suite.Run(t, new(testIndexSuite))
+}
+
+type testIndexSuite struct {
+	suite.Suite
+	store tikv.StoreProbe
+	table *index.Table
+}
+
+// The rows of the test table are "id|email|city". The email is unique and the
+// city is optional.
+func (s *testIndexSuite) SetupTest() {
+	s.store = tikv.StoreProbe{KVStore: NewTestStore(s.T())}
+	field := func(row []byte, i int) string {
+		return strings.Split(string(row), "|")[i]
+	}
+	var err error
+	s.table, err = index.NewTable([]byte("user"),
+		func(row []byte, b *codec.KeyBuilder) error {
+			b.AddString(field(row, 0))
+			return nil
+		},
+		index.Index{Name: "email", Unique: true, Extract: func(row []byte, b *codec.KeyBuilder) (bool, error) {
+			b.AddString(field(row, 1))
+			return true, nil
+		}},
+		index.Index{Name: "city", Extract: func(row []byte, b *codec.KeyBuilder) (bool, error) {
+			city := field(row, 2)
+			if city == "" {
+				return false, nil
+			}
+			b.AddString(city)
+			return true, nil
+		}},
+	)
+	s.Require().Nil(err)
+}
+
+func (s *testIndexSuite) TearDownTest() {
+	s.store.Close()
+}
+
+func (s *testIndexSuite) begin() transaction.TxnProbe {
+	txn, err := s.store.Begin()
+	s.Require().Nil(err)
+	return txn
+}
+
+func (s *testIndexSuite) pk(id string) []byte {
+	return codec.NewKeyBuilder(nil).AddString(id).Key()
+}
+
+func (s *testIndexSuite) value(v string) []byte {
+	return codec.NewKeyBuilder(nil).AddString(v).Key()
+}
+
+func (s *testIndexSuite) mustCommit(f func(txn transaction.TxnProbe)) {
+	txn := s.begin()
+	f(txn)
+	s.Require().Nil(txn.Commit(context.Background()))
+}
+
+func (s *testIndexSuite) lookupCity(city string) []string {
+	txn := s.begin()
+	entries, err := s.table.LookupPrefix(context.Background(), txn.KVTxn, "city", s.value(city), 0)
+	s.Require().Nil(err)
+	rows, err := s.table.GetRows(context.Background(), txn.KVTxn, entries)
+	s.Require().Nil(err)
+	var res []string
+	for _, e := range entries {
+		res = append(res, string(rows[string(e.PrimaryKey)]))
+	}
+	return res
+}
+
+func (s *testIndexSuite) TestNewTable() {
+	extract := func(row []byte, b *codec.KeyBuilder) (bool, error) { return true, nil }
+	_, err := index.NewTable([]byte("t"), nil, index.Index{Name: "a", Extract: extract}, index.Index{Name: "a", Extract: extract})
+	s.NotNil(err)
+	_, err = index.NewTable([]byte("t"), nil, index.Index{Extract: extract})
+	s.NotNil(err)
+}
+
+func (s *testIndexSuite) TestInsertAndLookup() {
+	ctx := context.Background()
+	s.mustCommit(func(txn transaction.TxnProbe) {
+		s.Nil(s.table.Insert(ctx, txn.KVTxn, []byte("1|a@x|bj")))
+		s.Nil(s.table.Insert(ctx, txn.KVTxn, []byte("2|b@x|sh")))
+		s.Nil(s.table.Insert(ctx, txn.KVTxn, []byte("3|c@x|bj")))
+		s.Nil(s.table.Insert(ctx, txn.KVTxn, []byte("4|d@x|")))
+	})
+
+	txn := s.begin()
+	row, err := s.table.Get(ctx, txn.KVTxn, s.pk("2"))
+	s.Nil(err)
+	s.Equal([]byte("2|b@x|sh"), row)
+	pk, err := s.table.LookupUnique(ctx, txn.KVTxn, "email", s.value("c@x"))
+	s.Nil(err)
+	s.Equal(s.pk("3"), pk)
+	_, err = s.table.LookupUnique(ctx, txn.KVTxn, "email", s.value("e@x"))
+	s.True(tikverr.IsErrNotFound(err))
+	_, err = s.table.LookupUnique(ctx, txn.KVTxn, "city", s.value("bj"))
+	s.NotNil(err)
+	_, err = s.table.Lookup(ctx, txn.KVTxn, "name", nil, nil, 0)
+	s.NotNil(err)
+
+	s.Equal([]string{"1|a@x|bj", "3|c@x|bj"}, s.lookupCity("bj"))
+	s.Equal([]string{"2|b@x|sh"}, s.lookupCity("sh"))
+	s.Empty(s.lookupCity("sz"))
+
+	// Rows without a city have no entry in the index.
+	entries, err := s.table.Lookup(ctx, txn.KVTxn, "city", nil, nil, 0)
+	s.Nil(err)
+	s.Len(entries, 3)
+	s.Equal(s.value("bj"), entries[0].Value)
+	s.Equal(s.pk("1"), entries[0].PrimaryKey)
+
+	entries, err = s.table.Lookup(ctx, txn.KVTxn, "email", s.value("b@x"), s.value("d@x"), 0)
+	s.Nil(err)
+	s.Len(entries, 2)
+	s.Equal(s.value("b@x"), entries[0].Value)
+	s.Equal(s.pk("3"), entries[1].PrimaryKey)
+	entries, err = s.table.Lookup(ctx, txn.KVTxn, "email", s.value("b@x"), nil, 1)
+	s.Nil(err)
+	s.Len(entries, 1)
+	s.Equal(s.pk("2"), entries[0].PrimaryKey)
+}
+
+func (s *testIndexSuite) TestPutUpdateDelete() {
+	ctx := context.Background()
+	s.mustCommit(func(txn transaction.TxnProbe) {
+		s.Nil(s.table.Put(ctx, txn.KVTxn, []byte("1|a@x|bj")))
+		s.Nil(s.table.Put(ctx, txn.KVTxn, []byte("2|b@x|bj")))
+	})
+	s.mustCommit(func(txn transaction.TxnProbe) {
+		s.Nil(s.table.Put(ctx, txn.KVTxn, []byte("1|a2@x|sh")))
+		// The unchanged unique value is not reported as a duplicate.
+		s.Nil(s.table.Update(ctx, txn.KVTxn, []byte("2|b@x|")))
+	})
+	s.Empty(s.lookupCity("bj"))
+	s.Equal([]string{"1|a2@x|sh"}, s.lookupCity("sh"))
+
+	txn := s.begin()
+	_, err := s.table.LookupUnique(ctx, txn.KVTxn, "email", s.value("a@x"))
+	s.True(tikverr.IsErrNotFound(err))
+	pk, err := s.table.LookupUnique(ctx, txn.KVTxn, "email", s.value("a2@x"))
+	s.Nil(err)
+	s.Equal(s.pk("1"), pk)
+	s.True(tikverr.IsErrNotFound(s.table.Update(ctx, txn.KVTxn, []byte("3|c@x|bj"))))
+
+	s.mustCommit(func(txn transaction.TxnProbe) {
+		s.Nil(s.table.Delete(ctx, txn.KVTxn, s.pk("1")))
+		s.Nil(s.table.Delete(ctx, txn.KVTxn, s.pk("3")))
+	})
+	s.Empty(s.lookupCity("sh"))
+	txn = s.begin()
+	_, err = s.table.Get(ctx, txn.KVTxn, s.pk("1"))
+	s.True(tikverr.IsErrNotFound(err))
+	_, err = s.table.LookupUnique(ctx, txn.KVTxn, "email", s.value("a2@x"))
+	s.True(tikverr.IsErrNotFound(err))
+	entries, err := s.table.Lookup(ctx, txn.KVTxn, "email", nil, nil, 0)
+	s.Nil(err)
+	s.Len(entries, 1)
+}
+
+func (s *testIndexSuite) TestUniqueConflict() {
+	ctx := context.Background()
+	s.mustCommit(func(txn transaction.TxnProbe) {
+		s.Nil(s.table.Insert(ctx, txn.KVTxn, []byte("1|a@x|bj")))
+	})
+
+	// The duplicate committed by another transaction is detected on commit.
+	txn := s.begin()
+	s.Nil(s.table.Insert(ctx, txn.KVTxn, []byte("2|a@x|sh")))
+	err := txn.Commit(ctx)
+	_, ok := errors.Cause(err).(*tikverr.ErrKeyExist)
+	s.True(ok, "%v", err)
+
+	// The duplicated primary key is detected too.
+	txn = s.begin()
+	s.Nil(s.table.Insert(ctx, txn.KVTxn, []byte("1|b@x|sh")))
+	err = txn.Commit(ctx)
+	_, ok = errors.Cause(err).(*tikverr.ErrKeyExist)
+	s.True(ok, "%v", err)
+
+	// The duplicate written in the same transaction is detected immediately.
+	txn = s.begin()
+	s.Nil(s.table.Insert(ctx, txn.KVTxn, []byte("2|b@x|sh")))
+	err = s.table.Insert(ctx, txn.KVTxn, []byte("3|b@x|sh"))
+	_, ok = errors.Cause(err).(*tikverr.ErrKeyExist)
+	s.True(ok, "%v", err)
+	s.Nil(txn.Rollback())
+
+	// The old value released in the transaction can be reused.
+	s.mustCommit(func(txn transaction.TxnProbe) {
+		s.Nil(s.table.Put(ctx, txn.KVTxn, []byte("1|c@x|bj")))
+		s.Nil(s.table.Insert(ctx, txn.KVTxn, []byte("2|a@x|sh")))
+	})
+	txn = s.begin()
+	pk, err := s.table.LookupUnique(ctx, txn.KVTxn, "email", s.value("a@x"))
+	s.Nil(err)
+	s.Equal(s.pk("2"), pk)
+	pk, err = s.table.LookupUnique(ctx, txn.KVTxn, "email", s.value("c@x"))
+	s.Nil(err)
+	s.Equal(s.pk("1"), pk)
+}
@@ -0,0 +1,298 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JK1Zhang/client-go/v3/rawkv"
+	"github.com/JK1Zhang/client-go/v3/rawkv/lease"
+	"github.com/JK1Zhang/client-go/v3/testutils"
+	"github.com/JK1Zhang/client-go/v3/tikv"
+	"github.com/JK1Zhang/client-go/v3/tikvrpc"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestLease(t *testing.T) {
+	suite.Run(t, new(testLeaseSuite))
+}
+
+type testLeaseSuite struct {
+	suite.Suite
+	raw   rawkv.ClientProbe
+	clock *fakeClock
+
+	mu struct {
+		sync.Mutex
+		// onCAS is called before a CompareAndSwap request is sent.
+		onCAS func()
+	}
+}
+
+// leaseCASClient calls the onCAS hook of the suite before CompareAndSwap.
+type leaseCASClient struct {
+	tikv.Client
+	s *testLeaseSuite
+}
+
+func (c leaseCASClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	if req.Type == tikvrpc.CmdRawCompareAndSwap {
+		c.s.mu.Lock()
+		onCAS := c.s.mu.onCAS
+		c.s.mu.Unlock()
+		if onCAS != nil {
+			onCAS()
+		}
+	}
+	return c.Client.SendRequest(ctx, addr, req, timeout)
+}
+
+type fakeClock struct {
+	sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func (s *testLeaseSuite) SetupTest() {
+	client, cluster, pdClient, err := testutils.NewMockTiKV("", nil)
+	s.Require().Nil(err)
+	testutils.BootstrapWithSingleStore(cluster)
+	s.raw = rawkv.ClientProbe{Client: &rawkv.Client{}}
+	s.raw.SetPDClient(pdClient)
+	s.raw.SetRegionCache(tikv.NewRegionCache(pdClient))
+	s.raw.SetRPCClient(leaseCASClient{Client: client, s: s})
+	s.raw.SetAtomicForCAS(true)
+	s.clock = &fakeClock{now: time.Unix(1600000000, 0)}
+}
+
+func (s *testLeaseSuite) TearDownTest() {
+	s.Require().Nil(s.raw.Close())
+}
+
+func (s *testLeaseSuite) newMutex(owner string) *lease.Mutex {
+	return lease.NewMutex(s.raw.Client, []byte("mutex"),
+		lease.WithOwner(owner),
+		lease.WithTTL(10*time.Second),
+		lease.WithRenewInterval(-1),
+		lease.WithRetryInterval(time.Millisecond),
+		lease.WithClock(s.clock))
+}
+
+func (s *testLeaseSuite) TestMutex() {
+	ctx := context.Background()
+	m1, m2 := s.newMutex("a"), s.newMutex("b")
+
+	l1, err := m1.TryLock(ctx)
+	s.Require().Nil(err)
+	s.Equal("a", l1.Owner())
+	s.Equal(uint64(1), l1.Token())
+	s.True(l1.Valid())
+	_, err = m2.TryLock(ctx)
+	s.Equal(lease.ErrLocked, err)
+
+	// The renewed lease is not taken over.
+	s.clock.Advance(8 * time.Second)
+	s.Nil(l1.Renew(ctx))
+	s.clock.Advance(8 * time.Second)
+	_, err = m2.TryLock(ctx)
+	s.Equal(lease.ErrLocked, err)
+
+	// The released mutex is acquired with a greater fencing token.
+	s.Nil(l1.Release(ctx))
+	s.False(l1.Valid())
+	s.Nil(l1.Err())
+	s.Nil(l1.Release(ctx))
+	<-l1.Done()
+	l2, err := m2.TryLock(ctx)
+	s.Require().Nil(err)
+	s.Equal(uint64(2), l2.Token())
+
+	// The expired lease is taken over, and the old owner finds it lost.
+	s.clock.Advance(11 * time.Second)
+	s.False(l2.Valid())
+	l3, err := m1.TryLock(ctx)
+	s.Require().Nil(err)
+	s.Equal(uint64(3), l3.Token())
+	s.Equal(lease.ErrLeaseLost, l2.Renew(ctx))
+	s.Equal(lease.ErrLeaseLost, l2.Err())
+	s.Equal(lease.ErrLeaseLost, l2.Release(ctx))
+	<-l2.Done()
+	s.True(l3.Valid())
+	s.Nil(l3.Release(ctx))
+}
+
+func (s *testLeaseSuite) TestCompareAndSwapAbsentKey() {
+	ctx := context.Background()
+	key, value, newValue := []byte("cas_absent"), []byte("v1"), []byte("v2")
+	prev, swapped, err := s.raw.CompareAndSwap(ctx, key, nil, value)
+	s.Nil(err)
+	s.True(swapped)
+	s.Nil(prev)
+
+	prev, swapped, err = s.raw.CompareAndSwap(ctx, key, nil, newValue)
+	s.Nil(err)
+	s.False(swapped)
+	s.Equal(value, prev)
+
+	// An empty value is different from an absent key.
+	prev, swapped, err = s.raw.CompareAndSwap(ctx, []byte("cas_absent_2"), []byte{}, value)
+	s.Nil(err)
+	s.False(swapped)
+	s.Nil(prev)
+}
+
+func (s *testLeaseSuite) TestRenewNotBlocking() {
+	ctx := context.Background()
+	l, err := s.newMutex("a").TryLock(ctx)
+	s.Require().Nil(err)
+
+	entered, resume := make(chan struct{}), make(chan struct{})
+	s.mu.Lock()
+	s.mu.onCAS = func() {
+		close(entered)
+		<-resume
+	}
+	s.mu.Unlock()
+	renewed := make(chan error)
+	go func() {
+		renewed <- l.Renew(ctx)
+	}()
+	<-entered
+	s.mu.Lock()
+	s.mu.onCAS = nil
+	s.mu.Unlock()
+
+	// The state of the lease is read while the renewal is in flight.
+	valid := make(chan bool, 1)
+	go func() {
+		valid <- l.Valid() && l.Err() == nil
+	}()
+	select {
+	case v := <-valid:
+		s.True(v)
+	case <-time.After(time.Second):
+		s.Fail("Valid is blocked by the renewal")
+	}
+	close(resume)
+	s.Nil(<-renewed)
+	s.Nil(l.Release(ctx))
+}
+
+func (s *testLeaseSuite) TestLock() {
+	ctx := context.Background()
+	m1, m2 := s.newMutex("a"), s.newMutex("b")
+	l1, err := m1.Lock(ctx)
+	s.Require().Nil(err)
+
+	ctx1, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	_, err = m2.Lock(ctx1)
+	cancel()
+	s.NotNil(err)
+
+	ch := make(chan *lease.Lease)
+	go func() {
+		l, err := m2.Lock(ctx)
+		s.Nil(err)
+		ch <- l
+	}()
+	time.Sleep(20 * time.Millisecond)
+	s.Nil(l1.Release(ctx))
+	l2 := <-ch
+	s.Equal(uint64(2), l2.Token())
+	s.Nil(l2.Release(ctx))
+}
+
+func (s *testLeaseSuite) TestBackgroundRenew() {
+	ctx := context.Background()
+	opts := []lease.Option{lease.WithTTL(300 * time.Millisecond), lease.WithRenewInterval(50 * time.Millisecond)}
+	m1 := lease.NewMutex(s.raw.Client, []byte("renew"), opts...)
+	m2 := lease.NewMutex(s.raw.Client, []byte("renew"), opts...)
+	s.NotEqual(m1.Owner(), m2.Owner())
+	l1, err := m1.TryLock(ctx)
+	s.Require().Nil(err)
+	time.Sleep(500 * time.Millisecond)
+	s.True(l1.Valid())
+	_, err = m2.TryLock(ctx)
+	s.Equal(lease.ErrLocked, err)
+	s.Nil(l1.Release(ctx))
+	l2, err := m2.TryLock(ctx)
+	s.Require().Nil(err)
+	s.Nil(l2.Release(ctx))
+}
+
+func (s *testLeaseSuite) TestElection() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	newElection := func(owner string) *lease.Election {
+		return lease.NewElection(s.raw.Client, []byte("election"),
+			lease.WithOwner(owner),
+			lease.WithRenewInterval(-1),
+			lease.WithRetryInterval(time.Millisecond),
+			lease.WithClock(s.clock))
+	}
+	e1, e2 := newElection("a"), newElection("b")
+
+	_, err := e1.Leader(ctx)
+	s.Equal(lease.ErrNoLeader, err)
+	observed := e2.Observe(ctx)
+	s.Nil(<-observed)
+
+	l1, err := e1.Campaign(ctx, []byte("addr-a"))
+	s.Require().Nil(err)
+	leader := <-observed
+	s.Require().NotNil(leader)
+	s.Equal(lease.LeaderInfo{Owner: "a", Value: []byte("addr-a"), Token: 1}, *leader)
+
+	ch := make(chan *lease.Lease)
+	go func() {
+		l, err := e2.Campaign(ctx, []byte("addr-b"))
+		s.Nil(err)
+		ch <- l
+	}()
+	s.Nil(l1.Release(ctx))
+	// The leader may be observed gone before the new leader is elected.
+	leader = <-observed
+	if leader == nil {
+		leader = <-observed
+	}
+	s.Require().NotNil(leader)
+	s.Equal(lease.LeaderInfo{Owner: "b", Value: []byte("addr-b"), Token: 2}, *leader)
+	l2 := <-ch
+	info, err := e1.Leader(ctx)
+	s.Nil(err)
+	s.Equal("b", info.Owner)
+
+	// The leader is gone once its lease expires.
+	s.clock.Advance(time.Minute)
+	s.Nil(<-observed)
+	s.False(l2.Valid())
+	cancel()
+	for range observed {
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/JK1Zhang/client-go/v3/kv"
+	"github.com/JK1Zhang/client-go/v3/testutils"
+	"github.com/JK1Zhang/client-go/v3/tikv"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestLockCleaner(t *testing.T) {
+	suite.Run(t, new(testLockCleanerSuite))
+}
+
+type testLockCleanerSuite struct {
+	suite.Suite
+	store tikv.StoreProbe
+}
+
+func (s *testLockCleanerSuite) SetupTest() {
+	client, cluster, pdClient, err := testutils.NewMockTiKV("", nil)
+	s.Require().Nil(err)
+	testutils.BootstrapWithMultiRegions(cluster, []byte("m"))
+	store, err := tikv.NewTestTiKVStore(client, pdClient, nil, nil, 0)
+	s.Require().Nil(err)
+	s.store = tikv.StoreProbe{KVStore: store}
+}
+
+func (s *testLockCleanerSuite) TearDownTest() {
+	s.store.Close()
+}
+
+func (s *testLockCleanerSuite) locks() int {
+	locks, err := s.store.ScanLocks(context.Background(), nil, nil, mustCurrentTS(s.T(), s.store), 0)
+	s.Require().Nil(err)
+	return len(locks)
+}
+
+func (s *testLockCleanerSuite) TestRanges() {
+	ctx := context.Background()
+	mustPrewrite(s.T(), s.store, 1, false, "a", "a", "n")
+	mustPrewrite(s.T(), s.store, uint64(time.Hour/time.Millisecond), false, "b", "b")
+	time.Sleep(10 * time.Millisecond)
+
+	// The locks are not old enough.
+	cleaner := tikv.NewLockCleaner(s.store.KVStore, tikv.WithLockCleanerMinLockAge(time.Hour))
+	res, err := cleaner.RunOnce(ctx)
+	s.Require().Nil(err)
+	s.Zero(res.Scanned)
+	s.Equal(3, s.locks())
+
+	cleaner = tikv.NewLockCleaner(s.store.KVStore,
+		tikv.WithLockCleanerMinLockAge(0),
+		tikv.WithLockCleanerRanges(kv.KeyRange{StartKey: []byte("a"), EndKey: []byte("m")}))
+	res, err = cleaner.RunOnce(ctx)
+	s.Require().Nil(err)
+	s.Equal(1, res.Ranges)
+	s.Equal(2, res.Scanned)
+	s.Equal(1, res.Resolved)
+	s.Equal(1, res.Alive)
+	s.Zero(res.Failed)
+	s.Equal(2, s.locks())
+
+	// Both regions are accessed recently.
+	cleaner = tikv.NewLockCleaner(s.store.KVStore, tikv.WithLockCleanerMinLockAge(0))
+	res, err = cleaner.RunOnce(ctx)
+	s.Require().Nil(err)
+	s.Equal(2, res.Ranges)
+	s.Equal(2, res.Scanned)
+	s.Equal(1, res.Resolved)
+	s.Equal(1, res.Alive)
+	s.Equal(1, s.locks())
+
+	cleaner = tikv.NewLockCleaner(s.store.KVStore, tikv.WithLockCleanerMinLockAge(0), tikv.WithLockCleanerHotRegions(1))
+	res, err = cleaner.RunOnce(ctx)
+	s.Require().Nil(err)
+	s.Equal(1, res.Ranges)
+}
+
+func (s *testLockCleanerSuite) TestPaging() {
+	// The alive locks fill more than a page at the head of the range.
+	alive := make([]string, 1100)
+	for i := range alive {
+		alive[i] = fmt.Sprintf("a%04d", i)
+	}
+	mustPrewrite(s.T(), s.store, uint64(time.Hour/time.Millisecond), false, alive[0], alive...)
+	mustPrewrite(s.T(), s.store, 1, false, "b", "b", "c")
+	time.Sleep(10 * time.Millisecond)
+
+	cleaner := tikv.NewLockCleaner(s.store.KVStore,
+		tikv.WithLockCleanerMinLockAge(0),
+		tikv.WithLockCleanerRanges(kv.KeyRange{StartKey: []byte("a"), EndKey: []byte("m")}))
+	res, err := cleaner.RunOnce(context.Background())
+	s.Require().Nil(err)
+	s.Equal(1102, res.Scanned)
+	s.Equal(1100, res.Alive)
+	s.Equal(2, res.Resolved)
+	s.Equal(1100, s.locks())
+}
+
+func (s *testLockCleanerSuite) TestRate() {
+	mustPrewrite(s.T(), s.store, 1, false, "a", "a", "b", "c", "d", "e", "f")
+	time.Sleep(10 * time.Millisecond)
+
+	// The locks are resolved 2 at a time, and paced every 100ms.
+	cleaner := tikv.NewLockCleaner(s.store.KVStore, tikv.WithLockCleanerMinLockAge(0), tikv.WithLockCleanerRate(20))
+	res, err := cleaner.RunOnce(context.Background())
+	s.Require().Nil(err)
+	s.Equal(6, res.Resolved)
+	s.GreaterOrEqual(res.Duration, 200*time.Millisecond)
+	s.Zero(s.locks())
+}
+
+func (s *testLockCleanerSuite) TestStart() {
+	// Both locks are in the same region, so they are resolved in the same round.
+	mustPrewrite(s.T(), s.store, 1, false, "a", "a", "b")
+	time.Sleep(10 * time.Millisecond)
+
+	cleaner := tikv.NewLockCleaner(s.store.KVStore,
+		tikv.WithLockCleanerMinLockAge(0),
+		tikv.WithLockCleanerInterval(200*time.Millisecond))
+	cleaner.Start()
+	defer cleaner.Close()
+	var res tikv.LockCleanerResult
+	s.Require().Eventually(func() bool {
+		var err error
+		res, err = cleaner.LastResult()
+		return err == nil && res.Scanned > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	s.Equal(2, res.Scanned)
+	s.Equal(2, res.Resolved)
+	s.Zero(res.Alive)
+	s.Zero(res.Failed)
+	s.Zero(s.locks())
+}
@@ -1021,3 +1021,33 @@ func (s *testLockSuite) TestLockWaitTimeLimit() {
 	s.Nil(txn1.Rollback())
 	s.Nil(txn2.Rollback())
 }
+
+func (s *testLockSuite) TestLockWaitTimeoutDetail() {
+	k1 := []byte("k1")
+	k2 := []byte("k2")
+
+	txn1, err := s.store.Begin()
+	s.Nil(err)
+	txn1.SetPessimistic(true)
+	lockCtx := &kv.LockCtx{ForUpdateTS: txn1.StartTS(), WaitStartTime: time.Now()}
+	s.Nil(txn1.LockKeys(context.Background(), lockCtx, k1, k2))
+
+	txn2, err := s.store.Begin()
+	s.Nil(err)
+	txn2.SetPessimistic(true)
+	lockCtx = kv.NewLockCtx(txn2.StartTS(), 200, time.Now())
+	err = txn2.LockKeys(context.Background(), lockCtx, k2)
+	s.True(errors.Is(err, tikverr.ErrLockWaitTimeout))
+	var detail *tikverr.ErrLockWaitTimeoutDetail
+	s.Require().True(errors.As(err, &detail))
+	s.Equal(k2, detail.Key)
+	s.Equal(k1, detail.Primary)
+	s.Equal(txn1.StartTS(), detail.BlockerStartTS)
+	s.GreaterOrEqual(detail.WaitDuration, 200*time.Millisecond)
+	code, category := tikverr.Classify(err)
+	s.Equal(tikverr.CodeLockWaitTimeout, code)
+	s.Equal(tikverr.CategoryTimeout, category)
+
+	s.Nil(txn1.Rollback())
+	s.Nil(txn2.Rollback())
+}
@@ -0,0 +1,135 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/JK1Zhang/client-go/v3/kv"
+	"github.com/JK1Zhang/client-go/v3/testutils"
+	"github.com/JK1Zhang/client-go/v3/tikv"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestMVCCInfo(t *testing.T) {
+	suite.Run(t, new(testMVCCInfoSuite))
+}
+
+type testMVCCInfoSuite struct {
+	suite.Suite
+	cluster testutils.Cluster
+	store   tikv.StoreProbe
+}
+
+func (s *testMVCCInfoSuite) SetupTest() {
+	client, cluster, pdClient, err := testutils.NewMockTiKV("", nil)
+	s.Require().Nil(err)
+	testutils.BootstrapWithSingleStore(cluster)
+	s.cluster = cluster
+	store, err := tikv.NewTestTiKVStore(client, pdClient, nil, nil, 0)
+	s.Require().Nil(err)
+	s.store = tikv.StoreProbe{KVStore: store}
+}
+
+func (s *testMVCCInfoSuite) TearDownTest() {
+	s.Require().Nil(s.store.Close())
+}
+
+func (s *testMVCCInfoSuite) split(key []byte) {
+	bo := tikv.NewBackofferWithVars(context.Background(), 5000, nil)
+	loc, err := s.store.GetRegionCache().LocateKey(bo, key)
+	s.Require().Nil(err)
+	newRegionID, peerID := s.cluster.AllocID(), s.cluster.AllocID()
+	s.cluster.Split(loc.Region.GetID(), newRegionID, key, []uint64{peerID}, peerID)
+	s.store.GetRegionCache().InvalidateCachedRegion(loc.Region)
+}
+
+func (s *testMVCCInfoSuite) TestGetMVCCByKey() {
+	key := []byte("mvcc_key")
+	txn1, err := s.store.Begin()
+	s.Require().Nil(err)
+	s.Nil(txn1.Set(key, []byte("v1")))
+	s.Nil(txn1.Commit(context.Background()))
+
+	txn2, err := s.store.Begin()
+	s.Require().Nil(err)
+	s.Nil(txn2.Delete(key))
+	s.Nil(txn2.Commit(context.Background()))
+
+	txn3, err := s.store.Begin()
+	s.Require().Nil(err)
+	txn3.SetPessimistic(true)
+	lockCtx := &kv.LockCtx{ForUpdateTS: txn3.StartTS(), WaitStartTime: time.Now()}
+	s.Nil(txn3.LockKeys(context.Background(), lockCtx, key))
+
+	info, err := s.store.GetMVCCByKey(context.Background(), key)
+	s.Require().Nil(err)
+	s.Equal(key, info.Key)
+	s.Require().NotNil(info.Lock)
+	s.Equal(kvrpcpb.Op_PessimisticLock, info.Lock.Type)
+	s.Equal(txn3.StartTS(), info.Lock.StartTS)
+	s.Equal(key, info.Lock.Primary)
+	s.Require().Len(info.Writes, 2)
+	s.Equal(kvrpcpb.Op_Del, info.Writes[0].Type)
+	s.Equal(txn2.StartTS(), info.Writes[0].StartTS)
+	s.Equal(txn2.GetCommitTS(), info.Writes[0].CommitTS)
+	s.Equal(kvrpcpb.Op_Put, info.Writes[1].Type)
+	s.Equal(txn1.StartTS(), info.Writes[1].StartTS)
+	s.Equal(txn1.GetCommitTS(), info.Writes[1].CommitTS)
+	s.Equal([]byte("v1"), info.Writes[1].ShortValue)
+	s.Nil(txn3.Rollback())
+
+	info, err = s.store.GetMVCCByKey(context.Background(), []byte("mvcc_not_exist"))
+	s.Require().Nil(err)
+	s.Nil(info.Lock)
+	s.Empty(info.Writes)
+}
+
+func (s *testMVCCInfoSuite) TestGetMVCCSampleByStartTS() {
+	s.split([]byte("mvcc_b"))
+	s.split([]byte("mvcc_c"))
+
+	txn, err := s.store.Begin()
+	s.Require().Nil(err)
+	s.Nil(txn.Set([]byte("mvcc_a"), []byte("va")))
+	s.Nil(txn.Set([]byte("mvcc_a2"), []byte("va2")))
+	s.Nil(txn.Set([]byte("mvcc_c"), []byte("vc")))
+	s.Nil(txn.Commit(context.Background()))
+
+	// A key is sampled for each region.
+	infos, err := s.store.GetMVCCSampleByStartTS(context.Background(), txn.StartTS())
+	s.Require().Nil(err)
+	s.Require().Len(infos, 2)
+	s.Equal([]byte("mvcc_a"), infos[0].Key)
+	s.Equal([]byte("mvcc_c"), infos[1].Key)
+	// The primary key is committed, the secondary key may be committed asynchronously.
+	s.Require().Len(infos[0].Writes, 1)
+	s.Equal(txn.StartTS(), infos[0].Writes[0].StartTS)
+	s.Equal(txn.GetCommitTS(), infos[0].Writes[0].CommitTS)
+	if infos[1].Lock != nil {
+		s.Equal(txn.StartTS(), infos[1].Lock.StartTS)
+		s.Equal([]byte("mvcc_a"), infos[1].Lock.Primary)
+	} else {
+		s.Require().Len(infos[1].Writes, 1)
+		s.Equal(txn.StartTS(), infos[1].Writes[0].StartTS)
+	}
+
+	infos, err = s.store.GetMVCCSampleByStartTS(context.Background(), txn.StartTS()+1)
+	s.Require().Nil(err)
+	s.Empty(infos)
+}
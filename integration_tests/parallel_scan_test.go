@@ -0,0 +1,166 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/JK1Zhang/client-go/v3/oracle"
+	"github.com/JK1Zhang/client-go/v3/testutils"
+	"github.com/JK1Zhang/client-go/v3/tikv"
+	"github.com/JK1Zhang/client-go/v3/txnkv/txnsnapshot"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/suite"
+)
+
+const parallelScanRowNum = 300
+
+func TestParallelScan(t *testing.T) {
+	suite.Run(t, new(testParallelScanSuite))
+}
+
+type testParallelScanSuite struct {
+	suite.Suite
+	cluster testutils.Cluster
+	store   tikv.StoreProbe
+}
+
+func (s *testParallelScanSuite) SetupTest() {
+	client, cluster, pdClient, err := testutils.NewMockTiKV("", nil)
+	s.Require().Nil(err)
+	testutils.BootstrapWithSingleStore(cluster)
+	s.cluster = cluster
+	store, err := tikv.NewTestTiKVStore(client, pdClient, nil, nil, 0)
+	s.Require().Nil(err)
+	s.store = tikv.StoreProbe{KVStore: store}
+
+	txn, err := s.store.Begin()
+	s.Require().Nil(err)
+	for i := 0; i < parallelScanRowNum; i++ {
+		s.Require().Nil(txn.Set(s.makeKey(i), s.makeValue(i)))
+	}
+	s.Require().Nil(txn.Commit(context.Background()))
+
+	bo := tikv.NewBackofferWithVars(context.Background(), 5000, nil)
+	for _, i := range []int{37, 100, 101, 222} {
+		loc, err := s.store.GetRegionCache().LocateKey(bo, s.makeKey(i))
+		s.Require().Nil(err)
+		newRegionID, peerID := s.cluster.AllocID(), s.cluster.AllocID()
+		s.cluster.Split(loc.Region.GetID(), newRegionID, s.makeKey(i), []uint64{peerID}, peerID)
+		s.store.GetRegionCache().InvalidateCachedRegion(loc.Region)
+	}
+}
+
+func (s *testParallelScanSuite) TearDownTest() {
+	s.Require().Nil(s.store.Close())
+}
+
+func (s *testParallelScanSuite) makeKey(i int) []byte {
+	return []byte(fmt.Sprintf("pscan_%05d", i))
+}
+
+func (s *testParallelScanSuite) makeValue(i int) []byte {
+	return []byte(fmt.Sprintf("value_%d", i))
+}
+
+func (s *testParallelScanSuite) getSnapshot() *txnsnapshot.KVSnapshot {
+	ts, err := s.store.CurrentTimestamp(oracle.GlobalTxnScope)
+	s.Require().Nil(err)
+	snapshot := s.store.KVStore.GetSnapshot(ts)
+	snapshot.SetScanBatchSize(16)
+	return snapshot
+}
+
+func (s *testParallelScanSuite) collect(startKey, endKey []byte, concurrency int, opts ...txnsnapshot.ParallelScanOption) ([][]byte, [][]byte) {
+	var keys, values [][]byte
+	err := s.getSnapshot().ParallelScan(context.Background(), startKey, endKey, concurrency, func(key, value []byte) error {
+		keys = append(keys, key)
+		values = append(values, value)
+		return nil
+	}, opts...)
+	s.Require().Nil(err)
+	return keys, values
+}
+
+func (s *testParallelScanSuite) TestOrdered() {
+	for _, concurrency := range []int{1, 3, 16} {
+		keys, values := s.collect(nil, nil, concurrency)
+		s.Require().Len(keys, parallelScanRowNum)
+		for i := range keys {
+			s.Equal(s.makeKey(i), keys[i])
+			s.Equal(s.makeValue(i), values[i])
+		}
+	}
+
+	keys, _ := s.collect(s.makeKey(20), s.makeKey(230), 4)
+	s.Require().Len(keys, 210)
+	for i := range keys {
+		s.Equal(s.makeKey(i+20), keys[i])
+	}
+
+	keys, _ = s.collect(s.makeKey(40), s.makeKey(40), 4)
+	s.Empty(keys)
+}
+
+func (s *testParallelScanSuite) TestUnordered() {
+	keys, _ := s.collect(s.makeKey(10), nil, 4, txnsnapshot.WithUnordered())
+	s.Require().Len(keys, parallelScanRowNum-10)
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+	for i := range keys {
+		s.Equal(s.makeKey(i+10), keys[i])
+	}
+}
+
+func (s *testParallelScanSuite) TestHandlerError() {
+	for _, opts := range [][]txnsnapshot.ParallelScanOption{nil, {txnsnapshot.WithUnordered()}} {
+		errStop := errors.New("stop")
+		cnt := 0
+		err := s.getSnapshot().ParallelScan(context.Background(), nil, nil, 4, func(key, value []byte) error {
+			cnt++
+			if cnt == 50 {
+				return errStop
+			}
+			return nil
+		}, opts...)
+		s.Equal(errStop, errors.Cause(err))
+		s.Equal(50, cnt)
+	}
+}
+
+func (s *testParallelScanSuite) TestResolveLocks() {
+	// Leave a secondary lock on key 150 whose primary key 50 is committed.
+	txn, err := s.store.Begin()
+	s.Require().Nil(err)
+	s.Require().Nil(txn.Set(s.makeKey(50), []byte("new_50")))
+	s.Require().Nil(txn.Set(s.makeKey(150), []byte("new_150")))
+	committer, err := txn.NewCommitter(0)
+	s.Require().Nil(err)
+	committer.SetPrimaryKey(s.makeKey(50))
+	ctx := context.Background()
+	s.Require().Nil(committer.PrewriteAllMutations(ctx))
+	commitTS, err := s.store.CurrentTimestamp(oracle.GlobalTxnScope)
+	s.Require().Nil(err)
+	committer.SetCommitTS(commitTS)
+	s.Require().Nil(committer.CommitMutations(ctx))
+
+	keys, values := s.collect(nil, nil, 4)
+	s.Require().Len(keys, parallelScanRowNum)
+	s.Equal([]byte("new_50"), values[50])
+	s.Equal([]byte("new_150"), values[150])
+}
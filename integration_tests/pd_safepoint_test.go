@@ -0,0 +1,170 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/JK1Zhang/client-go/v3/config"
+	"github.com/JK1Zhang/client-go/v3/testutils"
+	"github.com/JK1Zhang/client-go/v3/tikv"
+	"github.com/stretchr/testify/suite"
+	pd "github.com/tikv/pd/client"
+)
+
+func TestPDSafePointKV(t *testing.T) {
+	suite.Run(t, new(testPDSafePointKVSuite))
+}
+
+type testPDSafePointKVSuite struct {
+	suite.Suite
+	store    tikv.StoreProbe
+	pdClient pd.Client
+	spkv     *tikv.PDSafePointKV
+}
+
+func (s *testPDSafePointKVSuite) SetupTest() {
+	client, cluster, pdClient, err := testutils.NewMockTiKV("", nil)
+	s.Require().Nil(err)
+	testutils.BootstrapWithSingleStore(cluster)
+	s.pdClient = &tikv.CodecPDClient{Client: pdClient}
+	s.spkv = tikv.NewPDSafePointKV(s.pdClient)
+	store, err := tikv.NewKVStore("pd-safepoint", s.pdClient, s.spkv, client)
+	s.Require().Nil(err)
+	s.store = tikv.StoreProbe{KVStore: store}
+}
+
+func (s *testPDSafePointKVSuite) TearDownTest() {
+	s.store.Close()
+}
+
+func (s *testPDSafePointKVSuite) pdSafePoint() uint64 {
+	safePoint, err := s.pdClient.UpdateGCSafePoint(context.Background(), 0)
+	s.Require().Nil(err)
+	return safePoint
+}
+
+func (s *testPDSafePointKVSuite) TestSafePoint() {
+	ctx := context.Background()
+	v, err := s.spkv.Get(tikv.GcSavedSafePoint)
+	s.Nil(err)
+	s.Empty(v)
+
+	// The safepoint is read-only, it's updated by GC after the locks are resolved.
+	s.NotNil(s.store.SaveSafePoint(100))
+	s.Zero(s.pdSafePoint())
+	_, err = s.spkv.CompareAndSwap(tikv.GcSavedSafePoint, "", "200")
+	s.NotNil(err)
+	s.NotNil(s.spkv.Delete(tikv.GcSavedSafePoint))
+
+	_, err = s.pdClient.UpdateGCSafePoint(ctx, 100)
+	s.Require().Nil(err)
+	safePoint, err := s.store.LoadSafePoint()
+	s.Nil(err)
+	s.Equal(uint64(100), safePoint)
+
+	safePoint, err = s.store.GC(ctx, 200)
+	s.Require().Nil(err)
+	s.Equal(uint64(200), safePoint)
+	v, err = s.spkv.Get(tikv.GcSavedSafePoint)
+	s.Nil(err)
+	s.Equal("200", v)
+}
+
+func (s *testPDSafePointKVSuite) TestLocalKeys() {
+	s.Nil(s.spkv.Put("/tidb/store/gcworker/a", "1"))
+	s.Nil(s.spkv.Put("/tidb/store/gcworker/b", "2"))
+	v, err := s.spkv.Get("/tidb/store/gcworker/a")
+	s.Nil(err)
+	s.Equal("1", v)
+	// The local keys are not stored in PD.
+	s.Zero(s.pdSafePoint())
+
+	ok, err := s.spkv.CompareAndSwap("/tidb/store/gcworker/c", "", "3")
+	s.Nil(err)
+	s.True(ok)
+	ok, err = s.spkv.CompareAndSwap("/tidb/store/gcworker/c", "", "4")
+	s.Nil(err)
+	s.False(ok)
+
+	kvs, err := s.spkv.GetWithPrefix("/tidb/store/gcworker/")
+	s.Nil(err)
+	s.Len(kvs, 3)
+	_, err = s.pdClient.UpdateGCSafePoint(context.Background(), 100)
+	s.Require().Nil(err)
+	kvs, err = s.spkv.GetWithPrefix("/tidb/store/gcworker/")
+	s.Nil(err)
+	s.Len(kvs, 4)
+	found := false
+	for _, kv := range kvs {
+		if string(kv.Key) == tikv.GcSavedSafePoint {
+			found = true
+			s.Equal(strconv.Itoa(100), string(kv.Value))
+		}
+	}
+	s.True(found)
+}
+
+func (s *testPDSafePointKVSuite) TestGCWorker() {
+	// The leadership kept in memory isn't shared, so the workers refuse to run
+	// instead of becoming leaders at the same time.
+	newWorker := func(owner string) *tikv.GCWorker {
+		return tikv.NewGCWorker(s.store.KVStore,
+			tikv.WithGCOwnerID(owner),
+			tikv.WithGCTickInterval(10*time.Millisecond),
+			tikv.WithGCRunInterval(time.Hour),
+			tikv.WithGCLifeTime(time.Minute))
+	}
+	w1, w2 := newWorker("a"), newWorker("b")
+	w1.Start()
+	defer w1.Close()
+	w2.Start()
+	defer w2.Close()
+	for _, w := range []*tikv.GCWorker{w1, w2} {
+		s.Eventually(func() bool {
+			return w.Status().LastError != nil
+		}, 5*time.Second, 10*time.Millisecond)
+		status := w.Status()
+		s.False(status.IsLeader)
+		s.Empty(status.Leader)
+		s.Nil(status.LastJob)
+	}
+	s.Zero(s.pdSafePoint())
+
+	_, err := s.store.DeleteRangeLater(context.Background(), []byte("a"), []byte("b"))
+	s.NotNil(err)
+}
+
+func (s *testPDSafePointKVSuite) TestDisableEtcdByConfig() {
+	defer config.UpdateGlobal(func(conf *config.Config) {
+		conf.PDClient.DisableEtcdSafePointKV = true
+	})()
+	spkv, err := tikv.NewSafePointKV(s.pdClient, []string{"127.0.0.1:1"}, nil)
+	s.Require().Nil(err)
+	defer spkv.Close()
+	s.IsType(&tikv.PDSafePointKV{}, spkv)
+}
+
+func (s *testPDSafePointKVSuite) TestFallbackOnUnreachableEtcd() {
+	start := time.Now()
+	spkv, err := tikv.NewSafePointKV(s.pdClient, []string{"127.0.0.1:1"}, nil)
+	s.Require().Nil(err)
+	defer spkv.Close()
+	s.IsType(&tikv.PDSafePointKV{}, spkv)
+	s.Less(time.Since(start), 5*time.Second)
+}
@@ -0,0 +1,217 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JK1Zhang/client-go/v3/tikv"
+	"github.com/JK1Zhang/client-go/v3/txnkv/queue"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestQueue(t *testing.T) {
+	suite.Run(t, new(testQueueSuite))
+}
+
+type testQueueSuite struct {
+	suite.Suite
+	store tikv.StoreProbe
+	clock *fakeClock
+}
+
+func (s *testQueueSuite) SetupTest() {
+	s.store = tikv.StoreProbe{KVStore: NewTestStore(s.T())}
+	s.clock = &fakeClock{now: time.Unix(1600000000, 0)}
+}
+
+func (s *testQueueSuite) TearDownTest() {
+	s.store.Close()
+}
+
+func (s *testQueueSuite) newQueue(opts ...queue.Option) *queue.Queue {
+	opts = append([]queue.Option{queue.WithClock(s.clock), queue.WithVisibilityTimeout(time.Minute)}, opts...)
+	return queue.NewQueue(s.store.KVStore, "q", opts...)
+}
+
+func (s *testQueueSuite) payloads(msgs []*queue.Message) []string {
+	var res []string
+	for _, msg := range msgs {
+		res = append(res, string(msg.Payload))
+	}
+	return res
+}
+
+func (s *testQueueSuite) mustDequeue(q *queue.Queue, max int) []*queue.Message {
+	msgs, err := q.Dequeue(context.Background(), max)
+	s.Require().Nil(err)
+	return msgs
+}
+
+func (s *testQueueSuite) TestFIFO() {
+	ctx := context.Background()
+	q := s.newQueue()
+	ids, err := q.Enqueue(ctx, []byte("a"), []byte("b"))
+	s.Nil(err)
+	s.Len(ids, 2)
+	txn, err := s.store.Begin()
+	s.Require().Nil(err)
+	_, err = q.EnqueueTxn(txn.KVTxn, []byte("c"))
+	s.Nil(err)
+	// The message enqueued in a transaction is invisible until it commits.
+	s.Empty(s.mustDequeue(queue.NewQueue(s.store.KVStore, "other"), 10))
+	s.Nil(txn.Commit(ctx))
+	s.Empty(s.mustDequeue(q, 0))
+	s.Empty(s.mustDequeue(q, -1))
+
+	msgs := s.mustDequeue(q, 2)
+	s.Equal([]string{"a", "b"}, s.payloads(msgs))
+	s.Equal(ids[0], msgs[0].ID)
+	s.Equal(1, msgs[0].Attempts)
+	s.Equal(s.clock.Now().Add(time.Minute), msgs[0].Deadline)
+	s.NotNil(msgs[0].Receipt)
+	s.Equal([]string{"c"}, s.payloads(s.mustDequeue(q, 10)))
+	s.Empty(s.mustDequeue(q, 10))
+
+	s.Nil(q.Ack(ctx, msgs[0].Receipt, msgs[1].Receipt))
+	s.Equal(queue.ErrInvalidReceipt, q.Ack(ctx, msgs[0].Receipt))
+	s.Empty(s.mustDequeue(q, 10))
+
+	// Queues of different names are independent.
+	s.Empty(s.mustDequeue(queue.NewQueue(s.store.KVStore, "other"), 10))
+}
+
+func (s *testQueueSuite) TestLease() {
+	ctx := context.Background()
+	q := s.newQueue()
+	_, err := q.Enqueue(ctx, []byte("a"), []byte("b"), []byte("c"))
+	s.Nil(err)
+	msgs := s.mustDequeue(q, 3)
+	s.Len(msgs, 3)
+
+	// Nack makes the message ready at its original position.
+	s.Nil(q.Nack(ctx, msgs[0].Receipt))
+	_, err = q.Enqueue(ctx, []byte("d"))
+	s.Nil(err)
+	redelivered := s.mustDequeue(q, 1)
+	s.Equal([]string{"a"}, s.payloads(redelivered))
+	s.Equal(2, redelivered[0].Attempts)
+
+	// An extended lease doesn't expire with the others.
+	receipt, err := q.Extend(ctx, msgs[1].Receipt, 5*time.Minute)
+	s.Nil(err)
+	s.Equal(queue.ErrInvalidReceipt, q.Ack(ctx, msgs[1].Receipt))
+	s.clock.Advance(2 * time.Minute)
+	// The expired messages are delivered before the ready ones.
+	redelivered = s.mustDequeue(q, 10)
+	s.Equal([]string{"a", "c", "d"}, s.payloads(redelivered))
+	s.Equal(3, redelivered[0].Attempts)
+	s.Equal(2, redelivered[1].Attempts)
+	s.Equal(queue.ErrInvalidReceipt, q.Ack(ctx, msgs[2].Receipt))
+	s.Nil(q.Ack(ctx, receipt))
+
+	// A failed batch acknowledges nothing.
+	s.Equal(queue.ErrInvalidReceipt, q.Ack(ctx, redelivered[0].Receipt, msgs[2].Receipt))
+	s.Nil(q.Ack(ctx, redelivered[0].Receipt, redelivered[1].Receipt, redelivered[2].Receipt))
+	s.clock.Advance(time.Hour)
+	s.Empty(s.mustDequeue(q, 10))
+}
+
+func (s *testQueueSuite) TestDeadLetter() {
+	ctx := context.Background()
+	q := s.newQueue(queue.WithMaxAttempts(2))
+	ids, err := q.Enqueue(ctx, []byte("a"), []byte("b"))
+	s.Nil(err)
+	for i := 0; i < 2; i++ {
+		msgs := s.mustDequeue(q, 1)
+		s.Equal([]string{"a"}, s.payloads(msgs))
+		s.Nil(q.Nack(ctx, msgs[0].Receipt))
+	}
+	// a is moved to the dead letters instead of being delivered the third time.
+	msgs := s.mustDequeue(q, 1)
+	s.Equal([]string{"b"}, s.payloads(msgs))
+	dead, err := q.DeadLetters(ctx, 10)
+	s.Nil(err)
+	s.Equal([]string{"a"}, s.payloads(dead))
+	s.Equal(ids[0], dead[0].ID)
+	s.Equal(2, dead[0].Attempts)
+	s.Nil(dead[0].Receipt)
+
+	s.Nil(q.Redrive(ctx, dead[0].ID))
+	dead, err = q.DeadLetters(ctx, 10)
+	s.Nil(err)
+	s.Empty(dead)
+	msgs = s.mustDequeue(q, 1)
+	s.Equal([]string{"a"}, s.payloads(msgs))
+	s.Equal(1, msgs[0].Attempts)
+}
+
+func (s *testQueueSuite) TestConcurrentConsumers() {
+	ctx := context.Background()
+	q := s.newQueue()
+	const n = 200
+	for i := 0; i < n; i += 50 {
+		var payloads [][]byte
+		for j := i; j < i+50; j++ {
+			payloads = append(payloads, []byte(fmt.Sprintf("m%03d", j)))
+		}
+		_, err := q.Enqueue(ctx, payloads...)
+		s.Require().Nil(err)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		received = make(map[string]int)
+	)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); {
+				msgs, err := q.Dequeue(ctx, 7)
+				s.Nil(err)
+				var receipts [][]byte
+				mu.Lock()
+				for _, msg := range msgs {
+					received[string(msg.Payload)]++
+					receipts = append(receipts, msg.Receipt)
+				}
+				done := len(received) == n
+				mu.Unlock()
+				if len(receipts) > 0 {
+					s.Nil(q.Ack(ctx, receipts...))
+				}
+				if done {
+					return
+				}
+				// The messages locked by other consumers are skipped, so an empty result
+				// doesn't mean the queue is empty.
+				if len(msgs) == 0 {
+					time.Sleep(time.Millisecond)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	s.Len(received, n)
+	for payload, count := range received {
+		s.Equal(1, count, payload)
+	}
+}
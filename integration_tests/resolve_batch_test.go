@@ -0,0 +1,195 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/JK1Zhang/client-go/v3/config"
+	"github.com/JK1Zhang/client-go/v3/oracle"
+	"github.com/JK1Zhang/client-go/v3/testutils"
+	"github.com/JK1Zhang/client-go/v3/tikv"
+	"github.com/JK1Zhang/client-go/v3/tikvrpc"
+	"github.com/JK1Zhang/client-go/v3/txnkv/txnlock"
+	"github.com/pingcap/failpoint"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestResolveBatch(t *testing.T) {
+	suite.Run(t, new(testResolveBatchSuite))
+}
+
+type testResolveBatchSuite struct {
+	suite.Suite
+	store        tikv.StoreProbe
+	resolveLocks int64
+}
+
+// resolveLockCounter counts the ResolveLock requests sent by the store.
+type resolveLockCounter struct {
+	tikv.Client
+	count *int64
+}
+
+func (c resolveLockCounter) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	if req.Type == tikvrpc.CmdResolveLock {
+		atomic.AddInt64(c.count, 1)
+	}
+	return c.Client.SendRequest(ctx, addr, req, timeout)
+}
+
+func (s *testResolveBatchSuite) SetupTest() {
+	client, cluster, pdClient, err := testutils.NewMockTiKV("", nil)
+	s.Require().Nil(err)
+	testutils.BootstrapWithMultiRegions(cluster, []byte("m"))
+	hijack := func(c tikv.Client) tikv.Client {
+		return resolveLockCounter{Client: c, count: &s.resolveLocks}
+	}
+	store, err := tikv.NewTestTiKVStore(client, pdClient, hijack, nil, 0)
+	s.Require().Nil(err)
+	s.store = tikv.StoreProbe{KVStore: store}
+	atomic.StoreInt64(&s.resolveLocks, 0)
+}
+
+func (s *testResolveBatchSuite) TearDownTest() {
+	s.store.Close()
+}
+
+func (s *testResolveBatchSuite) currentTS() uint64 {
+	ts, err := s.store.CurrentTimestamp(oracle.GlobalTxnScope)
+	s.Require().Nil(err)
+	return ts
+}
+
+// commitPrimaries runs n txns, each of which commits the primary key "a<i>" and
+// leaves the lock of the secondary key "n<i>".
+func (s *testResolveBatchSuite) commitPrimaries(n int) []*txnlock.Lock {
+	ctx := context.Background()
+	for i := 0; i < n; i++ {
+		txn, err := s.store.Begin()
+		s.Require().Nil(err)
+		primary := []byte(fmt.Sprintf("a%d", i))
+		s.Require().Nil(txn.Set(primary, primary))
+		s.Require().Nil(txn.Set([]byte(fmt.Sprintf("n%d", i)), primary))
+		committer, err := txn.NewCommitter(0)
+		s.Require().Nil(err)
+		committer.SetPrimaryKey(primary)
+		s.Require().Nil(committer.PrewriteAllMutations(ctx))
+		committer.SetCommitTS(s.currentTS())
+		s.Require().Nil(committer.CommitMutations(ctx))
+	}
+	return s.scanLocks()
+}
+
+func (s *testResolveBatchSuite) scanLocks() []*txnlock.Lock {
+	statuses, err := s.store.ScanLocks(context.Background(), nil, nil, s.currentTS(), 0)
+	s.Require().Nil(err)
+	locks := make([]*txnlock.Lock, len(statuses))
+	for i, l := range statuses {
+		locks[i] = l.Lock
+	}
+	return locks
+}
+
+func (s *testResolveBatchSuite) newBackoffer() *tikv.Backoffer {
+	return tikv.NewBackoffer(context.Background(), tikv.ConfigProbe{}.GetGetMaxBackoff())
+}
+
+func (s *testResolveBatchSuite) TestBatchPerRegion() {
+	defer config.UpdateGlobal(func(conf *config.Config) {
+		conf.TiKVClient.ResolveLockLiteThreshold = 0
+	})()
+	locks := s.commitPrimaries(4)
+	s.Len(locks, 4)
+
+	lr := s.store.NewLockResolver()
+	defer lr.Close()
+	atomic.StoreInt64(&s.resolveLocks, 0)
+	msBeforeExpired, err := lr.ResolveLocks(s.newBackoffer(), 0, locks)
+	s.Require().Nil(err)
+	s.Zero(msBeforeExpired)
+	// The locks of all the txns are in the same region.
+	s.Equal(int64(1), atomic.LoadInt64(&s.resolveLocks))
+	s.Empty(s.scanLocks())
+}
+
+func (s *testResolveBatchSuite) TestBatchLite() {
+	locks := s.commitPrimaries(4)
+
+	lr := s.store.NewLockResolver()
+	defer lr.Close()
+	atomic.StoreInt64(&s.resolveLocks, 0)
+	_, err := lr.ResolveLocks(s.newBackoffer(), 0, locks)
+	s.Require().Nil(err)
+	// The small txns are resolved by the keys, one request per txn.
+	s.Equal(int64(4), atomic.LoadInt64(&s.resolveLocks))
+	s.Empty(s.scanLocks())
+}
+
+func (s *testResolveBatchSuite) TestConcurrentTxnStatus() {
+	locks := s.commitPrimaries(8)
+
+	s.Require().Nil(failpoint.Enable("tikvclient/getTxnStatusDelay", "return"))
+	defer func() {
+		s.Require().Nil(failpoint.Disable("tikvclient/getTxnStatusDelay"))
+	}()
+	lr := s.store.NewLockResolver()
+	defer lr.Close()
+	start := time.Now()
+	_, err := lr.ResolveLocks(s.newBackoffer(), 0, locks)
+	s.Require().Nil(err)
+	// Each check takes 100ms, they would take 800ms if checked one by one.
+	s.Less(time.Since(start), 400*time.Millisecond)
+	s.Empty(s.scanLocks())
+}
+
+func (s *testResolveBatchSuite) TestAsyncForRead() {
+	locks := s.commitPrimaries(4)
+
+	s.Require().Nil(failpoint.Enable("tikvclient/resolveLock", "sleep(300)"))
+	defer func() {
+		s.Require().Nil(failpoint.Disable("tikvclient/resolveLock"))
+	}()
+	lr := s.store.NewLockResolver()
+	defer lr.Close()
+	start := time.Now()
+	msBeforeExpired, canIgnore, canAccess, err := lr.ResolveLocksForRead(s.newBackoffer(), s.currentTS(), locks, false)
+	s.Require().Nil(err)
+	s.Zero(msBeforeExpired)
+	s.Empty(canIgnore)
+	s.Len(canAccess, 4)
+	// The read proceeds while the locks are resolved in the background.
+	s.Less(time.Since(start), 200*time.Millisecond)
+	s.Len(s.scanLocks(), 4)
+	s.Eventually(func() bool { return len(s.scanLocks()) == 0 }, 5*time.Second, 50*time.Millisecond)
+}
+
+func (s *testResolveBatchSuite) TestSyncForRead() {
+	defer config.UpdateGlobal(func(conf *config.Config) {
+		conf.TiKVClient.AsyncResolveLockForRead = false
+	})()
+	locks := s.commitPrimaries(4)
+
+	lr := s.store.NewLockResolver()
+	defer lr.Close()
+	_, _, canAccess, err := lr.ResolveLocksForRead(s.newBackoffer(), s.currentTS(), locks, false)
+	s.Require().Nil(err)
+	s.Len(canAccess, 4)
+	s.Empty(s.scanLocks())
+}
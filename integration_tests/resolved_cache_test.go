@@ -0,0 +1,156 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/JK1Zhang/client-go/v3/config"
+	"github.com/JK1Zhang/client-go/v3/oracle"
+	"github.com/JK1Zhang/client-go/v3/testutils"
+	"github.com/JK1Zhang/client-go/v3/tikv"
+	"github.com/JK1Zhang/client-go/v3/txnkv"
+	"github.com/JK1Zhang/client-go/v3/txnkv/txnlock"
+	"github.com/stretchr/testify/suite"
+	pd "github.com/tikv/pd/client"
+)
+
+func TestResolvedCache(t *testing.T) {
+	suite.Run(t, new(testResolvedCacheSuite))
+}
+
+type testResolvedCacheSuite struct {
+	suite.Suite
+	client   tikv.Client
+	pdClient pd.Client
+}
+
+func (s *testResolvedCacheSuite) SetupTest() {
+	client, cluster, pdClient, err := testutils.NewMockTiKV("", nil)
+	s.Require().Nil(err)
+	testutils.BootstrapWithSingleStore(cluster)
+	s.client, s.pdClient = client, pdClient
+}
+
+func (s *testResolvedCacheSuite) TearDownTest() {
+	s.Require().Nil(s.client.Close())
+}
+
+// sharedClient is shared by the stores, which is closed in TearDownTest.
+type sharedClient struct {
+	tikv.Client
+}
+
+func (c sharedClient) Close() error {
+	return nil
+}
+
+func (s *testResolvedCacheSuite) newStore() tikv.StoreProbe {
+	store, err := tikv.NewTestTiKVStore(sharedClient{s.client}, s.pdClient, nil, nil, 0)
+	s.Require().Nil(err)
+	return tikv.StoreProbe{KVStore: store}
+}
+
+// commitPrimary commits the primary key of a transaction writing the keys, and
+// leaves the locks of the secondary keys.
+func (s *testResolvedCacheSuite) commitPrimary(store tikv.StoreProbe, keys ...string) uint64 {
+	ctx := context.Background()
+	txn, err := store.Begin()
+	s.Require().Nil(err)
+	for _, k := range keys {
+		s.Require().Nil(txn.Set([]byte(k), []byte(k)))
+	}
+	committer, err := txn.NewCommitter(0)
+	s.Require().Nil(err)
+	committer.SetPrimaryKey([]byte(keys[0]))
+	s.Require().Nil(committer.PrewriteAllMutations(ctx))
+	commitTS, err := store.CurrentTimestamp(oracle.GlobalTxnScope)
+	s.Require().Nil(err)
+	committer.SetCommitTS(commitTS)
+	s.Require().Nil(committer.CommitMutations(ctx))
+	return txn.StartTS()
+}
+
+func (s *testResolvedCacheSuite) resolveLocks(store tikv.StoreProbe) {
+	ctx := context.Background()
+	ts, err := store.CurrentTimestamp(oracle.GlobalTxnScope)
+	s.Require().Nil(err)
+	locks, err := store.ScanLocks(ctx, nil, nil, ts, 0)
+	s.Require().Nil(err)
+	_, err = store.ResolveDeadLocks(ctx, locks)
+	s.Require().Nil(err)
+}
+
+func (s *testResolvedCacheSuite) TestLRU() {
+	status := txnlock.LockProbe{}.NewLockStatus(nil, false, 0)
+	cache := txnkv.NewLRUResolvedCache(2, 0)
+	cache.Put(1, status)
+	cache.Put(2, status)
+	_, ok := cache.Get(1)
+	s.True(ok)
+	// 2 is the least recently used one.
+	cache.Put(3, status)
+	s.Equal(2, cache.Len())
+	_, ok = cache.Get(2)
+	s.False(ok)
+	_, ok = cache.Get(1)
+	s.True(ok)
+	_, ok = cache.Get(3)
+	s.True(ok)
+
+	cache = txnkv.NewLRUResolvedCache(2, 50*time.Millisecond)
+	cache.Put(1, status)
+	_, ok = cache.Get(1)
+	s.True(ok)
+	time.Sleep(100 * time.Millisecond)
+	_, ok = cache.Get(1)
+	s.False(ok)
+	s.Zero(cache.Len())
+}
+
+func (s *testResolvedCacheSuite) TestShared() {
+	store1, store2 := s.newStore(), s.newStore()
+	defer store1.Close()
+	defer store2.Close()
+	s.NotSame(store1.GetLockResolver().GetResolvedCache(), store2.GetLockResolver().GetResolvedCache())
+
+	cache := txnkv.NewLRUResolvedCache(16, time.Minute)
+	store1.SetResolvedCache(cache)
+	store2.SetResolvedCache(cache)
+	startTS := s.commitPrimary(store1, "a", "b")
+	s.resolveLocks(store1)
+	status, ok := store2.GetLockResolver().GetResolvedCache().Get(startTS)
+	s.True(ok)
+	s.True(status.IsCommitted())
+}
+
+func (s *testResolvedCacheSuite) TestSharedByConfig() {
+	defer config.UpdateGlobal(func(conf *config.Config) {
+		conf.TiKVClient.ResolvedCache.Shared = true
+		conf.TiKVClient.ResolvedCache.Capacity = 16
+	})()
+	store1, store2 := s.newStore(), s.newStore()
+	defer store1.Close()
+	defer store2.Close()
+	cache := store1.GetLockResolver().GetResolvedCache()
+	s.Same(cache, store2.GetLockResolver().GetResolvedCache())
+
+	startTS := s.commitPrimary(store2, "a", "b")
+	s.resolveLocks(store2)
+	_, ok := cache.Get(startTS)
+	s.True(ok)
+}
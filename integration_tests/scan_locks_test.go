@@ -0,0 +1,130 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/JK1Zhang/client-go/v3/testutils"
+	"github.com/JK1Zhang/client-go/v3/tikv"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestScanLocks(t *testing.T) {
+	suite.Run(t, new(testScanLocksSuite))
+}
+
+type testScanLocksSuite struct {
+	suite.Suite
+	store tikv.StoreProbe
+}
+
+func (s *testScanLocksSuite) SetupTest() {
+	client, cluster, pdClient, err := testutils.NewMockTiKV("", nil)
+	s.Require().Nil(err)
+	testutils.BootstrapWithMultiRegions(cluster, []byte("m"))
+	store, err := tikv.NewTestTiKVStore(client, pdClient, nil, nil, 0)
+	s.Require().Nil(err)
+	s.store = tikv.StoreProbe{KVStore: store}
+}
+
+func (s *testScanLocksSuite) TearDownTest() {
+	s.store.Close()
+}
+
+func (s *testScanLocksSuite) keys(locks []*tikv.LockStatus) []string {
+	keys := make([]string, 0, len(locks))
+	for _, l := range locks {
+		keys = append(keys, string(l.Key))
+	}
+	return keys
+}
+
+func (s *testScanLocksSuite) TestScanLocks() {
+	ctx := context.Background()
+	hour := uint64(time.Hour / time.Millisecond)
+	alive := mustPrewrite(s.T(), s.store, hour, false, "a", "a", "n")
+	committed := mustPrewrite(s.T(), s.store, hour, true, "b", "b", "o")
+	expired := mustPrewrite(s.T(), s.store, 1, false, "c", "c", "p")
+	// The primary keys of the transactions are not written.
+	mustPrewrite(s.T(), s.store, 1, false, "z", "d")
+	mustPrewrite(s.T(), s.store, hour, false, "z", "e")
+	time.Sleep(10 * time.Millisecond)
+	maxTS := mustCurrentTS(s.T(), s.store)
+
+	locks, err := s.store.ScanLocks(ctx, nil, nil, maxTS, 0)
+	s.Require().Nil(err)
+	s.Equal([]string{"a", "c", "d", "e", "n", "o", "p"}, s.keys(locks))
+	s.Equal(alive, locks[0].TxnID)
+	s.Equal([]byte("a"), locks[0].Primary)
+	s.Equal(tikv.GCTxnAlive, locks[0].State)
+	s.NotZero(locks[0].PrimaryTTL)
+	s.False(locks[0].IsOwnerDead())
+	s.Equal(expired, locks[1].TxnID)
+	s.Equal(tikv.GCTxnExpired, locks[1].State)
+	s.True(locks[1].IsOwnerDead())
+	s.Equal(tikv.GCTxnNotFound, locks[2].State)
+	s.True(locks[2].IsOwnerDead())
+	s.Equal(tikv.GCTxnNotFound, locks[3].State)
+	s.False(locks[3].IsOwnerDead())
+	s.Equal(tikv.GCTxnAlive, locks[4].State)
+	s.Equal(committed, locks[5].TxnID)
+	s.Equal(tikv.GCTxnCommitted, locks[5].State)
+	s.Greater(locks[5].CommitTS, committed)
+	s.True(locks[5].IsOwnerDead())
+	s.Equal(tikv.GCTxnExpired, locks[6].State)
+
+	locks, err = s.store.ScanLocks(ctx, []byte("b"), []byte("o"), maxTS, 0)
+	s.Require().Nil(err)
+	s.Equal([]string{"c", "d", "e", "n"}, s.keys(locks))
+	locks, err = s.store.ScanLocks(ctx, []byte("d"), nil, maxTS, 3)
+	s.Require().Nil(err)
+	s.Equal([]string{"d", "e", "n"}, s.keys(locks))
+	locks, err = s.store.ScanLocks(ctx, nil, nil, alive, 0)
+	s.Require().Nil(err)
+	s.Equal([]string{"a", "n"}, s.keys(locks))
+
+	// ScanLocks doesn't change anything.
+	locks, err = s.store.ScanLocks(ctx, nil, nil, maxTS, 0)
+	s.Require().Nil(err)
+	s.Len(locks, 7)
+	s.Equal(tikv.GCTxnExpired, locks[1].State)
+
+	resolved, err := s.store.ResolveDeadLocks(ctx, locks)
+	s.Require().Nil(err)
+	s.Equal(4, resolved)
+	locks, err = s.store.ScanLocks(ctx, nil, nil, maxTS, 0)
+	s.Require().Nil(err)
+	s.Equal([]string{"a", "e", "n"}, s.keys(locks))
+	resolved, err = s.store.ResolveDeadLocks(ctx, locks)
+	s.Require().Nil(err)
+	s.Zero(resolved)
+
+	// A transaction renewed after the scan doesn't fail the others.
+	mustPrewrite(s.T(), s.store, 1, false, "f", "f")
+	time.Sleep(10 * time.Millisecond)
+	locks, err = s.store.ScanLocks(ctx, nil, nil, mustCurrentTS(s.T(), s.store), 0)
+	s.Require().Nil(err)
+	s.Equal([]string{"a", "e", "f", "n"}, s.keys(locks))
+	locks[0].State = tikv.GCTxnExpired
+	resolved, err = s.store.ResolveDeadLocks(ctx, locks)
+	s.NotNil(err)
+	s.Equal(1, resolved)
+	locks, err = s.store.ScanLocks(ctx, nil, nil, maxTS, 0)
+	s.Require().Nil(err)
+	s.Equal([]string{"a", "e", "n"}, s.keys(locks))
+}
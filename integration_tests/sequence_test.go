@@ -0,0 +1,169 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JK1Zhang/client-go/v3/tikv"
+	"github.com/JK1Zhang/client-go/v3/txnkv"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestSequence(t *testing.T) {
+	suite.Run(t, new(testSequenceSuite))
+}
+
+type testSequenceSuite struct {
+	suite.Suite
+	store tikv.StoreProbe
+}
+
+func (s *testSequenceSuite) SetupTest() {
+	s.store = tikv.StoreProbe{KVStore: NewTestStore(s.T())}
+}
+
+func (s *testSequenceSuite) TearDownTest() {
+	s.store.Close()
+}
+
+// stored returns the next ID not reserved of the sequence.
+func (s *testSequenceSuite) stored(name string) uint64 {
+	txn, err := s.store.Begin()
+	s.Require().Nil(err)
+	v, err := txn.Get(context.Background(), []byte("_sequence_"+name))
+	s.Require().Nil(err)
+	return binary.BigEndian.Uint64(v)
+}
+
+func (s *testSequenceSuite) TestNext() {
+	ctx := context.Background()
+	a := txnkv.NewSequenceAllocator(s.store.KVStore, txnkv.WithSequenceStep(10), txnkv.WithSequencePrefetch(0))
+	defer a.Close()
+	for i := uint64(1); i <= 25; i++ {
+		id, err := a.Next(ctx, "a")
+		s.Nil(err)
+		s.Equal(i, id)
+	}
+	s.Equal(uint64(31), s.stored("a"))
+
+	// Sequences are independent.
+	id, err := a.Next(ctx, "b")
+	s.Nil(err)
+	s.Equal(uint64(1), id)
+	s.Equal(uint64(11), s.stored("b"))
+
+	// Another allocator continues after the reserved blocks.
+	b := txnkv.NewSequenceAllocator(s.store.KVStore, txnkv.WithSequenceStep(10))
+	id, err = b.Next(ctx, "a")
+	s.Nil(err)
+	s.Equal(uint64(31), id)
+	b.Close()
+	_, err = b.Next(ctx, "a")
+	s.NotNil(err)
+
+	c := txnkv.NewSequenceAllocator(s.store.KVStore, txnkv.WithSequenceStart(100))
+	defer c.Close()
+	id, err = c.Next(ctx, "c")
+	s.Nil(err)
+	s.Equal(uint64(100), id)
+}
+
+func (s *testSequenceSuite) TestPrefetch() {
+	ctx := context.Background()
+	a := txnkv.NewSequenceAllocator(s.store.KVStore, txnkv.WithSequenceStep(10), txnkv.WithSequencePrefetch(5))
+	defer a.Close()
+	for i := uint64(1); i <= 5; i++ {
+		id, err := a.Next(ctx, "a")
+		s.Nil(err)
+		s.Equal(i, id)
+	}
+	s.Equal(uint64(11), s.stored("a"))
+	// 4 IDs are left, the next block is reserved in the background.
+	id, err := a.Next(ctx, "a")
+	s.Nil(err)
+	s.Equal(uint64(6), id)
+	s.Eventually(func() bool { return s.stored("a") == 21 }, 5*time.Second, 10*time.Millisecond)
+	for i := uint64(7); i <= 20; i++ {
+		id, err := a.Next(ctx, "a")
+		s.Nil(err)
+		s.Equal(i, id)
+	}
+}
+
+func (s *testSequenceSuite) TestConcurrent() {
+	ctx := context.Background()
+	const workers, n = 4, 50
+	for _, strict := range []bool{false, true} {
+		name := "nonstrict"
+		if strict {
+			name = "strict"
+		}
+		allocators := []*txnkv.SequenceAllocator{
+			txnkv.NewSequenceAllocator(s.store.KVStore, txnkv.WithSequenceStep(7), txnkv.WithSequenceStrict(strict)),
+			txnkv.NewSequenceAllocator(s.store.KVStore, txnkv.WithSequenceStep(7), txnkv.WithSequenceStrict(strict)),
+		}
+		var (
+			wg  sync.WaitGroup
+			mu  sync.Mutex
+			ids = make(map[uint64]struct{})
+		)
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func(a *txnkv.SequenceAllocator) {
+				defer wg.Done()
+				var last uint64
+				for j := 0; j < n; j++ {
+					id, err := a.Next(ctx, name)
+					s.Nil(err)
+					// The IDs of an allocator are increasing.
+					s.Greater(id, last)
+					last = id
+					mu.Lock()
+					ids[id] = struct{}{}
+					mu.Unlock()
+				}
+			}(allocators[i%len(allocators)])
+		}
+		wg.Wait()
+		s.Len(ids, workers*n, name)
+		for _, a := range allocators {
+			a.Close()
+		}
+	}
+}
+
+func (s *testSequenceSuite) TestStrict() {
+	ctx := context.Background()
+	a := txnkv.NewSequenceAllocator(s.store.KVStore, txnkv.WithSequenceStrict(true))
+	defer a.Close()
+	b := txnkv.NewSequenceAllocator(s.store.KVStore, txnkv.WithSequenceStrict(true))
+	defer b.Close()
+	// The IDs are increasing across allocators.
+	for i := uint64(1); i <= 10; i++ {
+		alloc := a
+		if i%2 == 0 {
+			alloc = b
+		}
+		id, err := alloc.Next(ctx, "s")
+		s.Nil(err)
+		s.Equal(i, id)
+	}
+	s.Equal(uint64(11), s.stored("s"))
+}
@@ -0,0 +1,125 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	tikverr "github.com/JK1Zhang/client-go/v3/error"
+	"github.com/JK1Zhang/client-go/v3/oracle"
+	"github.com/JK1Zhang/client-go/v3/tikv"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestServiceSafePoint(t *testing.T) {
+	suite.Run(t, new(testServiceSafePointSuite))
+}
+
+type testServiceSafePointSuite struct {
+	suite.Suite
+	store tikv.StoreProbe
+}
+
+func (s *testServiceSafePointSuite) SetupTest() {
+	s.store = tikv.StoreProbe{KVStore: NewTestStore(s.T())}
+}
+
+func (s *testServiceSafePointSuite) TearDownTest() {
+	s.store.Close()
+}
+
+func (s *testServiceSafePointSuite) currentTS() uint64 {
+	ts, err := s.store.CurrentTimestamp(oracle.GlobalTxnScope)
+	s.Require().Nil(err)
+	return ts
+}
+
+func (s *testServiceSafePointSuite) isGCTooEarly(err error) bool {
+	_, ok := errors.Cause(err).(*tikverr.ErrGCTooEarly)
+	return ok
+}
+
+func (s *testServiceSafePointSuite) TestRegister() {
+	ctx := context.Background()
+	ts := s.currentTS()
+	s.Require().Nil(s.store.RegisterServiceSafePoint(ctx, "svc", ts, time.Minute))
+
+	// GC doesn't pass the service safepoint.
+	safePoint, err := s.store.GC(ctx, s.currentTS())
+	s.Nil(err)
+	s.Equal(ts, safePoint)
+
+	// The reads protected by the service safepoint are visible even if the cached
+	// safepoint is larger.
+	s.store.UpdateSPCache(ts+10, time.Now())
+	s.Nil(s.store.CheckVisibility(ts))
+	s.Nil(s.store.CheckVisibility(ts + 5))
+	s.True(s.isGCTooEarly(s.store.CheckVisibility(ts - 1)))
+
+	s.Nil(s.store.UnregisterServiceSafePoint(ctx, "svc"))
+	s.Nil(s.store.UnregisterServiceSafePoint(ctx, "svc"))
+	s.True(s.isGCTooEarly(s.store.CheckVisibility(ts)))
+
+	// GC has advanced past ts.
+	s.True(s.isGCTooEarly(s.store.RegisterServiceSafePoint(ctx, "svc", ts, time.Minute)))
+	s.store.UpdateSPCache(0, time.Now())
+	s.True(s.isGCTooEarly(s.store.RegisterServiceSafePoint(ctx, "svc", ts-1, time.Minute)))
+}
+
+func (s *testServiceSafePointSuite) TestZeroGCWorker() {
+	ctx := context.Background()
+	// PD keeps a "gc_worker" service safepoint at 0 if nothing else is registered.
+	_, err := s.store.GetPDClient().UpdateServiceGCSafePoint(ctx, "gc_worker", math.MaxInt64, 0)
+	s.Require().Nil(err)
+	ts := s.currentTS()
+	s.Require().Nil(s.store.RegisterServiceSafePoint(ctx, "svc", ts, time.Minute))
+
+	safePoint, err := s.store.GC(ctx, s.currentTS())
+	s.Nil(err)
+	s.Equal(ts, safePoint)
+	// The service safepoint of the GC worker is moved forward by GC.
+	minSafePoint, err := s.store.GetPDClient().UpdateServiceGCSafePoint(ctx, "probe", 0, 0)
+	s.Nil(err)
+	s.Equal(ts, minSafePoint)
+
+	// A service safepoint below the GC safepoint is rejected.
+	s.Nil(s.store.UnregisterServiceSafePoint(ctx, "svc"))
+	s.store.UpdateSPCache(0, time.Now())
+	s.True(s.isGCTooEarly(s.store.RegisterServiceSafePoint(ctx, "svc", ts-1, time.Minute)))
+}
+
+func (s *testServiceSafePointSuite) TestRenew() {
+	ctx := context.Background()
+	ts := s.currentTS()
+	s.Require().Nil(s.store.RegisterServiceSafePoint(ctx, "svc", ts, time.Second))
+	time.Sleep(1500 * time.Millisecond)
+	s.store.UpdateSPCache(ts+10, time.Now())
+	s.Nil(s.store.CheckVisibility(ts))
+	minSafePoint, err := s.store.GetPDClient().UpdateServiceGCSafePoint(ctx, "probe", 1, 0)
+	s.Nil(err)
+	s.Equal(ts, minSafePoint)
+
+	// Registering again replaces the previous registration.
+	s.Require().Nil(s.store.RegisterServiceSafePoint(ctx, "svc", ts+20, time.Second))
+	s.True(s.isGCTooEarly(s.store.CheckVisibility(ts)))
+	s.Nil(s.store.UnregisterServiceSafePoint(ctx, "svc"))
+	minSafePoint, err = s.store.GetPDClient().UpdateServiceGCSafePoint(ctx, "probe", 1, 0)
+	s.Nil(err)
+	s.Zero(minSafePoint)
+}
@@ -0,0 +1,162 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	tikverr "github.com/JK1Zhang/client-go/v3/error"
+	"github.com/JK1Zhang/client-go/v3/oracle"
+	"github.com/JK1Zhang/client-go/v3/tikv"
+	"github.com/pingcap/failpoint"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestStaleRead(t *testing.T) {
+	suite.Run(t, new(testStaleReadSuite))
+}
+
+type testStaleReadSuite struct {
+	suite.Suite
+	store  tikv.StoreProbe
+	prefix string
+}
+
+func (s *testStaleReadSuite) SetupSuite() {
+	s.store = tikv.StoreProbe{KVStore: NewTestStore(s.T())}
+	s.prefix = fmt.Sprintf("stale_read_%d", time.Now().Unix())
+}
+
+func (s *testStaleReadSuite) TearDownSuite() {
+	s.Require().Nil(s.store.Close())
+}
+
+func (s *testStaleReadSuite) put(key, value []byte) uint64 {
+	txn, err := s.store.Begin()
+	s.Require().Nil(err)
+	s.Require().Nil(txn.Set(key, value))
+	s.Require().Nil(txn.Commit(context.Background()))
+	return txn.GetCommitTS()
+}
+
+func (s *testStaleReadSuite) TestExactTS() {
+	key := encodeKey(s.prefix, "exact")
+	commitTS1 := s.put(key, []byte("v1"))
+	commitTS2 := s.put(key, []byte("v2"))
+
+	txn, err := s.store.BeginStaleRead(context.Background(), tikv.WithStaleReadTS(commitTS1))
+	s.Require().Nil(err)
+	s.Equal(commitTS1, txn.StartTS())
+	s.True(txn.GetSnapshot().IsStatenessReadOnly())
+	val, err := txn.Get(context.Background(), key)
+	s.Nil(err)
+	s.Equal([]byte("v1"), val)
+
+	txn, err = s.store.BeginStaleRead(context.Background(), tikv.WithStaleReadTS(commitTS2))
+	s.Require().Nil(err)
+	val, err = txn.Get(context.Background(), key)
+	s.Nil(err)
+	s.Equal([]byte("v2"), val)
+
+	_, err = s.store.BeginStaleRead(context.Background(), tikv.WithStaleReadTS(oracle.GoTimeToTS(time.Now().Add(time.Hour))))
+	s.NotNil(err)
+}
+
+func (s *testStaleReadSuite) TestExactStaleness() {
+	now, err := s.store.CurrentTimestamp(oracle.GlobalTxnScope)
+	s.Require().Nil(err)
+	txn, err := s.store.BeginStaleRead(context.Background(), tikv.WithExactStaleness(5))
+	s.Require().Nil(err)
+	staleness := oracle.GetTimeFromTS(now).Sub(oracle.GetTimeFromTS(txn.StartTS()))
+	s.GreaterOrEqual(staleness, 4*time.Second)
+	s.LessOrEqual(staleness, 6*time.Second)
+}
+
+func (s *testStaleReadSuite) TestMaxSafeTS() {
+	_, err := s.store.BeginStaleRead(context.Background(), tikv.WithMaxSafeTS())
+	s.NotNil(err)
+
+	key := encodeKey(s.prefix, "safe_ts")
+	commitTS := s.put(key, []byte("v1"))
+	s.put(key, []byte("v2"))
+
+	s.Nil(failpoint.Enable("tikvclient/injectSafeTS", fmt.Sprintf("return(%d)", commitTS)))
+	defer failpoint.Disable("tikvclient/injectSafeTS")
+
+	txn, err := s.store.BeginStaleRead(context.Background())
+	s.Require().Nil(err)
+	s.Equal(commitTS, txn.StartTS())
+	val, err := txn.Get(context.Background(), key)
+	s.Nil(err)
+	s.Equal([]byte("v1"), val)
+}
+
+func (s *testStaleReadSuite) TestBoundedStaleness() {
+	now, err := s.store.CurrentTimestamp(oracle.GlobalTxnScope)
+	s.Require().Nil(err)
+	minTS := now - 1000
+	maxTS := now - 10
+
+	s.Nil(failpoint.Enable("tikvclient/injectSafeTS", fmt.Sprintf("return(%d)", now-100)))
+	txn, err := s.store.BeginStaleRead(context.Background(), tikv.WithBoundedStaleness(minTS, maxTS))
+	s.Require().Nil(err)
+	s.Equal(now-100, txn.StartTS())
+
+	s.Nil(failpoint.Enable("tikvclient/injectSafeTS", fmt.Sprintf("return(%d)", now-2000)))
+	txn, err = s.store.BeginStaleRead(context.Background(), tikv.WithBoundedStaleness(minTS, maxTS))
+	s.Require().Nil(err)
+	s.Equal(minTS, txn.StartTS())
+
+	s.Nil(failpoint.Enable("tikvclient/injectSafeTS", fmt.Sprintf("return(%d)", now)))
+	txn, err = s.store.BeginStaleRead(context.Background(), tikv.WithBoundedStaleness(minTS, maxTS))
+	s.Require().Nil(err)
+	s.Equal(maxTS, txn.StartTS())
+	s.Nil(failpoint.Disable("tikvclient/injectSafeTS"))
+
+	_, err = s.store.BeginStaleRead(context.Background(), tikv.WithBoundedStaleness(maxTS, minTS))
+	s.NotNil(err)
+
+	txn, err = s.store.BeginStaleRead(context.Background(), tikv.WithMaxStaleness(5))
+	s.Require().Nil(err)
+	s.Less(txn.StartTS(), now)
+}
+
+func (s *testStaleReadSuite) TestZeroMaxStaleness() {
+	now, err := s.store.CurrentTimestamp(oracle.GlobalTxnScope)
+	s.Require().Nil(err)
+	s.Nil(failpoint.Enable("tikvclient/injectSafeTS", fmt.Sprintf("return(%d)", now-1000)))
+	defer failpoint.Disable("tikvclient/injectSafeTS")
+
+	// No staleness is allowed, so the data is read at the current ts.
+	txn, err := s.store.BeginStaleRead(context.Background(), tikv.WithMaxStaleness(0))
+	s.Require().Nil(err)
+	s.Greater(txn.StartTS(), now)
+}
+
+func (s *testStaleReadSuite) TestWriteNotAllowed() {
+	txn, err := s.store.BeginStaleRead(context.Background(), tikv.WithExactStaleness(1))
+	s.Require().Nil(err)
+	s.Nil(txn.Set(encodeKey(s.prefix, "write"), []byte("v")))
+	err = txn.Commit(context.Background())
+	s.True(errors.Is(err, tikverr.ErrWriteInStaleReadTxn))
+
+	txn, err = s.store.BeginStaleRead(context.Background(), tikv.WithExactStaleness(1))
+	s.Require().Nil(err)
+	s.Nil(txn.Commit(context.Background()))
+}
@@ -44,6 +44,7 @@ import (
 
 <<<<<<< HEAD
 	"github.com/JK1Zhang/client-go/v3/config"
+	"github.com/JK1Zhang/client-go/v3/oracle"
 	"github.com/JK1Zhang/client-go/v3/testutils"
 	"github.com/JK1Zhang/client-go/v3/tikv"
 	"github.com/JK1Zhang/client-go/v3/txnkv/transaction"
@@ -57,6 +58,7 @@ import (
 <<<<<<< HEAD
 =======
 	"github.com/tikv/client-go/v2/config"
+	"github.com/tikv/client-go/v2/oracle"
 	"github.com/tikv/client-go/v2/testutils"
 	"github.com/tikv/client-go/v2/tikv"
 	"github.com/tikv/client-go/v2/txnkv/transaction"
@@ -153,6 +155,40 @@ func s08d(prefix string, n int) string {
 	return fmt.Sprintf("%s%08d", prefix, n)
 }
 
+func mustCurrentTS(t *testing.T, store tikv.StoreProbe) uint64 {
+	ts, err := store.CurrentTimestamp(oracle.GlobalTxnScope)
+	require.Nil(t, err)
+	return ts
+}
+
+// mustPrewrite prewrites the keys in a new transaction and returns its start ts.
+// See mustPrewriteTxn for the arguments.
+func mustPrewrite(t *testing.T, store tikv.StoreProbe, ttl uint64, commitPrimary bool, primary string, keys ...string) uint64 {
+	txn, err := store.Begin()
+	require.Nil(t, err)
+	mustPrewriteTxn(t, store, txn, ttl, commitPrimary, primary, keys...)
+	return txn.StartTS()
+}
+
+// mustPrewriteTxn sets the keys in txn and prewrites them with the given primary
+// key and lock ttl. The primary key is committed if commitPrimary is set, which
+// leaves the secondary locks behind as if the committer crashed.
+func mustPrewriteTxn(t *testing.T, store tikv.StoreProbe, txn transaction.TxnProbe, ttl uint64, commitPrimary bool, primary string, keys ...string) {
+	ctx := context.Background()
+	for _, k := range keys {
+		require.Nil(t, txn.Set([]byte(k), []byte(k)))
+	}
+	committer, err := txn.NewCommitter(0)
+	require.Nil(t, err)
+	committer.SetPrimaryKey([]byte(primary))
+	committer.SetLockTTL(ttl)
+	require.Nil(t, committer.PrewriteAllMutations(ctx))
+	if commitPrimary {
+		committer.SetCommitTS(mustCurrentTS(t, store))
+		require.Nil(t, committer.CommitMutations(ctx))
+	}
+}
+
 func toTiDBTxn(txn *transaction.TxnProbe) kv.Transaction {
 	return txndriver.NewTiKVTxn(txn.KVTxn)
 }
@@ -1081,6 +1081,41 @@ func (c *RegionCache) LoadRegionsInKeyRange(bo *retry.Backoffer, startKey, endKe
 	return
 }
 
+// RecentRegions returns at most limit cached regions which are accessed most
+// recently, in the descending order of the last access time. The regions out of
+// date are skipped. A non-positive limit means no limit.
+func (c *RegionCache) RecentRegions(limit int) []*Region {
+	type accessedRegion struct {
+		region     *Region
+		lastAccess int64
+	}
+	ts := time.Now().Unix()
+	c.mu.RLock()
+	accessed := make([]accessedRegion, 0, len(c.mu.latestVersions))
+	for _, ver := range c.mu.latestVersions {
+		r, ok := c.mu.regions[ver]
+		if !ok || r.checkNeedReload() {
+			continue
+		}
+		lastAccess := atomic.LoadInt64(&r.lastAccess)
+		if ts-lastAccess > regionCacheTTLSec {
+			continue
+		}
+		accessed = append(accessed, accessedRegion{region: r, lastAccess: lastAccess})
+	}
+	c.mu.RUnlock()
+
+	sort.Slice(accessed, func(i, j int) bool { return accessed[i].lastAccess > accessed[j].lastAccess })
+	if limit > 0 && len(accessed) > limit {
+		accessed = accessed[:limit]
+	}
+	regions := make([]*Region, len(accessed))
+	for i, a := range accessed {
+		regions[i] = a.region
+	}
+	return regions
+}
+
 // BatchLoadRegionsWithKeyRange loads at most given numbers of regions to the RegionCache,
 // within the given key range from the startKey to endKey. Returns the loaded regions.
 func (c *RegionCache) BatchLoadRegionsWithKeyRange(bo *retry.Backoffer, startKey []byte, endKey []byte, count int) (regions []*Region, err error) {
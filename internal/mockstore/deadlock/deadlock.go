@@ -37,6 +37,9 @@ package deadlock
 import (
 	"fmt"
 	"sync"
+	"time"
+
+	deadlockpb "github.com/pingcap/kvproto/pkg/deadlock"
 )
 
 // Detector detects deadlock.
@@ -50,8 +53,19 @@ type txnList struct {
 }
 
 type txnKeyHashPair struct {
-	txn     uint64
-	keyHash uint64
+	txn       uint64
+	keyHash   uint64
+	diagCtx   DiagnosticContext
+	startTime time.Time
+}
+
+// DiagnosticContext is the information of a wait-for relation which is only used
+// to diagnose deadlocks. It is reported in the wait chain of ErrDeadlock.
+type DiagnosticContext struct {
+	// Key is the key that the transaction is waiting for.
+	Key []byte
+	// ResourceGroupTag is the resource group tag of the lock request.
+	ResourceGroupTag []byte
 }
 
 // NewDetector creates a new Detector.
@@ -64,6 +78,9 @@ func NewDetector() *Detector {
 // ErrDeadlock is returned when deadlock is detected.
 type ErrDeadlock struct {
 	KeyHash uint64
+	// WaitChain is the wait-for relations that form the cycle. It starts from the
+	// transaction being waited for and ends with the relation that causes the deadlock.
+	WaitChain []*deadlockpb.WaitForEntry
 }
 
 func (e *ErrDeadlock) Error() string {
@@ -72,34 +89,58 @@ func (e *ErrDeadlock) Error() string {
 
 // Detect detects deadlock for the sourceTxn on a locked key.
 func (d *Detector) Detect(sourceTxn, waitForTxn, keyHash uint64) *ErrDeadlock {
+	return d.DetectWithDiagnosticContext(sourceTxn, waitForTxn, keyHash, DiagnosticContext{})
+}
+
+// DetectWithDiagnosticContext detects deadlock for the sourceTxn on a locked key,
+// the diagnostic context is recorded and reported in the wait chain of deadlocks.
+func (d *Detector) DetectWithDiagnosticContext(sourceTxn, waitForTxn, keyHash uint64, diagCtx DiagnosticContext) *ErrDeadlock {
 	d.lock.Lock()
-	err := d.doDetect(sourceTxn, waitForTxn)
-	if err == nil {
-		d.register(sourceTxn, waitForTxn, keyHash)
+	defer d.lock.Unlock()
+	now := time.Now()
+	if chain := d.doDetect(sourceTxn, waitForTxn, now); chain != nil {
+		entry := txnKeyHashPair{txn: waitForTxn, keyHash: keyHash, diagCtx: diagCtx, startTime: now}
+		return &ErrDeadlock{
+			KeyHash:   chain[len(chain)-1].KeyHash,
+			WaitChain: append(chain, entry.toWaitForEntry(sourceTxn, now)),
+		}
 	}
-	d.lock.Unlock()
-	return err
+	d.register(sourceTxn, waitForTxn, keyHash, diagCtx, now)
+	return nil
 }
 
-func (d *Detector) doDetect(sourceTxn, waitForTxn uint64) *ErrDeadlock {
+// doDetect returns the wait chain from waitForTxn to sourceTxn if there is one.
+func (d *Detector) doDetect(sourceTxn, waitForTxn uint64, now time.Time) []*deadlockpb.WaitForEntry {
 	list := d.waitForMap[waitForTxn]
 	if list == nil {
 		return nil
 	}
 	for _, nextTarget := range list.txns {
+		entry := nextTarget.toWaitForEntry(waitForTxn, now)
 		if nextTarget.txn == sourceTxn {
-			return &ErrDeadlock{KeyHash: nextTarget.keyHash}
+			return []*deadlockpb.WaitForEntry{entry}
 		}
-		if err := d.doDetect(sourceTxn, nextTarget.txn); err != nil {
-			return err
+		if chain := d.doDetect(sourceTxn, nextTarget.txn, now); chain != nil {
+			return append([]*deadlockpb.WaitForEntry{entry}, chain...)
 		}
 	}
 	return nil
 }
 
-func (d *Detector) register(sourceTxn, waitForTxn, keyHash uint64) {
+func (p *txnKeyHashPair) toWaitForEntry(txn uint64, now time.Time) *deadlockpb.WaitForEntry {
+	return &deadlockpb.WaitForEntry{
+		Txn:              txn,
+		WaitForTxn:       p.txn,
+		KeyHash:          p.keyHash,
+		Key:              p.diagCtx.Key,
+		ResourceGroupTag: p.diagCtx.ResourceGroupTag,
+		WaitTime:         uint64(now.Sub(p.startTime).Milliseconds()),
+	}
+}
+
+func (d *Detector) register(sourceTxn, waitForTxn, keyHash uint64, diagCtx DiagnosticContext, now time.Time) {
 	list := d.waitForMap[sourceTxn]
-	pair := txnKeyHashPair{txn: waitForTxn, keyHash: keyHash}
+	pair := txnKeyHashPair{txn: waitForTxn, keyHash: keyHash, diagCtx: diagCtx, startTime: now}
 	if list == nil {
 		d.waitForMap[sourceTxn] = &txnList{txns: []txnKeyHashPair{pair}}
 		return
@@ -121,12 +162,11 @@ func (d *Detector) CleanUp(txn uint64) {
 
 // CleanUpWaitFor removes a key in the wait for entry for the transaction.
 func (d *Detector) CleanUpWaitFor(txn, waitForTxn, keyHash uint64) {
-	pair := txnKeyHashPair{txn: waitForTxn, keyHash: keyHash}
 	d.lock.Lock()
 	l := d.waitForMap[txn]
 	if l != nil {
 		for i, tar := range l.txns {
-			if tar == pair {
+			if tar.txn == waitForTxn && tar.keyHash == keyHash {
 				l.txns = append(l.txns[:i], l.txns[i+1:]...)
 				break
 			}
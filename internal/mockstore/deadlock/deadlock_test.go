@@ -79,3 +79,35 @@ func TestDeadlock(t *testing.T) {
 	detector.Expire(2)
 	assert.Len(detector.waitForMap, 0)
 }
+
+func TestDeadlockWaitChain(t *testing.T) {
+	assert := assert.New(t)
+	detector := NewDetector()
+	diagCtx := func(key string) DiagnosticContext {
+		return DiagnosticContext{Key: []byte(key), ResourceGroupTag: []byte("tag-" + key)}
+	}
+	assert.Nil(detector.DetectWithDiagnosticContext(1, 2, 100, diagCtx("k2")))
+	assert.Nil(detector.DetectWithDiagnosticContext(2, 3, 200, diagCtx("k3")))
+	err := detector.DetectWithDiagnosticContext(3, 1, 300, diagCtx("k1"))
+	assert.NotNil(err)
+	assert.Equal(uint64(200), err.KeyHash)
+
+	// The chain starts from the transaction being waited for and ends with the new wait-for relation.
+	expected := []struct {
+		txn, waitForTxn, keyHash uint64
+		key                      string
+	}{{1, 2, 100, "k2"}, {2, 3, 200, "k3"}, {3, 1, 300, "k1"}}
+	assert.Len(err.WaitChain, len(expected))
+	for i, e := range expected {
+		entry := err.WaitChain[i]
+		assert.Equal(e.txn, entry.Txn)
+		assert.Equal(e.waitForTxn, entry.WaitForTxn)
+		assert.Equal(e.keyHash, entry.KeyHash)
+		assert.Equal([]byte(e.key), entry.Key)
+		assert.Equal([]byte("tag-"+e.key), entry.ResourceGroupTag)
+	}
+
+	// The relation causing the deadlock is not registered.
+	assert.Nil(detector.waitForMap[3])
+	assert.Nil(detector.Detect(4, 1, 400))
+}
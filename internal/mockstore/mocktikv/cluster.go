@@ -79,6 +79,10 @@ type Cluster struct {
 	// delayEvents is used to control the execution sequence of rpc requests for test.
 	delayEvents map[delayKey]time.Duration
 	delayMu     sync.Mutex
+
+	// lockObservers are the lock observers registered on the stores.
+	lockObservers        map[uint64]*lockObserver
+	lockObserverCapacity int
 }
 
 type delayKey struct {
@@ -94,6 +98,9 @@ func NewCluster(mvccStore MVCCStore) *Cluster {
 		regions:     make(map[uint64]*Region),
 		delayEvents: make(map[delayKey]time.Duration),
 		mvccStore:   mvccStore,
+
+		lockObservers:        make(map[uint64]*lockObserver),
+		lockObserverCapacity: defaultLockObserverCapacity,
 	}
 }
 
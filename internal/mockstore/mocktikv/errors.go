@@ -38,6 +38,7 @@ import (
 	"encoding/hex"
 	"fmt"
 
+	deadlockpb "github.com/pingcap/kvproto/pkg/deadlock"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
 )
 
@@ -118,6 +119,7 @@ type ErrDeadlock struct {
 	LockTS         uint64
 	LockKey        []byte
 	DealockKeyHash uint64
+	WaitChain      []*deadlockpb.WaitForEntry
 }
 
 func (e *ErrDeadlock) Error() string {
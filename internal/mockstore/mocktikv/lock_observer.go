@@ -0,0 +1,152 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mocktikv
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+)
+
+const defaultLockObserverCapacity = 1024
+
+// lockObserver collects the locks written to a store after it's registered,
+// whose start ts is not greater than maxTS. It becomes dirty when it can't hold
+// more locks, then the locks it collected are not complete.
+type lockObserver struct {
+	maxTS uint64
+	locks []*kvrpcpb.LockInfo
+	dirty bool
+}
+
+// SetLockObserverCapacity sets the number of the locks a lock observer can hold
+// before it becomes dirty.
+func (c *Cluster) SetLockObserverCapacity(capacity int) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.lockObserverCapacity = capacity
+}
+
+// registerLockObserver registers a lock observer on the store. Registering again
+// with the same maxTS keeps the collected locks, otherwise the observer is reset.
+func (c *Cluster) registerLockObserver(storeID, maxTS uint64) {
+	c.Lock()
+	defer c.Unlock()
+
+	if o := c.lockObservers[storeID]; o != nil && o.maxTS == maxTS {
+		return
+	}
+	c.lockObservers[storeID] = &lockObserver{maxTS: maxTS}
+}
+
+// checkLockObserver returns the locks collected by the lock observer on the
+// store and whether it's clean. An observer registered with another maxTS is
+// never clean.
+func (c *Cluster) checkLockObserver(storeID, maxTS uint64) ([]*kvrpcpb.LockInfo, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	o := c.lockObservers[storeID]
+	if o == nil || o.maxTS != maxTS || o.dirty {
+		return nil, false
+	}
+	locks := make([]*kvrpcpb.LockInfo, len(o.locks))
+	copy(locks, o.locks)
+	return locks, true
+}
+
+// removeLockObserver removes the lock observer registered with maxTS on the store.
+func (c *Cluster) removeLockObserver(storeID, maxTS uint64) {
+	c.Lock()
+	defer c.Unlock()
+
+	if o := c.lockObservers[storeID]; o != nil && o.maxTS == maxTS {
+		delete(c.lockObservers, storeID)
+	}
+}
+
+// observeLocks passes the locks written to the region to the lock observers on
+// the stores of its peers.
+func (c *Cluster) observeLocks(regionID uint64, locks []*kvrpcpb.LockInfo) {
+	c.Lock()
+	defer c.Unlock()
+
+	r := c.regions[regionID]
+	if r == nil {
+		return
+	}
+	for _, peer := range r.Meta.Peers {
+		o := c.lockObservers[peer.GetStoreId()]
+		if o == nil || o.dirty {
+			continue
+		}
+		for _, l := range locks {
+			if l.LockVersion > o.maxTS {
+				continue
+			}
+			if len(o.locks) >= c.lockObserverCapacity {
+				o.dirty = true
+				o.locks = nil
+				break
+			}
+			o.locks = append(o.locks, l)
+		}
+	}
+}
+
+// physicalScanLock scans the locks on the store from startKey, whose start ts is
+// not greater than maxTS, no matter which regions they belong to.
+func (c *Cluster) physicalScanLock(storeID, maxTS uint64, startKey []byte, limit int) ([]*kvrpcpb.LockInfo, error) {
+	locks, err := c.mvccStore.ScanLock(startKey, nil, maxTS)
+	if err != nil {
+		return nil, err
+	}
+
+	c.RLock()
+	defer c.RUnlock()
+	res := make([]*kvrpcpb.LockInfo, 0, len(locks))
+	for _, l := range locks {
+		if !c.hasPeerOnStoreNoLock(NewMvccKey(l.Key), storeID) {
+			continue
+		}
+		res = append(res, l)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return bytes.Compare(res[i].Key, res[j].Key) < 0
+	})
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+// hasPeerOnStoreNoLock returns whether the region containing the key has a peer
+// on the store.
+func (c *Cluster) hasPeerOnStoreNoLock(key MvccKey, storeID uint64) bool {
+	for _, r := range c.regions {
+		if !regionContains(r.Meta.StartKey, r.Meta.EndKey, key) {
+			continue
+		}
+		for _, peer := range r.Meta.Peers {
+			if peer.GetStoreId() == storeID {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
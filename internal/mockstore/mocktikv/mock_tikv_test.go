@@ -767,6 +767,30 @@ func TestMvccGetByKey(t *testing.T) {
 	assert.Equal(t, mvccInfo, except)
 }
 
+func TestMvccGetByStartTS(t *testing.T) {
+	store, err := NewMVCCLevelDB("")
+	require.Nil(t, err)
+	defer store.Close()
+
+	mustPutOK(t, store, "a", "va", 5, 10)
+	mustPrewriteOK(t, store, putMutations("b", "vb", "c", "vc"), "b", 15)
+	var istore interface{} = store
+	debugger, ok := istore.(MVCCDebugger)
+	assert.True(t, ok)
+
+	mvccInfo, key := debugger.MvccGetByStartTS(nil, nil, 5)
+	assert.Equal(t, []byte("a"), key)
+	assert.Len(t, mvccInfo.Writes, 1)
+	assert.Equal(t, uint64(10), mvccInfo.Writes[0].CommitTs)
+
+	mvccInfo, key = debugger.MvccGetByStartTS([]byte("c"), nil, 15)
+	assert.Equal(t, []byte("c"), key)
+	assert.Equal(t, uint64(15), mvccInfo.Lock.StartTs)
+
+	_, key = debugger.MvccGetByStartTS(nil, []byte("b"), 15)
+	assert.Nil(t, key)
+}
+
 func TestTxnHeartBeat(t *testing.T) {
 	store, err := NewMVCCLevelDB("")
 	require.Nil(t, err)
@@ -790,3 +814,45 @@ func TestTxnHeartBeat(t *testing.T) {
 	_, err = store.TxnHeartBeat([]byte("pk"), 5, 1000)
 	assert.NotNil(err)
 }
+
+func TestPessimisticLockDeadlockWaitChain(t *testing.T) {
+	store, err := NewMVCCLevelDB("")
+	require.Nil(t, err)
+	defer store.Close()
+
+	pessimisticLock := func(key string, startTS uint64, tag string) *kvrpcpb.PessimisticLockResponse {
+		return store.PessimisticLock(&kvrpcpb.PessimisticLockRequest{
+			Context:      &kvrpcpb.Context{ResourceGroupTag: []byte(tag)},
+			Mutations:    []*kvrpcpb.Mutation{{Op: kvrpcpb.Op_PessimisticLock, Key: []byte(key)}},
+			PrimaryLock:  []byte(key),
+			StartVersion: startTS,
+			ForUpdateTs:  startTS,
+			LockTtl:      3000,
+			WaitTimeout:  LockNoWait,
+		})
+	}
+	require.Empty(t, pessimisticLock("k1", 10, "init-1").Errors)
+	require.Empty(t, pessimisticLock("k2", 20, "init-2").Errors)
+
+	// txn 10 waits for txn 20 on k2.
+	resp := pessimisticLock("k2", 10, "tag-1")
+	require.Len(t, resp.Errors, 1)
+	require.NotNil(t, resp.Errors[0].Locked)
+
+	// txn 20 waits for txn 10 on k1, which forms a deadlock.
+	resp = pessimisticLock("k1", 20, "tag-2")
+	require.Len(t, resp.Errors, 1)
+	deadlock := resp.Errors[0].Deadlock
+	require.NotNil(t, deadlock)
+	assert.Equal(t, uint64(10), deadlock.LockTs)
+	assert.Equal(t, []byte("k1"), deadlock.LockKey)
+	require.Len(t, deadlock.WaitChain, 2)
+	assert.Equal(t, uint64(10), deadlock.WaitChain[0].Txn)
+	assert.Equal(t, uint64(20), deadlock.WaitChain[0].WaitForTxn)
+	assert.Equal(t, []byte("k2"), deadlock.WaitChain[0].Key)
+	assert.Equal(t, []byte("tag-1"), deadlock.WaitChain[0].ResourceGroupTag)
+	assert.Equal(t, uint64(20), deadlock.WaitChain[1].Txn)
+	assert.Equal(t, uint64(10), deadlock.WaitChain[1].WaitForTxn)
+	assert.Equal(t, []byte("k1"), deadlock.WaitChain[1].Key)
+	assert.Equal(t, []byte("tag-2"), deadlock.WaitChain[1].ResourceGroupTag)
+}
@@ -541,6 +541,8 @@ type lockCtx struct {
 	primary     []byte
 	ttl         uint64
 	minCommitTs uint64
+	// resourceGroupTag is reported in the wait chain of deadlocks.
+	resourceGroupTag []byte
 
 	returnValues   bool
 	checkExistence bool
@@ -563,6 +565,9 @@ func (mvcc *MVCCLevelDB) PessimisticLock(req *kvrpcpb.PessimisticLockRequest) *k
 		returnValues:   req.ReturnValues,
 		checkExistence: req.CheckExistence,
 	}
+	if req.Context != nil {
+		lCtx.resourceGroupTag = req.Context.ResourceGroupTag
+	}
 	lockWaitTime := req.WaitTimeout
 
 	anyError := false
@@ -619,12 +624,14 @@ func (mvcc *MVCCLevelDB) pessimisticLockMutation(batch *leveldb.Batch, mutation
 	}
 	if ok {
 		if dec.lock.startTS != startTS {
-			errDeadlock := mvcc.deadlockDetector.Detect(startTS, dec.lock.startTS, farm.Fingerprint64(mutation.Key))
+			diagCtx := deadlock.DiagnosticContext{Key: mutation.Key, ResourceGroupTag: lctx.resourceGroupTag}
+			errDeadlock := mvcc.deadlockDetector.DetectWithDiagnosticContext(startTS, dec.lock.startTS, farm.Fingerprint64(mutation.Key), diagCtx)
 			if errDeadlock != nil {
 				return &ErrDeadlock{
 					LockKey:        mutation.Key,
 					LockTS:         dec.lock.startTS,
 					DealockKeyHash: errDeadlock.KeyHash,
+					WaitChain:      errDeadlock.WaitChain,
 				}
 			}
 			return dec.lock.lockErr(mutation.Key)
@@ -918,7 +925,10 @@ func prewriteMutation(db *leveldb.DB, batch *leveldb.Batch,
 			// The minCommitTS has been pushed forward.
 			minCommitTS = dec.lock.minCommitTS
 		}
-		_, err = checkConflictValue(iter, mutation, startTS, startTS, false, assertionLevel)
+		// The key is protected by the pessimistic lock, the writes committed after
+		// startTS are already checked when the lock is acquired, so only the assertion
+		// is checked here.
+		_, err = checkConflictValue(iter, mutation, math.MaxUint64, startTS, false, assertionLevel)
 		if err != nil {
 			return err
 		}
@@ -1439,9 +1449,13 @@ func (mvcc *MVCCLevelDB) ScanLock(startKey, endKey []byte, maxTS uint64) ([]*kvr
 		}
 		if ok && dec.lock.startTS <= maxTS {
 			locks = append(locks, &kvrpcpb.LockInfo{
-				PrimaryLock: dec.lock.primary,
-				LockVersion: dec.lock.startTS,
-				Key:         currKey,
+				PrimaryLock:     dec.lock.primary,
+				LockVersion:     dec.lock.startTS,
+				Key:             currKey,
+				LockTtl:         dec.lock.ttl,
+				TxnSize:         dec.lock.txnSize,
+				LockType:        dec.lock.op,
+				LockForUpdateTs: dec.lock.forUpdateTS,
 			})
 		}
 
@@ -1795,6 +1809,7 @@ func (mvcc *MVCCLevelDB) RawDeleteRange(cf string, startKey, endKey []byte) {
 }
 
 // RawCompareAndSwap supports CAS function(write newValue if expectedValue equals value stored in db).
+// A nil expectedValue means the key doesn't exist.
 // `oldValue` and `swapped` returned specify the old value stored in db and whether CAS has happened.
 func (mvcc *MVCCLevelDB) RawCompareAndSwap(cf string, key, expectedValue, newValue []byte,
 ) (oldValue []byte, swapped bool, err error) {
@@ -1812,12 +1827,16 @@ func (mvcc *MVCCLevelDB) RawCompareAndSwap(cf string, key, expectedValue, newVal
 	}
 
 	oldValue, err = db.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		oldValue, err = nil, nil
+	}
 	if err != nil {
 		tikverr.Log(err)
 		return nil, false, errors.WithStack(err)
 	}
 
-	if !bytes.Equal(oldValue, expectedValue) {
+	// A nil expectedValue means the key must not exist.
+	if (expectedValue == nil) != (oldValue == nil) || !bytes.Equal(oldValue, expectedValue) {
 		return oldValue, false, nil
 	}
 
@@ -1853,26 +1872,43 @@ func (mvcc *MVCCLevelDB) doRawDeleteRange(cf string, startKey, endKey []byte) er
 }
 
 // MvccGetByStartTS implements the MVCCDebugger interface.
-func (mvcc *MVCCLevelDB) MvccGetByStartTS(starTS uint64) (*kvrpcpb.MvccInfo, []byte) {
+func (mvcc *MVCCLevelDB) MvccGetByStartTS(startKey, endKey []byte, starTS uint64) (*kvrpcpb.MvccInfo, []byte) {
 	mvcc.mu.RLock()
 	defer mvcc.mu.RUnlock()
 
 	var key []byte
-	iter := newIterator(mvcc.getDB(""), nil)
+	iter := newIterator(mvcc.getDB(""), &util.Range{
+		Start: mvccEncode(startKey, lockVer),
+	})
 	defer iter.Release()
 
-	// find the first committed key for which `start_ts` equals to `ts`
+	// find the first key in the range which is locked or committed by the transaction
 	for iter.Valid() {
-		var value mvccValue
-		err := value.UnmarshalBinary(iter.Value())
-		if err == nil && value.startTS == starTS {
-			if _, key, err = codec.DecodeBytes(iter.Key(), nil); err != nil {
-				return nil, nil
-			}
+		k, ver, err := mvccDecode(iter.Key())
+		if err != nil {
+			return nil, nil
+		}
+		if len(endKey) > 0 && bytes.Compare(k, endKey) >= 0 {
 			break
 		}
+		if ver == lockVer {
+			var lock mvccLock
+			if err = lock.UnmarshalBinary(iter.Value()); err == nil && lock.startTS == starTS {
+				key = k
+				break
+			}
+		} else {
+			var value mvccValue
+			if err = value.UnmarshalBinary(iter.Value()); err == nil && value.startTS == starTS {
+				key = k
+				break
+			}
+		}
 		iter.Next()
 	}
+	if key == nil {
+		return nil, nil
+	}
 
 	return mvcc.mvccGetByKeyNoLock(key), key
 }
@@ -110,6 +110,7 @@ func convertToKeyError(err error) *kvrpcpb.KeyError {
 				LockTs:          dead.LockTS,
 				LockKey:         dead.LockKey,
 				DeadlockKeyHash: dead.DealockKeyHash,
+				WaitChain:       dead.WaitChain,
 			},
 		}
 	}
@@ -237,6 +238,7 @@ func (h kvHandler) handleKvPrewrite(req *kvrpcpb.PrewriteRequest) *kvrpcpb.Prewr
 		}
 	}
 	errs := h.mvccStore.Prewrite(req)
+	h.observePrewrite(req, errs)
 	for i, e := range errs {
 		if e != nil {
 			if _, isLocked := errors.Cause(e).(*ErrLocked); !isLocked {
@@ -251,6 +253,33 @@ func (h kvHandler) handleKvPrewrite(req *kvrpcpb.PrewriteRequest) *kvrpcpb.Prewr
 	}
 }
 
+// observePrewrite passes the locks written by the prewrite to the lock observers.
+// No lock is written if the prewrite fails on any key.
+func (h kvHandler) observePrewrite(req *kvrpcpb.PrewriteRequest, errs []error) {
+	for _, e := range errs {
+		if e != nil {
+			return
+		}
+	}
+	locks := make([]*kvrpcpb.LockInfo, 0, len(req.Mutations))
+	for _, m := range req.Mutations {
+		if m.Op == kvrpcpb.Op_CheckNotExists {
+			continue
+		}
+		locks = append(locks, &kvrpcpb.LockInfo{
+			PrimaryLock: req.PrimaryLock,
+			LockVersion: req.StartVersion,
+			Key:         m.Key,
+			LockTtl:     req.LockTtl,
+			TxnSize:     req.TxnSize,
+			LockType:    m.Op,
+		})
+	}
+	if len(locks) > 0 {
+		h.cluster.observeLocks(req.Context.GetRegionId(), locks)
+	}
+}
+
 func (h kvHandler) handleKvPessimisticLock(req *kvrpcpb.PessimisticLockRequest) *kvrpcpb.PessimisticLockResponse {
 	for _, m := range req.Mutations {
 		if !h.checkKeyInRegion(m.Key) {
@@ -377,7 +406,9 @@ func (h kvHandler) handleMvccGetByStartTS(req *kvrpcpb.MvccGetByStartTsRequest)
 		}
 	}
 	var resp kvrpcpb.MvccGetByStartTsResponse
-	resp.Info, resp.Key = debugger.MvccGetByStartTS(req.StartTs)
+	startKey := MvccKey(h.startKey).Raw()
+	endKey := MvccKey(h.endKey).Raw()
+	resp.Info, resp.Key = debugger.MvccGetByStartTS(startKey, endKey, req.StartTs)
 	return &resp
 }
 
@@ -394,12 +425,21 @@ func (h kvHandler) handleKvBatchRollback(req *kvrpcpb.BatchRollbackRequest) *kvr
 func (h kvHandler) handleKvScanLock(req *kvrpcpb.ScanLockRequest) *kvrpcpb.ScanLockResponse {
 	startKey := MvccKey(h.startKey).Raw()
 	endKey := MvccKey(h.endKey).Raw()
+	if bytes.Compare(req.GetStartKey(), startKey) > 0 {
+		startKey = req.GetStartKey()
+	}
+	if len(req.GetEndKey()) > 0 && (len(endKey) == 0 || bytes.Compare(req.GetEndKey(), endKey) < 0) {
+		endKey = req.GetEndKey()
+	}
 	locks, err := h.mvccStore.ScanLock(startKey, endKey, req.GetMaxVersion())
 	if err != nil {
 		return &kvrpcpb.ScanLockResponse{
 			Error: convertToKeyError(err),
 		}
 	}
+	if req.GetLimit() > 0 && len(locks) > int(req.GetLimit()) {
+		locks = locks[:req.GetLimit()]
+	}
 	return &kvrpcpb.ScanLockResponse{
 		Locks: locks,
 	}
@@ -408,7 +448,16 @@ func (h kvHandler) handleKvScanLock(req *kvrpcpb.ScanLockRequest) *kvrpcpb.ScanL
 func (h kvHandler) handleKvResolveLock(req *kvrpcpb.ResolveLockRequest) *kvrpcpb.ResolveLockResponse {
 	startKey := MvccKey(h.startKey).Raw()
 	endKey := MvccKey(h.endKey).Raw()
-	err := h.mvccStore.ResolveLock(startKey, endKey, req.GetStartVersion(), req.GetCommitVersion())
+	var err error
+	if len(req.TxnInfos) > 0 {
+		txnInfos := make(map[uint64]uint64, len(req.TxnInfos))
+		for _, info := range req.TxnInfos {
+			txnInfos[info.GetTxn()] = info.GetStatus()
+		}
+		err = h.mvccStore.BatchResolveLock(startKey, endKey, txnInfos)
+	} else {
+		err = h.mvccStore.ResolveLock(startKey, endKey, req.GetStartVersion(), req.GetCommitVersion())
+	}
 	if err != nil {
 		return &kvrpcpb.ResolveLockResponse{
 			Error: convertToKeyError(err),
@@ -441,6 +490,46 @@ func (h kvHandler) handleKvDeleteRange(req *kvrpcpb.DeleteRangeRequest) *kvrpcpb
 	return &resp
 }
 
+// handleKvUnsafeDestroyRange destroys the range of the store without checking the regions.
+func (h kvHandler) handleKvUnsafeDestroyRange(req *kvrpcpb.UnsafeDestroyRangeRequest) *kvrpcpb.UnsafeDestroyRangeResponse {
+	var resp kvrpcpb.UnsafeDestroyRangeResponse
+	err := h.mvccStore.DeleteRange(req.StartKey, req.EndKey)
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	return &resp
+}
+
+func (h kvHandler) handleKvRegisterLockObserver(req *kvrpcpb.RegisterLockObserverRequest) *kvrpcpb.RegisterLockObserverResponse {
+	h.cluster.registerLockObserver(h.storeID, req.GetMaxTs())
+	return &kvrpcpb.RegisterLockObserverResponse{}
+}
+
+func (h kvHandler) handleKvCheckLockObserver(req *kvrpcpb.CheckLockObserverRequest) *kvrpcpb.CheckLockObserverResponse {
+	locks, clean := h.cluster.checkLockObserver(h.storeID, req.GetMaxTs())
+	return &kvrpcpb.CheckLockObserverResponse{
+		IsClean: clean,
+		Locks:   locks,
+	}
+}
+
+func (h kvHandler) handleKvRemoveLockObserver(req *kvrpcpb.RemoveLockObserverRequest) *kvrpcpb.RemoveLockObserverResponse {
+	h.cluster.removeLockObserver(h.storeID, req.GetMaxTs())
+	return &kvrpcpb.RemoveLockObserverResponse{}
+}
+
+func (h kvHandler) handleKvPhysicalScanLock(req *kvrpcpb.PhysicalScanLockRequest) *kvrpcpb.PhysicalScanLockResponse {
+	locks, err := h.cluster.physicalScanLock(h.storeID, req.GetMaxTs(), req.GetStartKey(), int(req.GetLimit()))
+	if err != nil {
+		return &kvrpcpb.PhysicalScanLockResponse{
+			Error: err.Error(),
+		}
+	}
+	return &kvrpcpb.PhysicalScanLockResponse{
+		Locks: locks,
+	}
+}
+
 func (h kvHandler) handleKvRawGet(req *kvrpcpb.RawGetRequest) *kvrpcpb.RawGetResponse {
 	rawKV, ok := h.mvccStore.(RawKV)
 	if !ok {
@@ -523,10 +612,16 @@ func (h kvHandler) HandleKvRawCompareAndSwap(req *kvrpcpb.RawCASRequest) *kvrpcp
 		}
 	}
 
+	expectedValue := req.GetPreviousValue()
+	if req.GetPreviousNotExist() {
+		expectedValue = nil
+	} else if expectedValue == nil {
+		expectedValue = []byte{}
+	}
 	oldValue, success, err := rawKV.RawCompareAndSwap(
 		req.Cf,
 		req.GetKey(),
-		req.GetPreviousValue(),
+		expectedValue,
 		req.GetValue(),
 	)
 	if err != nil {
@@ -943,15 +1038,15 @@ func (c *RPCClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.R
 		}
 		resp.Resp = kvHandler{session}.HandleKvRawCompareAndSwap(r)
 	case tikvrpc.CmdUnsafeDestroyRange:
-		panic("unimplemented")
+		resp.Resp = kvHandler{session}.handleKvUnsafeDestroyRange(req.UnsafeDestroyRange())
 	case tikvrpc.CmdRegisterLockObserver:
-		return nil, errors.New("unimplemented")
+		resp.Resp = kvHandler{session}.handleKvRegisterLockObserver(req.RegisterLockObserver())
 	case tikvrpc.CmdCheckLockObserver:
-		return nil, errors.New("unimplemented")
+		resp.Resp = kvHandler{session}.handleKvCheckLockObserver(req.CheckLockObserver())
 	case tikvrpc.CmdRemoveLockObserver:
-		return nil, errors.New("unimplemented")
+		resp.Resp = kvHandler{session}.handleKvRemoveLockObserver(req.RemoveLockObserver())
 	case tikvrpc.CmdPhysicalScanLock:
-		return nil, errors.New("unimplemented")
+		resp.Resp = kvHandler{session}.handleKvPhysicalScanLock(req.PhysicalScanLock())
 	case tikvrpc.CmdCop:
 		if c.coprHandler == nil {
 			return nil, errors.New("unimplemented")
@@ -46,6 +46,7 @@ var (
 	TiKVSendReqHistogram                     *prometheus.HistogramVec
 	TiKVCoprocessorHistogram                 *prometheus.HistogramVec
 	TiKVLockResolverCounter                  *prometheus.CounterVec
+	TiKVResolvedCacheCounter                 *prometheus.CounterVec
 	TiKVRegionErrorCounter                   *prometheus.CounterVec
 	TiKVTxnWriteKVCountHistogram             prometheus.Histogram
 	TiKVTxnWriteSizeHistogram                prometheus.Histogram
@@ -92,6 +93,12 @@ var (
 	TiKVReadThroughput                       prometheus.Histogram
 	TiKVUnsafeDestroyRangeFailuresCounterVec *prometheus.CounterVec
 	TiKVPrewriteAssertionUsageCounter        *prometheus.CounterVec
+	TiKVGCWorkerActionsCounter               *prometheus.CounterVec
+	TiKVGCWorkerIsLeader                     prometheus.Gauge
+	TiKVGCDurationHistogram                  prometheus.Histogram
+	TiKVGCSafePointGauge                     prometheus.Gauge
+	TiKVLockCleanerLocksCounter              *prometheus.CounterVec
+	TiKVLockCleanerRunDuration               *prometheus.HistogramVec
 )
 
 // Label constants.
@@ -158,6 +165,14 @@ func initMetrics(namespace, subsystem string) {
 			Help:      "Counter of lock resolver actions.",
 		}, []string{LblType})
 
+	TiKVResolvedCacheCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "resolved_cache_total",
+			Help:      "Counter of the hits, misses and evictions of the resolved transaction cache.",
+		}, []string{LblType})
+
 	TiKVRegionErrorCounter = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: namespace,
@@ -550,6 +565,56 @@ func initMetrics(namespace, subsystem string) {
 			Help:      "Counter of assertions used in prewrite requests",
 		}, []string{LblType})
 
+	TiKVGCWorkerActionsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "gc_worker_actions_total",
+			Help:      "Counter of the actions of the GC worker.",
+		}, []string{LblType, LblResult})
+
+	TiKVGCWorkerIsLeader = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "gc_worker_is_leader",
+			Help:      "Whether the GC worker is the leader, 1 if it is.",
+		})
+
+	TiKVGCDurationHistogram = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "gc_duration_seconds",
+			Help:      "Bucketed histogram of the duration of the GC jobs run by the GC worker.",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 2, 20), // 100ms ~ 14.5h
+		})
+
+	TiKVGCSafePointGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "gc_safe_point_seconds",
+			Help:      "Physical time of the GC safepoint updated by the GC worker, in unix seconds.",
+		})
+
+	TiKVLockCleanerLocksCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "lock_cleaner_locks_total",
+			Help:      "Counter of the locks scanned by the lock cleaner, by what is done to them.",
+		}, []string{LblType})
+
+	TiKVLockCleanerRunDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "lock_cleaner_run_duration_seconds",
+			Help:      "Bucketed histogram of the duration of the rounds run by the lock cleaner.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 20), // 1ms ~ 524s
+		}, []string{LblResult})
+
 	initShortcuts()
 }
 
@@ -570,6 +635,7 @@ func RegisterMetrics() {
 	prometheus.MustRegister(TiKVSendReqHistogram)
 	prometheus.MustRegister(TiKVCoprocessorHistogram)
 	prometheus.MustRegister(TiKVLockResolverCounter)
+	prometheus.MustRegister(TiKVResolvedCacheCounter)
 	prometheus.MustRegister(TiKVRegionErrorCounter)
 	prometheus.MustRegister(TiKVTxnWriteKVCountHistogram)
 	prometheus.MustRegister(TiKVTxnWriteSizeHistogram)
@@ -616,6 +682,12 @@ func RegisterMetrics() {
 	prometheus.MustRegister(TiKVReadThroughput)
 	prometheus.MustRegister(TiKVUnsafeDestroyRangeFailuresCounterVec)
 	prometheus.MustRegister(TiKVPrewriteAssertionUsageCounter)
+	prometheus.MustRegister(TiKVGCWorkerActionsCounter)
+	prometheus.MustRegister(TiKVGCWorkerIsLeader)
+	prometheus.MustRegister(TiKVGCDurationHistogram)
+	prometheus.MustRegister(TiKVGCSafePointGauge)
+	prometheus.MustRegister(TiKVLockCleanerLocksCounter)
+	prometheus.MustRegister(TiKVLockCleanerRunDuration)
 }
 
 // readCounter reads the value of a prometheus.Counter.
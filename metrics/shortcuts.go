@@ -90,6 +90,11 @@ var (
 	LockResolverCountWithResolveLocks             prometheus.Counter
 	LockResolverCountWithResolveLockLite          prometheus.Counter
 
+	ResolvedCacheCountWithHit    prometheus.Counter
+	ResolvedCacheCountWithMiss   prometheus.Counter
+	ResolvedCacheCountWithEvict  prometheus.Counter
+	ResolvedCacheCountWithExpire prometheus.Counter
+
 	RegionCacheCounterWithInvalidateRegionFromCacheOK prometheus.Counter
 	RegionCacheCounterWithSendFail                    prometheus.Counter
 	RegionCacheCounterWithGetRegionByIDOK             prometheus.Counter
@@ -183,6 +188,11 @@ func initShortcuts() {
 	LockResolverCountWithResolveLocks = TiKVLockResolverCounter.WithLabelValues("query_resolve_locks")
 	LockResolverCountWithResolveLockLite = TiKVLockResolverCounter.WithLabelValues("query_resolve_lock_lite")
 
+	ResolvedCacheCountWithHit = TiKVResolvedCacheCounter.WithLabelValues("hit")
+	ResolvedCacheCountWithMiss = TiKVResolvedCacheCounter.WithLabelValues("miss")
+	ResolvedCacheCountWithEvict = TiKVResolvedCacheCounter.WithLabelValues("evict")
+	ResolvedCacheCountWithExpire = TiKVResolvedCacheCounter.WithLabelValues("expire")
+
 	RegionCacheCounterWithInvalidateRegionFromCacheOK = TiKVRegionCacheCounter.WithLabelValues("invalidate_region_from_cache", "ok")
 	RegionCacheCounterWithSendFail = TiKVRegionCacheCounter.WithLabelValues("send_fail", "ok")
 	RegionCacheCounterWithGetRegionByIDOK = TiKVRegionCacheCounter.WithLabelValues("get_region_by_id", "ok")
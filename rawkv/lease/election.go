@@ -0,0 +1,106 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lease
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/JK1Zhang/client-go/v3/rawkv"
+)
+
+// LeaderInfo describes the leader of an election.
+type LeaderInfo struct {
+	// Owner is the owner token of the leader.
+	Owner string
+	// Value is the value proclaimed by the leader in Campaign.
+	Value []byte
+	// Token is the fencing token of the leadership.
+	Token uint64
+}
+
+// Election is a leader election on a RawKV key. The leader holds the mutex of the key.
+type Election struct {
+	mutex *Mutex
+}
+
+// NewElection creates an Election on the key. The client must be in atomic mode.
+func NewElection(client *rawkv.Client, key []byte, opts ...Option) *Election {
+	return &Election{mutex: NewMutex(client, key, opts...)}
+}
+
+// Campaign waits until it's elected as the leader, then proclaims the value. The
+// leadership is kept until the returned lease is released or lost.
+func (e *Election) Campaign(ctx context.Context, value []byte) (*Lease, error) {
+	return e.mutex.lock(ctx, value)
+}
+
+// Leader returns the current leader. It returns ErrNoLeader if there is no leader.
+func (e *Election) Leader(ctx context.Context) (*LeaderInfo, error) {
+	value, err := e.mutex.client.Get(ctx, e.mutex.key)
+	if err != nil {
+		return nil, err
+	}
+	r, err := decodeRecord(value)
+	if err != nil {
+		return nil, err
+	}
+	if r == nil || !r.held(e.mutex.opts.clock.Now()) {
+		return nil, ErrNoLeader
+	}
+	return &LeaderInfo{Owner: r.Owner, Value: r.Value, Token: r.Token}, nil
+}
+
+// Observe polls the leader and sends it to the returned channel whenever it
+// changes. A nil LeaderInfo is sent when the leader is gone. The channel is closed
+// when ctx is done. Errors of the polls are ignored, the leader is polled again in
+// the next round.
+func (e *Election) Observe(ctx context.Context) <-chan *LeaderInfo {
+	ch := make(chan *LeaderInfo)
+	go func() {
+		defer close(ch)
+		var (
+			last  *LeaderInfo
+			first = true
+		)
+		for {
+			leader, err := e.Leader(ctx)
+			if err == nil || err == ErrNoLeader {
+				if first || !sameLeader(last, leader) {
+					select {
+					case ch <- leader:
+					case <-ctx.Done():
+						return
+					}
+					last, first = leader, false
+				}
+			}
+			select {
+			case <-time.After(e.mutex.opts.retryInterval):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+func sameLeader(a, b *LeaderInfo) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Owner == b.Owner && a.Token == b.Token && bytes.Equal(a.Value, b.Value)
+}
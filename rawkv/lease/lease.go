@@ -0,0 +1,425 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lease implements distributed mutexes and leader election on RawKV.
+//
+// A mutex is a single key updated by CompareAndSwap, so the rawkv.Client must be
+// in atomic mode (SetAtomicForCAS(true)). The value of the key records the owner,
+// the fencing token and the expiration time of the lease. The expiration time is
+// judged by the clocks of the clients, so the clocks are assumed to be roughly
+// synchronized. A lease is taken over once it expires, and the fencing token is
+// increased every time the mutex is acquired, so the storage protected by the
+// mutex can reject the writes of a stale owner by comparing the tokens.
+//
+// The keys are not written with a RawKV TTL (PutWithTTL): CompareAndSwap can't set
+// a TTL, and an expired key would drop the fencing token with it, so the tokens
+// would start over. The expiration time is kept in the value instead.
+package lease
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/JK1Zhang/client-go/v3/rawkv"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultTTL           = 10 * time.Second
+	defaultRetryInterval = 500 * time.Millisecond
+)
+
+var (
+	// ErrLocked is returned by TryLock if the mutex is held by another owner.
+	ErrLocked = errors.New("lease: mutex is held by another owner")
+	// ErrLeaseLost means the lease expired or was taken over by another owner.
+	ErrLeaseLost = errors.New("lease: lease is lost")
+	// ErrNoLeader is returned by Leader if there is no leader.
+	ErrNoLeader = errors.New("lease: no leader")
+)
+
+// Clock provides the current time to decide the expiration of leases.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SystemClock is the Clock of the local system time.
+var SystemClock Clock = systemClock{}
+
+type options struct {
+	ttl           time.Duration
+	renewInterval time.Duration
+	retryInterval time.Duration
+	clock         Clock
+	owner         string
+}
+
+// Option configures a Mutex or an Election.
+type Option func(*options)
+
+// WithTTL sets how long a lease lasts after it's acquired or renewed.
+func WithTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.ttl = ttl
+	}
+}
+
+// WithRenewInterval sets the interval of the background renewal of the leases.
+// The default is a third of the TTL. A negative interval disables the background
+// renewal, then the leases must be renewed by Lease.Renew.
+func WithRenewInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.renewInterval = interval
+	}
+}
+
+// WithRetryInterval sets how often Lock and Campaign retry, and how often Observe
+// polls the leader.
+func WithRetryInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.retryInterval = interval
+	}
+}
+
+// WithClock sets the clock deciding the expiration of leases.
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// WithOwner sets the owner token written to the leases. The default is a random UUID.
+func WithOwner(owner string) Option {
+	return func(o *options) {
+		o.owner = owner
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		ttl:           defaultTTL,
+		retryInterval: defaultRetryInterval,
+		clock:         SystemClock,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.ttl <= 0 {
+		o.ttl = defaultTTL
+	}
+	if o.renewInterval == 0 {
+		o.renewInterval = o.ttl / 3
+	}
+	if o.retryInterval <= 0 {
+		o.retryInterval = defaultRetryInterval
+	}
+	if o.owner == "" {
+		o.owner = uuid.New().String()
+	}
+	return o
+}
+
+// record is the value of a mutex key. A released mutex keeps its token with a
+// zero expiration time, so the token keeps increasing.
+type record struct {
+	Owner  string `json:"owner,omitempty"`
+	Token  uint64 `json:"token"`
+	Expire int64  `json:"expire"`
+	Value  []byte `json:"value,omitempty"`
+}
+
+func (r *record) held(now time.Time) bool {
+	return r.Expire > now.UnixNano()
+}
+
+func decodeRecord(value []byte) (*record, error) {
+	if value == nil {
+		return nil, nil
+	}
+	var r record
+	if err := json.Unmarshal(value, &r); err != nil {
+		return nil, errors.Wrapf(err, "lease: invalid value %q", value)
+	}
+	return &r, nil
+}
+
+func (r *record) encode() []byte {
+	value, _ := json.Marshal(r)
+	return value
+}
+
+// Mutex is a distributed mutex stored in a RawKV key.
+type Mutex struct {
+	client *rawkv.Client
+	key    []byte
+	opts   options
+}
+
+// NewMutex creates a Mutex stored in the key. The client must be in atomic mode.
+func NewMutex(client *rawkv.Client, key []byte, opts ...Option) *Mutex {
+	return &Mutex{
+		client: client,
+		key:    append([]byte{}, key...),
+		opts:   newOptions(opts),
+	}
+}
+
+// Owner returns the owner token of the mutex.
+func (m *Mutex) Owner() string {
+	return m.opts.owner
+}
+
+// TryLock acquires the mutex. It returns ErrLocked if the mutex is held by
+// another owner.
+func (m *Mutex) TryLock(ctx context.Context) (*Lease, error) {
+	return m.tryLock(ctx, nil)
+}
+
+// Lock acquires the mutex. It waits until the mutex is released or expires.
+func (m *Mutex) Lock(ctx context.Context) (*Lease, error) {
+	return m.lock(ctx, nil)
+}
+
+func (m *Mutex) lock(ctx context.Context, value []byte) (*Lease, error) {
+	for {
+		l, err := m.tryLock(ctx, value)
+		if err != ErrLocked {
+			return l, err
+		}
+		select {
+		case <-time.After(m.opts.retryInterval):
+		case <-ctx.Done():
+			return nil, errors.WithStack(ctx.Err())
+		}
+	}
+}
+
+func (m *Mutex) tryLock(ctx context.Context, value []byte) (*Lease, error) {
+	old, err := m.client.Get(ctx, m.key)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		r, err := decodeRecord(old)
+		if err != nil {
+			return nil, err
+		}
+		now := m.opts.clock.Now()
+		if r != nil && r.held(now) {
+			return nil, ErrLocked
+		}
+		token := uint64(1)
+		if r != nil {
+			token = r.Token + 1
+		}
+		newRecord := &record{
+			Owner:  m.opts.owner,
+			Token:  token,
+			Expire: now.Add(m.opts.ttl).UnixNano(),
+			Value:  value,
+		}
+		newValue := newRecord.encode()
+		prev, ok, err := m.client.CompareAndSwap(ctx, m.key, old, newValue)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return newLease(m, newRecord, newValue, now), nil
+		}
+		// The mutex is changed by others, check it again.
+		old = prev
+	}
+}
+
+// Lease is a mutex held by the owner. It's renewed in the background until it's
+// released or lost.
+type Lease struct {
+	mutex  *Mutex
+	token  uint64
+	value  []byte
+	done   chan struct{}
+	cancel context.CancelFunc
+
+	// casMu serializes the CompareAndSwap of Renew and Release, so they don't
+	// swap the same stored value concurrently. mu is not held across the RPC.
+	casMu sync.Mutex
+	mu    struct {
+		sync.Mutex
+		// stored is the value of the key written by the last acquisition or renewal.
+		// It's nil once the lease is released or lost.
+		stored   []byte
+		deadline time.Time
+		err      error
+	}
+}
+
+func newLease(m *Mutex, r *record, stored []byte, acquired time.Time) *Lease {
+	l := &Lease{
+		mutex: m,
+		token: r.Token,
+		value: r.Value,
+		done:  make(chan struct{}),
+	}
+	l.mu.stored = stored
+	l.mu.deadline = acquired.Add(m.opts.ttl)
+	var ctx context.Context
+	ctx, l.cancel = context.WithCancel(context.Background())
+	if m.opts.renewInterval > 0 {
+		go l.renewLoop(ctx)
+	}
+	return l
+}
+
+// Token returns the fencing token of the lease. The tokens of a mutex are
+// increasing in the order of acquisition.
+func (l *Lease) Token() uint64 {
+	return l.token
+}
+
+// Owner returns the owner token of the lease.
+func (l *Lease) Owner() string {
+	return l.mutex.opts.owner
+}
+
+// Done returns a channel which is closed when the lease is released or lost.
+func (l *Lease) Done() <-chan struct{} {
+	return l.done
+}
+
+// Err returns ErrLeaseLost if the lease is lost, or nil if it's held or released.
+func (l *Lease) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.mu.err
+}
+
+// Valid returns whether the lease is still held. A lease is regarded as held until
+// the TTL passes since the last successful renewal started.
+func (l *Lease) Valid() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.validLocked()
+}
+
+func (l *Lease) validLocked() bool {
+	return l.mu.stored != nil && l.mutex.opts.clock.Now().Before(l.mu.deadline)
+}
+
+// Renew extends the lease by the TTL. It returns ErrLeaseLost if the lease has
+// expired, been taken over or been released.
+func (l *Lease) Renew(ctx context.Context) error {
+	l.casMu.Lock()
+	defer l.casMu.Unlock()
+	stored, _ := l.storedValue()
+	if stored == nil {
+		return ErrLeaseLost
+	}
+	now := l.mutex.opts.clock.Now()
+	r := &record{
+		Owner:  l.mutex.opts.owner,
+		Token:  l.token,
+		Expire: now.Add(l.mutex.opts.ttl).UnixNano(),
+		Value:  l.value,
+	}
+	newValue := r.encode()
+	_, ok, err := l.mutex.client.CompareAndSwap(ctx, l.mutex.key, stored, newValue)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !ok {
+		return l.loseLocked()
+	}
+	l.mu.stored = newValue
+	l.mu.deadline = now.Add(l.mutex.opts.ttl)
+	return nil
+}
+
+// Release releases the lease and stops the renewal. It returns ErrLeaseLost if
+// the lease was lost before.
+func (l *Lease) Release(ctx context.Context) error {
+	l.casMu.Lock()
+	defer l.casMu.Unlock()
+	stored, err := l.storedValue()
+	if stored == nil {
+		return err
+	}
+	r := &record{Token: l.token}
+	_, ok, err := l.mutex.client.CompareAndSwap(ctx, l.mutex.key, stored, r.encode())
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !ok {
+		return l.loseLocked()
+	}
+	l.mu.stored = nil
+	l.stopLocked()
+	return nil
+}
+
+// storedValue returns the value of the key written by the lease. It returns nil
+// if the lease is released, or nil with ErrLeaseLost if it's lost.
+func (l *Lease) storedValue() ([]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.mu.stored == nil {
+		return nil, l.mu.err
+	}
+	if !l.validLocked() {
+		return nil, l.loseLocked()
+	}
+	return l.mu.stored, nil
+}
+
+func (l *Lease) loseLocked() error {
+	if l.mu.stored != nil {
+		l.mu.stored = nil
+		l.mu.err = ErrLeaseLost
+		l.stopLocked()
+	}
+	return ErrLeaseLost
+}
+
+func (l *Lease) stopLocked() {
+	l.cancel()
+	close(l.done)
+}
+
+func (l *Lease) renewLoop(ctx context.Context) {
+	ticker := time.NewTicker(l.mutex.opts.renewInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// The renewal is retried in the next round if it fails for other reasons.
+			if err := l.Renew(ctx); err == ErrLeaseLost {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
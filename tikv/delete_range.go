@@ -0,0 +1,229 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/JK1Zhang/client-go/v3/internal/logutil"
+	"github.com/JK1Zhang/client-go/v3/kv"
+	"github.com/JK1Zhang/client-go/v3/metrics"
+	"github.com/JK1Zhang/client-go/v3/oracle"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Delete range constants.
+const (
+	// GcDeleteRangePrefix is the prefix of the pending delete ranges in SafePointKV.
+	GcDeleteRangePrefix = "/tidb/store/gcworker/delete_range/"
+	// GcDeleteRangeDonePrefix is the prefix of the done markers of the delete ranges in SafePointKV.
+	GcDeleteRangeDonePrefix = "/tidb/store/gcworker/delete_range_done/"
+)
+
+// DeleteRangeRecord is a range recorded by DeleteRangeLater.
+type DeleteRangeRecord struct {
+	// StartKey and EndKey are the range to delete, an empty EndKey means the range is unbounded.
+	StartKey []byte `json:"start_key"`
+	EndKey   []byte `json:"end_key"`
+	// TS is the deletion ts, which also identifies the range. The range is destroyed
+	// once the GC safepoint passes it.
+	TS uint64 `json:"ts"`
+	// Attempts is the number of failed attempts to destroy the range.
+	Attempts int `json:"attempts"`
+	// LastError is the error of the last failed attempt.
+	LastError string `json:"last_error,omitempty"`
+	// Done tells whether the range is destroyed.
+	Done bool `json:"done"`
+	// DoneTime is when the range is destroyed.
+	DoneTime time.Time `json:"done_time"`
+}
+
+// DeleteRangeLater records the range [startKey, endKey) to be deleted, and returns
+// its deletion ts. The range is destroyed by the GCWorker with UnsafeDestroyRange
+// once the GC safepoint passes the deletion ts, so the snapshots before the
+// deletion ts can still read the range until then.
+// The range must not be written after the call, the writes are destroyed too.
+// It returns an error if the SafePointKV of the store is a PDSafePointKV, whose
+// records are not seen by the GCWorkers in the other processes.
+func (s *KVStore) DeleteRangeLater(ctx context.Context, startKey []byte, endKey []byte) (uint64, error) {
+	if err := checkSharedSafePointKV(s.GetSafePointKV()); err != nil {
+		return 0, err
+	}
+	if len(endKey) > 0 && kv.CmpKey(startKey, endKey) >= 0 {
+		return 0, errors.Errorf("invalid delete range [%q, %q)", startKey, endKey)
+	}
+	ts, err := s.CurrentTimestamp(oracle.GlobalTxnScope)
+	if err != nil {
+		return 0, err
+	}
+	r := &DeleteRangeRecord{StartKey: startKey, EndKey: endKey, TS: ts}
+	if err = putDeleteRangeRecord(s.GetSafePointKV(), deleteRangeKey(GcDeleteRangePrefix, ts), r); err != nil {
+		return 0, err
+	}
+	logutil.Logger(ctx).Info("[gc worker] record delete range",
+		zap.String("startKey", kv.StrKey(startKey)),
+		zap.String("endKey", kv.StrKey(endKey)),
+		zap.Uint64("ts", ts))
+	return ts, nil
+}
+
+// ListDeleteRanges returns the ranges recorded by DeleteRangeLater, both pending
+// and done, in the order of the deletion ts. A done range is listed until the GC
+// safepoint passes the time it's destroyed.
+func (s *KVStore) ListDeleteRanges(ctx context.Context) ([]*DeleteRangeRecord, error) {
+	pending, err := loadDeleteRangeRecords(s.GetSafePointKV(), GcDeleteRangePrefix)
+	if err != nil {
+		return nil, err
+	}
+	done, err := loadDeleteRangeRecords(s.GetSafePointKV(), GcDeleteRangeDonePrefix)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]*DeleteRangeRecord, 0, len(pending)+len(done))
+	isDone := make(map[uint64]bool, len(done))
+	for _, r := range done {
+		isDone[r.TS] = true
+		records = append(records, r)
+	}
+	for _, r := range pending {
+		// The range is done but the pending record is not cleared yet.
+		if !isDone[r.TS] {
+			records = append(records, r)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].TS < records[j].TS
+	})
+	return records, nil
+}
+
+// destroyDeleteRanges destroys the pending delete ranges whose deletion ts is
+// before safePoint. A range is marked done once it's destroyed, otherwise the
+// failure is recorded and the range is retried in the next call. The done markers
+// are kept until safePoint passes their DoneTime, so they're removed by the
+// next GC job after the range is destroyed. It returns the number of the
+// destroyed ranges.
+func (s *KVStore) destroyDeleteRanges(ctx context.Context, safePoint uint64) (int, error) {
+	spkv := s.GetSafePointKV()
+	pending, err := loadDeleteRangeRecords(spkv, GcDeleteRangePrefix)
+	if err != nil {
+		return 0, err
+	}
+	var destroyed int
+	for _, r := range pending {
+		if r.TS >= safePoint {
+			continue
+		}
+		if err = ctx.Err(); err != nil {
+			return destroyed, errors.WithStack(err)
+		}
+		if err = s.UnsafeDestroyRange(ctx, r.StartKey, r.EndKey); err != nil {
+			metrics.TiKVGCWorkerActionsCounter.WithLabelValues("delete_range", "fail").Inc()
+			logutil.Logger(ctx).Warn("[gc worker] failed to destroy delete range",
+				zap.String("startKey", kv.StrKey(r.StartKey)),
+				zap.String("endKey", kv.StrKey(r.EndKey)),
+				zap.Uint64("ts", r.TS),
+				zap.Int("attempts", r.Attempts+1),
+				zap.Error(err))
+			r.Attempts++
+			r.LastError = err.Error()
+			if err = putDeleteRangeRecord(spkv, deleteRangeKey(GcDeleteRangePrefix, r.TS), r); err != nil {
+				return destroyed, err
+			}
+			continue
+		}
+
+		r.Done = true
+		r.DoneTime = time.Now()
+		if err = putDeleteRangeRecord(spkv, deleteRangeKey(GcDeleteRangeDonePrefix, r.TS), r); err != nil {
+			return destroyed, err
+		}
+		if err = deleteSafePointKey(spkv, deleteRangeKey(GcDeleteRangePrefix, r.TS)); err != nil {
+			return destroyed, err
+		}
+		destroyed++
+		metrics.TiKVGCWorkerActionsCounter.WithLabelValues("delete_range", "ok").Inc()
+		logutil.Logger(ctx).Info("[gc worker] destroyed delete range",
+			zap.String("startKey", kv.StrKey(r.StartKey)),
+			zap.String("endKey", kv.StrKey(r.EndKey)),
+			zap.Uint64("ts", r.TS))
+	}
+
+	done, err := loadDeleteRangeRecords(spkv, GcDeleteRangeDonePrefix)
+	if err != nil {
+		return destroyed, err
+	}
+	safePointTime := oracle.GetTimeFromTS(safePoint)
+	for _, r := range done {
+		if r.DoneTime.Before(safePointTime) {
+			if err = deleteSafePointKey(spkv, deleteRangeKey(GcDeleteRangeDonePrefix, r.TS)); err != nil {
+				return destroyed, err
+			}
+		}
+	}
+	return destroyed, nil
+}
+
+// deleteSafePointKey deletes the key from the SafePointKV, or clears it by an
+// empty value if the SafePointKV can't delete a key.
+func deleteSafePointKey(spkv SafePointKV, key string) error {
+	if d, ok := spkv.(deleteSafePointKV); ok {
+		return d.Delete(key)
+	}
+	return spkv.Put(key, "")
+}
+
+// deleteRangeKey returns the key of the delete range of the ts. The ts is padded
+// so that the keys are in the order of the ts.
+func deleteRangeKey(prefix string, ts uint64) string {
+	return fmt.Sprintf("%s%020d", prefix, ts)
+}
+
+func putDeleteRangeRecord(spkv SafePointKV, key string, r *DeleteRangeRecord) error {
+	value, err := json.Marshal(r)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return spkv.Put(key, string(value))
+}
+
+// loadDeleteRangeRecords loads the delete ranges under the prefix in the order of
+// the deletion ts. The cleared records are skipped.
+func loadDeleteRangeRecords(spkv SafePointKV, prefix string) ([]*DeleteRangeRecord, error) {
+	kvs, err := spkv.GetWithPrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]*DeleteRangeRecord, 0, len(kvs))
+	for _, item := range kvs {
+		if len(item.Value) == 0 {
+			continue
+		}
+		r := &DeleteRangeRecord{}
+		if err = json.Unmarshal(item.Value, r); err != nil {
+			return nil, errors.Wrapf(err, "invalid delete range record %s", item.Key)
+		}
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].TS < records[j].TS
+	})
+	return records, nil
+}
@@ -50,6 +50,33 @@ import (
 	zap "go.uber.org/zap"
 )
 
+const defaultGCConcurrency = 8
+
+type gcOptions struct {
+	concurrency      int
+	physicalScanLock bool
+}
+
+// GCOpt configures GC.
+type GCOpt func(*gcOptions)
+
+// WithConcurrency sets the number of the workers resolving locks, which is 8 by default.
+func WithConcurrency(concurrency int) GCOpt {
+	return func(o *gcOptions) {
+		o.concurrency = concurrency
+	}
+}
+
+// WithPhysicalScanLock sets whether to resolve locks by scanning the locks of each
+// store physically instead of scanning the regions, with lock observers catching
+// the locks written meanwhile. It falls back to scanning the regions if the lock
+// observers may have missed locks. It's disabled by default.
+func WithPhysicalScanLock(enable bool) GCOpt {
+	return func(o *gcOptions) {
+		o.physicalScanLock = enable
+	}
+}
+
 // GC does garbage collection (GC) of the TiKV cluster.
 // GC deletes MVCC records whose timestamp is lower than the given `safepoint`. We must guarantee
 //  that all transactions started before this timestamp had committed. We can keep an active
@@ -62,9 +89,31 @@ import (
 // 2. updating PD's known safepoint
 //
 // GC is a simplified version of [GC in TiDB](https://docs.pingcap.com/tidb/stable/garbage-collection-overview).
-// We skip the second step "delete ranges" which is an optimization for TiDB.
-func (s *KVStore) GC(ctx context.Context, safepoint uint64) (newSafePoint uint64, err error) {
-	err = s.resolveLocks(ctx, safepoint, 8)
+// GC itself skips the second step "delete ranges". GCWorker destroys the ranges
+// registered by DeleteRangeLater once its GC job passes their deletion ts.
+//
+// GC is a one-shot call, use GCWorker to run GC periodically.
+//
+// The safepoint never passes the minimal service safepoint registered in PD, see
+// RegisterServiceSafePoint. The returned newSafePoint is the safepoint actually used.
+func (s *KVStore) GC(ctx context.Context, safepoint uint64, opts ...GCOpt) (newSafePoint uint64, err error) {
+	options := &gcOptions{concurrency: defaultGCConcurrency}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.concurrency <= 0 {
+		options.concurrency = defaultGCConcurrency
+	}
+
+	safepoint, err = s.adjustSafePointByServiceSafePoints(ctx, safepoint)
+	if err != nil {
+		return
+	}
+	if options.physicalScanLock {
+		err = s.resolveLocksWithPhysicalScan(ctx, safepoint, options.concurrency)
+	} else {
+		err = s.resolveLocks(ctx, safepoint, options.concurrency)
+	}
 	if err != nil {
 		return
 	}
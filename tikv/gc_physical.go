@@ -0,0 +1,284 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/JK1Zhang/client-go/v3/internal/logutil"
+	"github.com/JK1Zhang/client-go/v3/kv"
+	"github.com/JK1Zhang/client-go/v3/metrics"
+	"github.com/JK1Zhang/client-go/v3/tikvrpc"
+	"github.com/JK1Zhang/client-go/v3/txnkv/txnlock"
+	"github.com/JK1Zhang/client-go/v3/util"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// errDirtyLockObserver means some locks may be missed by the lock observers, so
+// the physical scan is not trustworthy.
+var errDirtyLockObserver = errors.New("[gc worker] lock observer is dirty")
+
+// resolveLocksPhysical resolves the locks older than safePoint by scanning the
+// locks of each store physically, which doesn't go through the regions like
+// resolveLocks. The locks written during the scan are collected by the lock
+// observers registered on the stores before the scan. It returns
+// errDirtyLockObserver if any observer may have missed locks or the stores have
+// changed, then resolveLocks must be used instead.
+func (s *KVStore) resolveLocksPhysical(ctx context.Context, safePoint uint64) error {
+	stores, err := s.listStoresForUnsafeDestory(ctx)
+	if err != nil {
+		return err
+	}
+	logutil.Logger(ctx).Info("[gc worker] start resolving locks with physical scan lock",
+		zap.Uint64("safePoint", safePoint),
+		zap.Int("stores", len(stores)))
+
+	defer s.removeLockObservers(ctx, safePoint, stores)
+	if err = s.registerLockObservers(ctx, safePoint, stores); err != nil {
+		return err
+	}
+	if err = s.physicalScanAndResolveLocks(ctx, safePoint, stores); err != nil {
+		return err
+	}
+	util.EvalFailpoint("beforeCheckLockObservers")
+	locks, err := s.checkLockObservers(ctx, safePoint, stores)
+	if err != nil {
+		return err
+	}
+	if err = s.resolveLocksAcrossRegions(ctx, locks); err != nil {
+		return err
+	}
+	logutil.Logger(ctx).Info("[gc worker] finish resolving locks with physical scan lock",
+		zap.Uint64("safePoint", safePoint),
+		zap.Int("observedLocks", len(locks)))
+	return nil
+}
+
+func (s *KVStore) registerLockObservers(ctx context.Context, safePoint uint64, stores []*metapb.Store) error {
+	return forEachStore(stores, func(store *metapb.Store) error {
+		req := tikvrpc.NewRequest(tikvrpc.CmdRegisterLockObserver, &kvrpcpb.RegisterLockObserverRequest{MaxTs: safePoint})
+		resp, err := s.sendToStore(ctx, store, req)
+		if err != nil {
+			return err
+		}
+		if errStr := resp.Resp.(*kvrpcpb.RegisterLockObserverResponse).GetError(); errStr != "" {
+			return errors.Errorf("[gc worker] register lock observer on store %v failed: %s", store.GetId(), errStr)
+		}
+		return nil
+	})
+}
+
+// checkLockObservers returns the locks collected by the lock observers, sorted by
+// the keys. It returns errDirtyLockObserver if any observer is not clean or a
+// new store is added after the observers are registered.
+func (s *KVStore) checkLockObservers(ctx context.Context, safePoint uint64, stores []*metapb.Store) ([]*txnlock.Lock, error) {
+	var (
+		mu    sync.Mutex
+		locks = make(map[string]*txnlock.Lock)
+	)
+	err := forEachStore(stores, func(store *metapb.Store) error {
+		req := tikvrpc.NewRequest(tikvrpc.CmdCheckLockObserver, &kvrpcpb.CheckLockObserverRequest{MaxTs: safePoint})
+		resp, err := s.sendToStore(ctx, store, req)
+		if err != nil {
+			return err
+		}
+		checkResp := resp.Resp.(*kvrpcpb.CheckLockObserverResponse)
+		if checkResp.GetError() != "" {
+			return errors.Errorf("[gc worker] check lock observer on store %v failed: %s", store.GetId(), checkResp.GetError())
+		}
+		if !checkResp.GetIsClean() {
+			logutil.Logger(ctx).Warn("[gc worker] lock observer is dirty", zap.Uint64("storeID", store.GetId()))
+			return errDirtyLockObserver
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		// The locks of a region are observed on all its peers.
+		for _, l := range checkResp.GetLocks() {
+			locks[string(l.GetKey())] = txnlock.NewLock(l)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := s.listStoresForUnsafeDestory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	registered := make(map[uint64]struct{}, len(stores))
+	for _, store := range stores {
+		registered[store.GetId()] = struct{}{}
+	}
+	for _, store := range current {
+		if _, ok := registered[store.GetId()]; !ok {
+			logutil.Logger(ctx).Warn("[gc worker] store is added during physical scan lock", zap.Uint64("storeID", store.GetId()))
+			return nil, errDirtyLockObserver
+		}
+	}
+
+	res := make([]*txnlock.Lock, 0, len(locks))
+	for _, l := range locks {
+		res = append(res, l)
+	}
+	sort.Slice(res, func(i, j int) bool { return bytes.Compare(res[i].Key, res[j].Key) < 0 })
+	return res, nil
+}
+
+// removeLockObservers removes the lock observers. The errors are only logged,
+// because an observer left behind is replaced by the next registration.
+func (s *KVStore) removeLockObservers(ctx context.Context, safePoint uint64, stores []*metapb.Store) {
+	err := forEachStore(stores, func(store *metapb.Store) error {
+		req := tikvrpc.NewRequest(tikvrpc.CmdRemoveLockObserver, &kvrpcpb.RemoveLockObserverRequest{MaxTs: safePoint})
+		resp, err := s.sendToStore(ctx, store, req)
+		if err != nil {
+			return err
+		}
+		if errStr := resp.Resp.(*kvrpcpb.RemoveLockObserverResponse).GetError(); errStr != "" {
+			return errors.Errorf("[gc worker] remove lock observer on store %v failed: %s", store.GetId(), errStr)
+		}
+		return nil
+	})
+	if err != nil {
+		logutil.Logger(ctx).Warn("[gc worker] failed to remove lock observers", zap.Error(err))
+	}
+}
+
+// physicalScanAndResolveLocks scans the locks older than safePoint on each store
+// and resolves them.
+func (s *KVStore) physicalScanAndResolveLocks(ctx context.Context, safePoint uint64, stores []*metapb.Store) error {
+	return forEachStore(stores, func(store *metapb.Store) error {
+		var startKey []byte
+		var total int
+		for {
+			req := tikvrpc.NewRequest(tikvrpc.CmdPhysicalScanLock, &kvrpcpb.PhysicalScanLockRequest{
+				MaxTs:    safePoint,
+				StartKey: startKey,
+				Limit:    gcScanLockLimit,
+			})
+			resp, err := s.sendToStore(ctx, store, req)
+			if err != nil {
+				return err
+			}
+			scanResp := resp.Resp.(*kvrpcpb.PhysicalScanLockResponse)
+			if scanResp.GetError() != "" {
+				return errors.Errorf("[gc worker] physical scan lock on store %v failed: %s", store.GetId(), scanResp.GetError())
+			}
+			locks := make([]*txnlock.Lock, len(scanResp.GetLocks()))
+			for i, l := range scanResp.GetLocks() {
+				locks[i] = txnlock.NewLock(l)
+			}
+			if err = s.resolveLocksAcrossRegions(ctx, locks); err != nil {
+				return err
+			}
+			total += len(locks)
+			if len(locks) < gcScanLockLimit {
+				break
+			}
+			startKey = kv.NextKey(locks[len(locks)-1].Key)
+		}
+		logutil.Logger(ctx).Info("[gc worker] physical scan lock on store finished",
+			zap.Uint64("storeID", store.GetId()),
+			zap.Int("resolvedLocksNum", total))
+		return nil
+	})
+}
+
+// resolveLocksAcrossRegions resolves the locks sorted by the keys, which may
+// belong to multiple regions.
+func (s *KVStore) resolveLocksAcrossRegions(ctx context.Context, locks []*txnlock.Lock) error {
+	bo := NewGcResolveLockMaxBackoffer(ctx)
+	for len(locks) > 0 {
+		loc, err := s.GetRegionCache().LocateKey(bo, locks[0].Key)
+		if err != nil {
+			return err
+		}
+		n := 1
+		for n < len(locks) && loc.Contains(locks[n].Key) {
+			n++
+		}
+		resolvedLocation, err := s.batchResolveLocksInARegion(bo, locks[:n], loc)
+		if err != nil {
+			return err
+		}
+		// The region has changed, locate the locks again.
+		if resolvedLocation == nil {
+			continue
+		}
+		locks = locks[n:]
+		bo = NewGcResolveLockMaxBackoffer(ctx)
+	}
+	return nil
+}
+
+func (s *KVStore) sendToStore(ctx context.Context, store *metapb.Store, req *tikvrpc.Request) (*tikvrpc.Response, error) {
+	resp, err := s.GetTiKVClient().SendRequest(ctx, store.GetAddress(), req, ReadTimeoutMedium)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if resp == nil || resp.Resp == nil {
+		return nil, errors.Errorf("[gc worker] %s returns nil response from store %v", req.Type, store.GetId())
+	}
+	return resp, nil
+}
+
+// forEachStore calls f on the stores concurrently, and returns the first error.
+func forEachStore(stores []*metapb.Store, f func(store *metapb.Store) error) error {
+	var wg sync.WaitGroup
+	errCh := make(chan error, len(stores))
+	for _, store := range stores {
+		wg.Add(1)
+		go func(store *metapb.Store) {
+			defer wg.Done()
+			errCh <- f(store)
+		}(store)
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveLocksWithPhysicalScan tries resolveLocksPhysical, and falls back to
+// resolveLocks if it fails.
+func (s *KVStore) resolveLocksWithPhysicalScan(ctx context.Context, safePoint uint64, concurrency int) error {
+	err := s.resolveLocksPhysical(ctx, safePoint)
+	if err == nil {
+		metrics.TiKVGCWorkerActionsCounter.WithLabelValues("resolve_locks_physical", "ok").Inc()
+		return nil
+	}
+	if ctx.Err() != nil {
+		return err
+	}
+	if errors.Cause(err) == errDirtyLockObserver {
+		metrics.TiKVGCWorkerActionsCounter.WithLabelValues("resolve_locks_physical", "dirty").Inc()
+	} else {
+		metrics.TiKVGCWorkerActionsCounter.WithLabelValues("resolve_locks_physical", "fail").Inc()
+	}
+	logutil.Logger(ctx).Warn("[gc worker] resolve locks with physical scan lock failed, fall back to scanning the regions",
+		zap.Uint64("safePoint", safePoint),
+		zap.Error(err))
+	return s.resolveLocks(ctx, safePoint, concurrency)
+}
@@ -0,0 +1,322 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/JK1Zhang/client-go/v3/internal/logutil"
+	"github.com/JK1Zhang/client-go/v3/kv"
+	"github.com/JK1Zhang/client-go/v3/oracle"
+	"github.com/JK1Zhang/client-go/v3/txnkv/rangetask"
+	"github.com/JK1Zhang/client-go/v3/txnkv/txnlock"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// GCTxnState is the state of a transaction owning locks, which decides how GC
+// resolves the locks.
+type GCTxnState int
+
+// GCTxnState values.
+const (
+	// GCTxnAlive means the primary key is locked and the lock is not expired.
+	GCTxnAlive GCTxnState = iota
+	// GCTxnExpired means the primary key is locked but the lock is expired, GC rolls back the transaction.
+	GCTxnExpired
+	// GCTxnCommitted means the transaction is committed, GC commits its locks.
+	GCTxnCommitted
+	// GCTxnRolledBack means the transaction is rolled back, GC rolls back its locks.
+	GCTxnRolledBack
+	// GCTxnNotFound means the primary key is neither locked nor committed, GC rolls back the transaction.
+	GCTxnNotFound
+)
+
+func (s GCTxnState) String() string {
+	switch s {
+	case GCTxnAlive:
+		return "alive"
+	case GCTxnExpired:
+		return "expired"
+	case GCTxnCommitted:
+		return "committed"
+	case GCTxnRolledBack:
+		return "rolled_back"
+	case GCTxnNotFound:
+		return "not_found"
+	}
+	return "unknown"
+}
+
+// GCPlanTxn is a transaction owning locks older than the safepoint.
+type GCPlanTxn struct {
+	StartTS uint64
+	Primary []byte
+	// Locks is the number of the locks of the transaction.
+	Locks int
+	State GCTxnState
+	// CommitTS is the commit ts if the transaction is committed.
+	CommitTS uint64
+	// TTL is the TTL of the primary lock if the primary key is locked.
+	TTL uint64
+}
+
+// GCPlanRegion is a region with locks older than the safepoint.
+type GCPlanRegion struct {
+	RegionID uint64
+	StartKey []byte
+	EndKey   []byte
+	// Locks is the number of the locks in the region.
+	Locks int
+	// TxnIDs are the start ts of the transactions owning the locks, in ascending order.
+	TxnIDs []uint64
+	// ScanDuration is how long it took to scan the locks in the region.
+	ScanDuration time.Duration
+
+	txnIDs map[uint64]struct{}
+}
+
+// GCPlan reports what GC will do with a safepoint.
+type GCPlan struct {
+	// RequestedSafePoint is the safepoint passed to GCPlan.
+	RequestedSafePoint uint64
+	// SafePoint is the safepoint of the plan, which never passes the minimal service
+	// safepoint. It may be smaller than the one GC will use, see GCPlan.
+	SafePoint uint64
+	// Regions are the regions with locks, in the order of the keys.
+	Regions []*GCPlanRegion
+	// Txns are the transactions owning the locks, in the order of the start ts.
+	Txns []*GCPlanTxn
+	// TotalRegions is the number of the scanned regions.
+	TotalRegions int
+	// TotalLocks is the number of the locks older than the safepoint.
+	TotalLocks int
+	// Duration is how long the plan took.
+	Duration time.Duration
+	// EstimatedDuration estimates how long GC takes, assuming resolving the locks of
+	// a region takes as long as scanning them.
+	EstimatedDuration time.Duration
+}
+
+// TxnsInState returns the number of the transactions in the state.
+func (p *GCPlan) TxnsInState(state GCTxnState) int {
+	var n int
+	for _, txn := range p.Txns {
+		if txn.State == state {
+			n++
+		}
+	}
+	return n
+}
+
+// gcPlanner collects the locks of the regions.
+type gcPlanner struct {
+	mu      sync.Mutex
+	regions map[uint64]*GCPlanRegion
+	txns    map[uint64]*GCPlanTxn
+	scanned int
+}
+
+// GCPlan reports what GC with the safepoint will do without resolving anything:
+// the locks older than the safepoint per region and in total, the transactions
+// owning them and their status, and an estimated time of GC.
+// The concurrency is set by WithConcurrency like GC.
+func (s *KVStore) GCPlan(ctx context.Context, safepoint uint64, opts ...GCOpt) (*GCPlan, error) {
+	start := time.Now()
+	options := &gcOptions{concurrency: defaultGCConcurrency}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.concurrency <= 0 {
+		options.concurrency = defaultGCConcurrency
+	}
+
+	plan := &GCPlan{RequestedSafePoint: safepoint, SafePoint: safepoint}
+	// GCPlan doesn't register the service safepoint of the GC worker like GC, so the
+	// minimum includes the one left by the last GC, and the planned safepoint may
+	// be smaller than the one GC will use, but never greater.
+	minServiceSafePoint, err := s.loadMinServiceSafePoint(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if minServiceSafePoint < plan.SafePoint {
+		plan.SafePoint = minServiceSafePoint
+	}
+
+	p := &gcPlanner{
+		regions: make(map[uint64]*GCPlanRegion),
+		txns:    make(map[uint64]*GCPlanTxn),
+	}
+	handler := func(ctx context.Context, r kv.KeyRange) (rangetask.TaskStat, error) {
+		return s.planLocksForRange(ctx, p, plan.SafePoint, r.StartKey, r.EndKey)
+	}
+	runner := rangetask.NewRangeTaskRunner("gc-plan-runner", s, options.concurrency, handler)
+	if err = runner.RunOnRange(ctx, []byte(""), []byte("")); err != nil {
+		return nil, err
+	}
+	if err = s.checkPlanTxns(ctx, p, options.concurrency); err != nil {
+		return nil, err
+	}
+
+	var resolveDuration time.Duration
+	for _, r := range p.regions {
+		sort.Slice(r.TxnIDs, func(i, j int) bool { return r.TxnIDs[i] < r.TxnIDs[j] })
+		plan.Regions = append(plan.Regions, r)
+		plan.TotalLocks += r.Locks
+		resolveDuration += r.ScanDuration
+	}
+	sort.Slice(plan.Regions, func(i, j int) bool {
+		return bytes.Compare(plan.Regions[i].StartKey, plan.Regions[j].StartKey) < 0
+	})
+	for _, txn := range p.txns {
+		plan.Txns = append(plan.Txns, txn)
+	}
+	sort.Slice(plan.Txns, func(i, j int) bool { return plan.Txns[i].StartTS < plan.Txns[j].StartTS })
+	plan.TotalRegions = p.scanned
+	plan.Duration = time.Since(start)
+	plan.EstimatedDuration = plan.Duration + resolveDuration/time.Duration(options.concurrency)
+
+	logutil.Logger(ctx).Info("[gc worker] GC plan finished",
+		zap.Uint64("safePoint", plan.SafePoint),
+		zap.Int("regions", plan.TotalRegions),
+		zap.Int("regionsWithLocks", len(plan.Regions)),
+		zap.Int("locks", plan.TotalLocks),
+		zap.Int("txns", len(plan.Txns)),
+		zap.Duration("estimatedDuration", plan.EstimatedDuration))
+	return plan, nil
+}
+
+// planLocksForRange scans the locks older than safePoint in the range like
+// resolveLocksForRange, and collects them without resolving.
+func (s *KVStore) planLocksForRange(ctx context.Context, p *gcPlanner, safePoint uint64, startKey []byte, endKey []byte) (rangetask.TaskStat, error) {
+	var stat rangetask.TaskStat
+	key := startKey
+	bo := NewGcResolveLockMaxBackoffer(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return stat, errors.New("[gc worker] gc plan canceled")
+		default:
+		}
+
+		scanStart := time.Now()
+		locks, loc, err := s.scanLocksInRegionWithStartKey(bo, key, safePoint, gcScanLockLimit)
+		if err != nil {
+			return stat, err
+		}
+		scanDuration := time.Since(scanStart)
+
+		p.mu.Lock()
+		if len(locks) > 0 {
+			r, ok := p.regions[loc.Region.GetID()]
+			if !ok {
+				r = &GCPlanRegion{
+					RegionID: loc.Region.GetID(),
+					StartKey: loc.StartKey,
+					EndKey:   loc.EndKey,
+					txnIDs:   make(map[uint64]struct{}),
+				}
+				p.regions[loc.Region.GetID()] = r
+			}
+			r.Locks += len(locks)
+			r.ScanDuration += scanDuration
+			for _, l := range locks {
+				txn, ok := p.txns[l.TxnID]
+				if !ok {
+					txn = &GCPlanTxn{StartTS: l.TxnID, Primary: l.Primary}
+					p.txns[l.TxnID] = txn
+				}
+				txn.Locks++
+				if _, ok = r.txnIDs[l.TxnID]; !ok {
+					r.txnIDs[l.TxnID] = struct{}{}
+					r.TxnIDs = append(r.TxnIDs, l.TxnID)
+				}
+			}
+		}
+		if len(locks) < gcScanLockLimit {
+			p.scanned++
+		}
+		p.mu.Unlock()
+
+		if len(locks) < gcScanLockLimit {
+			stat.CompletedRegions++
+			key = loc.EndKey
+		} else {
+			key = kv.NextKey(locks[len(locks)-1].Key)
+		}
+		if len(key) == 0 || (len(endKey) != 0 && bytes.Compare(key, endKey) >= 0) {
+			break
+		}
+		bo = NewGcResolveLockMaxBackoffer(ctx)
+	}
+	return stat, nil
+}
+
+// checkPlanTxns queries the status of the transactions with PeekTxnStatus, so no
+// transaction is rolled back.
+func (s *KVStore) checkPlanTxns(ctx context.Context, p *gcPlanner, concurrency int) error {
+	txns := make(chan *GCPlanTxn, len(p.txns))
+	for _, txn := range p.txns {
+		txns <- txn
+	}
+	close(txns)
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, concurrency)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for txn := range txns {
+				bo := NewGcResolveLockMaxBackoffer(ctx)
+				state, status, err := s.peekTxnState(bo, txn.StartTS, txn.Primary)
+				if err != nil {
+					errCh <- err
+					return
+				}
+				txn.State = state
+				txn.CommitTS = status.CommitTS()
+				txn.TTL = status.TTL()
+			}
+		}()
+	}
+	wg.Wait()
+	close(errCh)
+	return <-errCh
+}
+
+// peekTxnState returns the state of the transaction with PeekTxnStatus, which
+// doesn't change the transaction.
+func (s *KVStore) peekTxnState(bo *Backoffer, txnID uint64, primary []byte) (GCTxnState, txnlock.TxnStatus, error) {
+	status, found, err := s.GetLockResolver().PeekTxnStatus(bo, txnID, primary)
+	if err != nil {
+		return GCTxnNotFound, status, err
+	}
+	switch {
+	case !found:
+		return GCTxnNotFound, status, nil
+	case status.IsCommitted():
+		return GCTxnCommitted, status, nil
+	case status.IsRolledBack():
+		return GCTxnRolledBack, status, nil
+	case s.GetOracle().IsExpired(txnID, status.TTL(), &oracle.Option{TxnScope: oracle.GlobalTxnScope}):
+		return GCTxnExpired, status, nil
+	}
+	return GCTxnAlive, status, nil
+}
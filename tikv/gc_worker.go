@@ -0,0 +1,485 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/JK1Zhang/client-go/v3/internal/logutil"
+	"github.com/JK1Zhang/client-go/v3/metrics"
+	"github.com/JK1Zhang/client-go/v3/oracle"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// GC worker constants.
+const (
+	// GcLeaderKey is the key of the leader of the GC workers in SafePointKV.
+	GcLeaderKey = "/tidb/store/gcworker/leader"
+	// GcLastRunKey is the key of the last GC job run by the GC workers in SafePointKV.
+	GcLastRunKey = "/tidb/store/gcworker/last_run"
+
+	defaultGCRunInterval  = 10 * time.Minute
+	defaultGCLifeTime     = 10 * time.Minute
+	defaultGCTickInterval = time.Minute
+	defaultGCLeaderLease  = 2 * time.Minute
+)
+
+type gcWorkerOptions struct {
+	runInterval  time.Duration
+	lifeTime     time.Duration
+	tickInterval time.Duration
+	leaderLease  time.Duration
+	concurrency  int
+	ownerID      string
+	// physicalScanLock enables WithPhysicalScanLock in the GC jobs.
+	physicalScanLock bool
+}
+
+// GCWorkerOption configures a GCWorker.
+type GCWorkerOption func(*gcWorkerOptions)
+
+// WithGCRunInterval sets the interval between two GC jobs, which is 10 minutes by default.
+func WithGCRunInterval(interval time.Duration) GCWorkerOption {
+	return func(o *gcWorkerOptions) {
+		o.runInterval = interval
+	}
+}
+
+// WithGCLifeTime sets how long the MVCC versions are retained, the safepoint of a
+// GC job is the time of the job minus the life time. It's 10 minutes by default.
+func WithGCLifeTime(lifeTime time.Duration) GCWorkerOption {
+	return func(o *gcWorkerOptions) {
+		o.lifeTime = lifeTime
+	}
+}
+
+// WithGCTickInterval sets how often the worker campaigns for the leadership and
+// checks whether a GC job is due, which is 1 minute by default.
+func WithGCTickInterval(interval time.Duration) GCWorkerOption {
+	return func(o *gcWorkerOptions) {
+		o.tickInterval = interval
+	}
+}
+
+// WithGCLeaderLease sets how long the leadership is kept without being renewed,
+// which is 2 minutes by default. It should be longer than the tick interval.
+func WithGCLeaderLease(lease time.Duration) GCWorkerOption {
+	return func(o *gcWorkerOptions) {
+		o.leaderLease = lease
+	}
+}
+
+// WithGCConcurrency sets the number of the workers resolving locks in a GC job,
+// which is 8 by default.
+func WithGCConcurrency(concurrency int) GCWorkerOption {
+	return func(o *gcWorkerOptions) {
+		o.concurrency = concurrency
+	}
+}
+
+// WithGCPhysicalScanLock sets whether the GC jobs resolve locks with physical scan
+// lock, see WithPhysicalScanLock. It's disabled by default.
+func WithGCPhysicalScanLock(enable bool) GCWorkerOption {
+	return func(o *gcWorkerOptions) {
+		o.physicalScanLock = enable
+	}
+}
+
+// WithGCOwnerID sets the id of the worker in the leader election, which is a
+// random uuid by default. The ids of the workers must be unique.
+func WithGCOwnerID(id string) GCWorkerOption {
+	return func(o *gcWorkerOptions) {
+		o.ownerID = id
+	}
+}
+
+// GCJobInfo describes a GC job.
+type GCJobInfo struct {
+	// Owner is the id of the worker running the job.
+	Owner string `json:"owner"`
+	// SafePoint is the safepoint of the job.
+	SafePoint uint64 `json:"safe_point"`
+	// StartTime is when the job started.
+	StartTime time.Time `json:"start_time"`
+	// Duration is how long the job took, it's 0 if the job is not finished.
+	Duration time.Duration `json:"duration"`
+	// Finished tells whether the job finished successfully.
+	Finished bool `json:"finished"`
+	// DeletedRanges is the number of the ranges recorded by DeleteRangeLater and
+	// destroyed by the job.
+	DeletedRanges int `json:"deleted_ranges"`
+}
+
+// GCWorkerStatus is the status of a GCWorker.
+type GCWorkerStatus struct {
+	// OwnerID is the id of the worker.
+	OwnerID string
+	// IsLeader tells whether the worker is the leader.
+	IsLeader bool
+	// Leader is the id of the leader known by the worker, it's empty if unknown.
+	Leader string
+	// Running tells whether the worker is running a GC job.
+	Running bool
+	// LastJob is the last GC job run by any worker, it's nil if there is none.
+	LastJob *GCJobInfo
+	// LastError is the error of the last GC job run by this worker, or the reason
+	// why the worker can't run GC.
+	LastError error
+}
+
+// gcLeaderRecord is the value of GcLeaderKey.
+type gcLeaderRecord struct {
+	Owner string `json:"owner"`
+	// Lease is the unix nano time when the leadership expires.
+	Lease int64 `json:"lease"`
+}
+
+// GCWorker runs GC periodically. The GCWorkers of a cluster elect a leader
+// through the SafePointKV of the store, and only the leader runs GC jobs.
+//
+// The safepoint of a GC job is the time of the job minus the GC life time, but
+// it never passes the minimal service safepoint registered in PD. The safepoint
+// is saved to the SafePointKV before the locks are resolved, so that the
+// transactions started before it fail on CheckVisibility. After the locks are
+// resolved, the ranges recorded by DeleteRangeLater before the safepoint are
+// destroyed.
+//
+// The leadership is updated atomically if the SafePointKV supports it, like
+// EtcdSafePointKV does. Otherwise more than one worker may run GC at the same
+// time, which is wasteful but safe. The workers don't run on a PDSafePointKV,
+// which doesn't share the leadership with the other processes.
+type GCWorker struct {
+	store *KVStore
+	opts  gcWorkerOptions
+
+	mu struct {
+		sync.Mutex
+		isLeader bool
+		leader   string
+		running  bool
+		lastJob  *GCJobInfo
+		lastErr  error
+	}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewGCWorker creates a GCWorker of the store. Call Start to run it.
+func NewGCWorker(store *KVStore, opts ...GCWorkerOption) *GCWorker {
+	o := gcWorkerOptions{
+		runInterval:  defaultGCRunInterval,
+		lifeTime:     defaultGCLifeTime,
+		tickInterval: defaultGCTickInterval,
+		leaderLease:  defaultGCLeaderLease,
+		concurrency:  defaultGCConcurrency,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.ownerID == "" {
+		o.ownerID = uuid.New().String()
+	}
+	if o.tickInterval <= 0 {
+		o.tickInterval = defaultGCTickInterval
+	}
+	if o.leaderLease <= 0 {
+		o.leaderLease = defaultGCLeaderLease
+	}
+	ctx, cancel := context.WithCancel(store.ctx)
+	return &GCWorker{
+		store:  store,
+		opts:   o,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Start starts the worker in the background. It stops when Close is called or
+// the store is closed.
+func (w *GCWorker) Start() {
+	w.wg.Add(1)
+	go w.run()
+}
+
+// Close stops the worker and waits for the running GC job to exit. The
+// leadership is resigned so that another worker takes over immediately.
+func (w *GCWorker) Close() {
+	w.cancel()
+	w.wg.Wait()
+	if err := w.resign(); err != nil {
+		logutil.BgLogger().Warn("[gc worker] failed to resign leadership",
+			zap.String("ownerID", w.opts.ownerID), zap.Error(err))
+	}
+}
+
+// Status returns the status of the worker.
+func (w *GCWorker) Status() GCWorkerStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return GCWorkerStatus{
+		OwnerID:   w.opts.ownerID,
+		IsLeader:  w.mu.isLeader,
+		Leader:    w.mu.leader,
+		Running:   w.mu.running,
+		LastJob:   w.mu.lastJob,
+		LastError: w.mu.lastErr,
+	}
+}
+
+func (w *GCWorker) run() {
+	defer w.wg.Done()
+	ticker := time.NewTicker(w.opts.tickInterval)
+	defer ticker.Stop()
+	for {
+		w.tick()
+		select {
+		case <-ticker.C:
+		case <-w.ctx.Done():
+			return
+		}
+	}
+}
+
+// tick renews the leadership, and starts a GC job if the worker is the leader and
+// the last job is old enough.
+func (w *GCWorker) tick() {
+	// The workers can't elect a leader if the SafePointKV isn't shared.
+	if err := checkSharedSafePointKV(w.store.GetSafePointKV()); err != nil {
+		w.mu.Lock()
+		w.mu.lastErr = err
+		w.mu.Unlock()
+		return
+	}
+	isLeader, err := w.checkLeader()
+	if err != nil {
+		metrics.TiKVGCWorkerActionsCounter.WithLabelValues("check_leader", "fail").Inc()
+		logutil.BgLogger().Warn("[gc worker] failed to check leader",
+			zap.String("ownerID", w.opts.ownerID), zap.Error(err))
+		return
+	}
+	lastJob, err := loadGCJobInfo(w.store.GetSafePointKV())
+	if err != nil {
+		logutil.BgLogger().Warn("[gc worker] failed to load last job",
+			zap.String("ownerID", w.opts.ownerID), zap.Error(err))
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.mu.lastJob = lastJob
+	if !isLeader || w.mu.running {
+		return
+	}
+	if lastJob != nil && time.Since(lastJob.StartTime) < w.opts.runInterval {
+		return
+	}
+	w.mu.running = true
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		job, err := w.runGCJob(w.ctx, lastJob)
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		w.mu.running = false
+		w.mu.lastErr = err
+		if job != nil {
+			w.mu.lastJob = job
+		}
+	}()
+}
+
+// checkLeader campaigns for the leadership, or renews it if the worker is the leader.
+func (w *GCWorker) checkLeader() (bool, error) {
+	spkv := w.store.GetSafePointKV()
+	oldValue, err := spkv.Get(GcLeaderKey)
+	if err != nil {
+		return false, err
+	}
+	var leader gcLeaderRecord
+	if oldValue != "" {
+		if err = json.Unmarshal([]byte(oldValue), &leader); err != nil {
+			return false, errors.WithStack(err)
+		}
+	}
+	now := time.Now()
+	if leader.Owner != "" && leader.Owner != w.opts.ownerID && now.UnixNano() < leader.Lease {
+		w.setLeader(leader.Owner)
+		return false, nil
+	}
+
+	newValue, err := json.Marshal(gcLeaderRecord{Owner: w.opts.ownerID, Lease: now.Add(w.opts.leaderLease).UnixNano()})
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	ok, err := compareAndSwapSafePointKV(spkv, GcLeaderKey, oldValue, string(newValue))
+	if err != nil || !ok {
+		// Another worker updated the leadership at the same time, check it in the next tick.
+		return false, err
+	}
+	if leader.Owner != w.opts.ownerID {
+		metrics.TiKVGCWorkerActionsCounter.WithLabelValues("become_leader", "ok").Inc()
+		logutil.BgLogger().Info("[gc worker] became the leader", zap.String("ownerID", w.opts.ownerID))
+	}
+	w.setLeader(w.opts.ownerID)
+	return true, nil
+}
+
+func (w *GCWorker) setLeader(leader string) {
+	isLeader := leader == w.opts.ownerID
+	w.mu.Lock()
+	w.mu.isLeader = isLeader
+	w.mu.leader = leader
+	w.mu.Unlock()
+	if isLeader {
+		metrics.TiKVGCWorkerIsLeader.Set(1)
+	} else {
+		metrics.TiKVGCWorkerIsLeader.Set(0)
+	}
+}
+
+// resign expires the leadership if the worker is the leader.
+func (w *GCWorker) resign() error {
+	spkv := w.store.GetSafePointKV()
+	oldValue, err := spkv.Get(GcLeaderKey)
+	if err != nil || oldValue == "" {
+		return err
+	}
+	var leader gcLeaderRecord
+	if err = json.Unmarshal([]byte(oldValue), &leader); err != nil {
+		return errors.WithStack(err)
+	}
+	if leader.Owner != w.opts.ownerID {
+		return nil
+	}
+	newValue, err := json.Marshal(gcLeaderRecord{})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err = compareAndSwapSafePointKV(spkv, GcLeaderKey, oldValue, string(newValue)); err != nil {
+		return err
+	}
+	w.setLeader("")
+	return nil
+}
+
+// runGCJob runs a GC job and returns the finished job. It returns nil if the
+// safepoint doesn't move forward since the last job.
+func (w *GCWorker) runGCJob(ctx context.Context, lastJob *GCJobInfo) (*GCJobInfo, error) {
+	start := time.Now()
+	safePoint, err := w.calcSafePoint(ctx)
+	if err != nil {
+		metrics.TiKVGCWorkerActionsCounter.WithLabelValues("run_job", "fail").Inc()
+		logutil.Logger(ctx).Error("[gc worker] failed to calculate safepoint",
+			zap.String("ownerID", w.opts.ownerID), zap.Error(err))
+		return nil, err
+	}
+	if lastJob != nil && lastJob.Finished && safePoint <= lastJob.SafePoint {
+		metrics.TiKVGCWorkerActionsCounter.WithLabelValues("run_job", "skip").Inc()
+		logutil.Logger(ctx).Info("[gc worker] safepoint is not moved forward, skip this round",
+			zap.String("ownerID", w.opts.ownerID),
+			zap.Uint64("safePoint", safePoint),
+			zap.Uint64("lastSafePoint", lastJob.SafePoint))
+		return nil, nil
+	}
+
+	logutil.Logger(ctx).Info("[gc worker] start GC job",
+		zap.String("ownerID", w.opts.ownerID),
+		zap.Uint64("safePoint", safePoint),
+		zap.Time("safePointTime", oracle.GetTimeFromTS(safePoint)))
+	job := &GCJobInfo{Owner: w.opts.ownerID, SafePoint: safePoint, StartTime: start}
+	spkv := w.store.GetSafePointKV()
+	err = saveGCJobInfo(spkv, job)
+	if err == nil {
+		err = saveSafePoint(spkv, safePoint)
+	}
+	if err == nil {
+		safePoint, err = w.store.GC(ctx, safePoint, WithConcurrency(w.opts.concurrency), WithPhysicalScanLock(w.opts.physicalScanLock))
+	}
+	if err == nil {
+		job.DeletedRanges, err = w.store.destroyDeleteRanges(ctx, safePoint)
+	}
+	if err == nil {
+		job.Duration = time.Since(start)
+		job.Finished = true
+		err = saveGCJobInfo(spkv, job)
+	}
+	if err != nil {
+		metrics.TiKVGCWorkerActionsCounter.WithLabelValues("run_job", "fail").Inc()
+		logutil.Logger(ctx).Error("[gc worker] GC job failed",
+			zap.String("ownerID", w.opts.ownerID),
+			zap.Uint64("safePoint", safePoint),
+			zap.Error(err))
+		return job, err
+	}
+
+	metrics.TiKVGCWorkerActionsCounter.WithLabelValues("run_job", "ok").Inc()
+	metrics.TiKVGCDurationHistogram.Observe(job.Duration.Seconds())
+	metrics.TiKVGCSafePointGauge.Set(float64(oracle.GetTimeFromTS(safePoint).Unix()))
+	logutil.Logger(ctx).Info("[gc worker] finished GC job",
+		zap.String("ownerID", w.opts.ownerID),
+		zap.Uint64("safePoint", safePoint),
+		zap.Duration("duration", job.Duration))
+	return job, nil
+}
+
+// calcSafePoint returns the current time minus the GC life time, or the minimal
+// service safepoint if it's smaller.
+func (w *GCWorker) calcSafePoint(ctx context.Context) (uint64, error) {
+	now, err := w.store.CurrentTimestamp(oracle.GlobalTxnScope)
+	if err != nil {
+		return 0, err
+	}
+	safePoint := oracle.GoTimeToTS(oracle.GetTimeFromTS(now).Add(-w.opts.lifeTime))
+	return w.store.adjustSafePointByServiceSafePoints(ctx, safePoint)
+}
+
+// compareAndSwapSafePointKV sets the key to newValue if its value is oldValue. The
+// key is set by Put if the SafePointKV can't update a key atomically.
+func compareAndSwapSafePointKV(spkv SafePointKV, k string, oldValue string, newValue string) (bool, error) {
+	if kv, ok := spkv.(casSafePointKV); ok {
+		return kv.CompareAndSwap(k, oldValue, newValue)
+	}
+	if err := spkv.Put(k, newValue); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func saveGCJobInfo(spkv SafePointKV, job *GCJobInfo) error {
+	value, err := json.Marshal(job)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return spkv.Put(GcLastRunKey, string(value))
+}
+
+func loadGCJobInfo(spkv SafePointKV) (*GCJobInfo, error) {
+	value, err := spkv.Get(GcLastRunKey)
+	if err != nil || value == "" {
+		return nil, err
+	}
+	job := &GCJobInfo{}
+	if err = json.Unmarshal([]byte(value), job); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return job, nil
+}
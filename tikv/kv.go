@@ -140,6 +140,9 @@ type KVStore struct {
 	spTime    time.Time
 	spMutex   sync.RWMutex // this is used to update safePoint and spTime
 
+	// serviceSafePoints are the service safepoints registered by RegisterServiceSafePoint.
+	serviceSafePoints serviceSafePoints
+
 	// storeID -> safeTS, stored as map[uint64]uint64
 	// safeTS here will be used during the Stale Read process,
 	// it indicates the safe timestamp point that can be used to read consistent but may not the latest data.
@@ -162,7 +165,12 @@ func (s *KVStore) UpdateSPCache(cachedSP uint64, cachedTime time.Time) {
 }
 
 // CheckVisibility checks if it is safe to read using given ts.
+// It's always safe if ts is protected by a service safepoint registered by RegisterServiceSafePoint.
 func (s *KVStore) CheckVisibility(startTime uint64) error {
+	if s.isProtectedByServiceSafePoint(startTime) {
+		return nil
+	}
+
 	s.spMutex.RLock()
 	cachedSafePoint := s.safePoint
 	cachedTime := s.spTime
@@ -207,6 +215,9 @@ func NewKVStore(uuid string, pdClient pd.Client, spkv SafePointKV, tikvclient Cl
 	}
 	store.clientMu.client = client.NewReqCollapse(client.NewInterceptedClient(tikvclient))
 	store.lockResolver = txnlock.NewLockResolver(store)
+	if config.GetGlobalConfig().TiKVClient.ResolvedCache.Shared {
+		store.lockResolver.SetResolvedCache(txnlock.SharedResolvedCache(store.clusterID))
+	}
 
 	store.wg.Add(2)
 	go store.runSafePointChecker()
@@ -449,6 +460,12 @@ func (s *KVStore) SetTiKVClient(client Client) {
 	s.clientMu.client = client
 }
 
+// SetResolvedCache resets the cache of the resolved transaction statuses of the
+// LockResolver. The stores connected to the same cluster can share a cache.
+func (s *KVStore) SetResolvedCache(cache txnlock.ResolvedCache) {
+	s.lockResolver.SetResolvedCache(cache)
+}
+
 // GetTiKVClient gets the client instance.
 func (s *KVStore) GetTiKVClient() (client Client) {
 	s.clientMu.RLock()
@@ -613,12 +630,13 @@ func NewLockResolver(etcdAddrs []string, security config.Security, opts ...pd.Cl
 		return nil, err
 	}
 
-	spkv, err := NewEtcdSafePointKV(etcdAddrs, tlsConfig)
+	pdClient := locate.NewCodeCPDClient(pdCli)
+	spkv, err := NewSafePointKV(pdClient, etcdAddrs, tlsConfig)
 	if err != nil {
 		return nil, err
 	}
 
-	s, err := NewKVStore(uuid, locate.NewCodeCPDClient(pdCli), spkv, client.NewRPCClient(WithSecurity(security)))
+	s, err := NewKVStore(uuid, pdClient, spkv, client.NewRPCClient(WithSecurity(security)))
 	if err != nil {
 		return nil, err
 	}
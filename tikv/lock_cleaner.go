@@ -0,0 +1,373 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/JK1Zhang/client-go/v3/internal/logutil"
+	"github.com/JK1Zhang/client-go/v3/kv"
+	"github.com/JK1Zhang/client-go/v3/metrics"
+	"github.com/JK1Zhang/client-go/v3/oracle"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultLockCleanerInterval   = time.Minute
+	defaultLockCleanerMinLockAge = 10 * time.Minute
+	defaultLockCleanerHotRegions = 64
+
+	// lockCleanerScanLimit is the max number of the locks scanned at a time, a range
+	// is scanned page by page.
+	lockCleanerScanLimit = 1024
+	// lockCleanerBatchSize is the max number of the locks resolved at a time.
+	lockCleanerBatchSize = 64
+)
+
+type lockCleanerOptions struct {
+	interval   time.Duration
+	minLockAge time.Duration
+	ranges     []kv.KeyRange
+	hotRegions int
+	rate       float64
+}
+
+// LockCleanerOption configures a LockCleaner.
+type LockCleanerOption func(*lockCleanerOptions)
+
+// WithLockCleanerInterval sets the interval between two rounds, which is 1 minute
+// by default.
+func WithLockCleanerInterval(interval time.Duration) LockCleanerOption {
+	return func(o *lockCleanerOptions) {
+		o.interval = interval
+	}
+}
+
+// WithLockCleanerMinLockAge sets how old a lock must be to be cleaned, which is 10
+// minutes by default. The age of a lock is measured from the start ts of its
+// transaction.
+func WithLockCleanerMinLockAge(age time.Duration) LockCleanerOption {
+	return func(o *lockCleanerOptions) {
+		o.minLockAge = age
+	}
+}
+
+// WithLockCleanerRanges sets the key ranges to scan. If no range is set, the
+// regions accessed most recently by the store are scanned instead.
+func WithLockCleanerRanges(ranges ...kv.KeyRange) LockCleanerOption {
+	return func(o *lockCleanerOptions) {
+		o.ranges = ranges
+	}
+}
+
+// WithLockCleanerHotRegions sets the number of the regions scanned in a round
+// when no range is set, which is 64 by default.
+func WithLockCleanerHotRegions(n int) LockCleanerOption {
+	return func(o *lockCleanerOptions) {
+		o.hotRegions = n
+	}
+}
+
+// WithLockCleanerRate sets the max number of the locks resolved per second. It's
+// unlimited by default.
+func WithLockCleanerRate(locksPerSecond float64) LockCleanerOption {
+	return func(o *lockCleanerOptions) {
+		o.rate = locksPerSecond
+	}
+}
+
+// LockCleanerResult is the result of a round of a LockCleaner.
+type LockCleanerResult struct {
+	// Ranges is the number of the scanned ranges.
+	Ranges int
+	// Scanned is the number of the scanned locks.
+	Scanned int
+	// Resolved is the number of the resolved locks.
+	Resolved int
+	// Alive is the number of the locks skipped because their transactions are alive.
+	Alive int
+	// Failed is the number of the locks failed to resolve.
+	Failed int
+	// Duration is how long the round took.
+	Duration time.Duration
+}
+
+// LockCleaner cleans the locks left by the abandoned transactions between the GC
+// runs. In each round, it scans the configured key ranges or the hottest regions
+// for the locks older than the min lock age, and resolves the ones whose
+// transactions are dead, see LockStatus.IsOwnerDead.
+type LockCleaner struct {
+	store *KVStore
+	opts  lockCleanerOptions
+
+	mu struct {
+		sync.Mutex
+		lastResult LockCleanerResult
+		lastErr    error
+	}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewLockCleaner creates a LockCleaner of the store. Call Start to run it in the
+// background, or call RunOnce to run a round.
+func NewLockCleaner(store *KVStore, opts ...LockCleanerOption) *LockCleaner {
+	o := lockCleanerOptions{
+		interval:   defaultLockCleanerInterval,
+		minLockAge: defaultLockCleanerMinLockAge,
+		hotRegions: defaultLockCleanerHotRegions,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.interval <= 0 {
+		o.interval = defaultLockCleanerInterval
+	}
+	ctx, cancel := context.WithCancel(store.ctx)
+	return &LockCleaner{
+		store:  store,
+		opts:   o,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Start starts the cleaner in the background. It stops when Close is called or
+// the store is closed.
+func (c *LockCleaner) Start() {
+	c.wg.Add(1)
+	go c.run()
+}
+
+// Close stops the cleaner and waits for the running round to exit.
+func (c *LockCleaner) Close() {
+	c.cancel()
+	c.wg.Wait()
+}
+
+// LastResult returns the result and the error of the last round run in the
+// background.
+func (c *LockCleaner) LastResult() (LockCleanerResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.mu.lastResult, c.mu.lastErr
+}
+
+func (c *LockCleaner) run() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.opts.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.ctx.Done():
+			return
+		}
+		res, err := c.RunOnce(c.ctx)
+		if err != nil {
+			logutil.BgLogger().Warn("[lock cleaner] round failed", zap.Error(err))
+		}
+		c.mu.Lock()
+		c.mu.lastResult, c.mu.lastErr = res, err
+		c.mu.Unlock()
+	}
+}
+
+// RunOnce runs a round. The failures of the ranges don't stop the round, the
+// first error is returned after all the ranges are processed.
+func (c *LockCleaner) RunOnce(ctx context.Context) (LockCleanerResult, error) {
+	start := time.Now()
+	res, err := c.runOnce(ctx)
+	res.Duration = time.Since(start)
+
+	result := "ok"
+	if err != nil {
+		result = "fail"
+	}
+	metrics.TiKVLockCleanerRunDuration.WithLabelValues(result).Observe(res.Duration.Seconds())
+	metrics.TiKVLockCleanerLocksCounter.WithLabelValues("scanned").Add(float64(res.Scanned))
+	metrics.TiKVLockCleanerLocksCounter.WithLabelValues("resolved").Add(float64(res.Resolved))
+	metrics.TiKVLockCleanerLocksCounter.WithLabelValues("alive").Add(float64(res.Alive))
+	metrics.TiKVLockCleanerLocksCounter.WithLabelValues("failed").Add(float64(res.Failed))
+	if res.Scanned > 0 {
+		logutil.Logger(ctx).Info("[lock cleaner] round finished",
+			zap.Int("ranges", res.Ranges),
+			zap.Int("scanned", res.Scanned),
+			zap.Int("resolved", res.Resolved),
+			zap.Int("alive", res.Alive),
+			zap.Int("failed", res.Failed),
+			zap.Duration("duration", res.Duration))
+	}
+	return res, err
+}
+
+func (c *LockCleaner) runOnce(ctx context.Context) (LockCleanerResult, error) {
+	var res LockCleanerResult
+	now, err := c.store.CurrentTimestamp(oracle.GlobalTxnScope)
+	if err != nil {
+		return res, err
+	}
+	maxTS := oracle.GoTimeToTS(oracle.GetTimeFromTS(now).Add(-c.opts.minLockAge))
+	ranges := c.opts.ranges
+	if len(ranges) == 0 {
+		ranges = c.hotRanges()
+	}
+	res.Ranges = len(ranges)
+
+	limiter := newLockCleanerLimiter(c.opts.rate)
+	var firstErr error
+	for _, r := range ranges {
+		if err = c.cleanRange(ctx, r, maxTS, limiter, &res); err != nil {
+			logutil.Logger(ctx).Warn("[lock cleaner] failed to clean range",
+				zap.String("startKey", kv.StrKey(r.StartKey)),
+				zap.String("endKey", kv.StrKey(r.EndKey)),
+				zap.Error(err))
+			if firstErr == nil {
+				firstErr = err
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+	}
+	return res, firstErr
+}
+
+// hotRanges returns the ranges of the regions accessed most recently.
+func (c *LockCleaner) hotRanges() []kv.KeyRange {
+	regions := c.store.GetRegionCache().RecentRegions(c.opts.hotRegions)
+	ranges := make([]kv.KeyRange, len(regions))
+	for i, r := range regions {
+		ranges[i] = kv.KeyRange{StartKey: r.StartKey(), EndKey: r.EndKey()}
+	}
+	return ranges
+}
+
+// cleanRange scans the range page by page, so the alive locks at the head of the
+// range don't hide the dead ones after them.
+func (c *LockCleaner) cleanRange(ctx context.Context, r kv.KeyRange, maxTS uint64, limiter *lockCleanerLimiter, res *LockCleanerResult) error {
+	var firstErr error
+	startKey := r.StartKey
+	for {
+		locks, err := c.store.ScanLocks(ctx, startKey, r.EndKey, maxTS, lockCleanerScanLimit)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			return firstErr
+		}
+		if err = c.resolveLocks(ctx, locks, limiter, res); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if len(locks) < lockCleanerScanLimit || ctx.Err() != nil {
+			return firstErr
+		}
+		startKey = kv.NextKey(locks[len(locks)-1].Key)
+	}
+}
+
+func (c *LockCleaner) resolveLocks(ctx context.Context, locks []*LockStatus, limiter *lockCleanerLimiter, res *LockCleanerResult) error {
+	res.Scanned += len(locks)
+	dead := make([]*LockStatus, 0, len(locks))
+	for _, l := range locks {
+		if l.IsOwnerDead() {
+			dead = append(dead, l)
+		} else {
+			res.Alive++
+		}
+	}
+
+	var firstErr error
+	batchSize := limiter.batchSize(lockCleanerBatchSize)
+	for len(dead) > 0 {
+		n := batchSize
+		if n > len(dead) {
+			n = len(dead)
+		}
+		if err := limiter.wait(ctx, n); err != nil {
+			res.Failed += len(dead)
+			return err
+		}
+		resolved, err := c.store.ResolveDeadLocks(ctx, dead[:n])
+		res.Resolved += resolved
+		if alive, ok := errors.Cause(err).(*aliveLocksError); ok {
+			// The transactions renewed after the scan are not failures.
+			res.Alive += alive.locks
+		} else if err != nil {
+			res.Failed += n - resolved
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		dead = dead[n:]
+	}
+	return firstErr
+}
+
+// lockCleanerLimiter paces the locks to resolve at the given rate. A
+// non-positive rate means unlimited.
+type lockCleanerLimiter struct {
+	rate float64
+	next time.Time
+}
+
+func newLockCleanerLimiter(rate float64) *lockCleanerLimiter {
+	return &lockCleanerLimiter{rate: rate}
+}
+
+// batchSize returns the number of the locks to resolve at a time, which is small
+// enough to pace the locks about every 100ms.
+func (l *lockCleanerLimiter) batchSize(max int) int {
+	if l.rate <= 0 {
+		return max
+	}
+	n := int(l.rate / 10)
+	if n < 1 {
+		return 1
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
+
+// wait waits until n locks can be resolved.
+func (l *lockCleanerLimiter) wait(ctx context.Context, n int) error {
+	if l.rate <= 0 {
+		return nil
+	}
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	d := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
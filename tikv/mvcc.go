@@ -0,0 +1,190 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+
+	tikverr "github.com/JK1Zhang/client-go/v3/error"
+	"github.com/JK1Zhang/client-go/v3/internal/locate"
+	"github.com/JK1Zhang/client-go/v3/internal/retry"
+	"github.com/JK1Zhang/client-go/v3/tikvrpc"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pkg/errors"
+)
+
+const mvccInfoMaxBackoff = 20000
+
+// MVCCInfo is all the MVCC versions of a key.
+type MVCCInfo struct {
+	Key []byte
+	// Lock is the lock on the key, it's nil if the key is not locked.
+	Lock *MVCCLock
+	// Writes are the write records of the key, from the newest to the oldest.
+	Writes []MVCCWrite
+	// Values are the values which are not stored in the write records, from the newest to the oldest.
+	Values []MVCCValue
+}
+
+// MVCCLock is the lock of a key.
+type MVCCLock struct {
+	Type           kvrpcpb.Op
+	StartTS        uint64
+	Primary        []byte
+	ShortValue     []byte
+	TTL            uint64
+	ForUpdateTS    uint64
+	TxnSize        uint64
+	UseAsyncCommit bool
+	Secondaries    [][]byte
+	RollbackTSs    []uint64
+}
+
+// MVCCWrite is a write record of a key, which tells who wrote the version and when.
+type MVCCWrite struct {
+	Type       kvrpcpb.Op
+	StartTS    uint64
+	CommitTS   uint64
+	ShortValue []byte
+}
+
+// MVCCValue is a value of a key written by the transaction of StartTS.
+type MVCCValue struct {
+	StartTS uint64
+	Value   []byte
+}
+
+func newMVCCInfo(key []byte, info *kvrpcpb.MvccInfo) *MVCCInfo {
+	res := &MVCCInfo{Key: key}
+	if info == nil {
+		return res
+	}
+	if l := info.GetLock(); l != nil {
+		res.Lock = &MVCCLock{
+			Type:           l.Type,
+			StartTS:        l.StartTs,
+			Primary:        l.Primary,
+			ShortValue:     l.ShortValue,
+			TTL:            l.Ttl,
+			ForUpdateTS:    l.ForUpdateTs,
+			TxnSize:        l.TxnSize,
+			UseAsyncCommit: l.UseAsyncCommit,
+			Secondaries:    l.Secondaries,
+			RollbackTSs:    l.RollbackTs,
+		}
+	}
+	for _, w := range info.GetWrites() {
+		res.Writes = append(res.Writes, MVCCWrite{
+			Type:       w.Type,
+			StartTS:    w.StartTs,
+			CommitTS:   w.CommitTs,
+			ShortValue: w.ShortValue,
+		})
+	}
+	for _, v := range info.GetValues() {
+		res.Values = append(res.Values, MVCCValue{
+			StartTS: v.StartTs,
+			Value:   v.Value,
+		})
+	}
+	return res
+}
+
+// GetMVCCByKey returns all the MVCC versions of the key, including the lock,
+// the write records and the values. It is used for debugging.
+func (s *KVStore) GetMVCCByKey(ctx context.Context, key []byte) (*MVCCInfo, error) {
+	bo := retry.NewBackofferWithVars(ctx, mvccInfoMaxBackoff, nil)
+	req := tikvrpc.NewRequest(tikvrpc.CmdMvccGetByKey, &kvrpcpb.MvccGetByKeyRequest{Key: key})
+	for {
+		loc, err := s.regionCache.LocateKey(bo, key)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.sendMVCCReq(bo, req, loc)
+		if err != nil {
+			return nil, err
+		}
+		if resp == nil {
+			continue
+		}
+		mvccResp := resp.(*kvrpcpb.MvccGetByKeyResponse)
+		if mvccResp.GetError() != "" {
+			return nil, errors.Errorf("unexpected MvccGetByKey error: %s", mvccResp.GetError())
+		}
+		return newMVCCInfo(key, mvccResp.GetInfo()), nil
+	}
+}
+
+// GetMVCCSampleByStartTS returns the MVCC versions of a sample of the keys
+// written or locked by the transaction of startTS. It is used for debugging.
+// Every region is asked for the transaction, and TiKV reports at most one key of
+// each region, so the result has one sample key for each region the transaction
+// touched, not all the keys of the transaction. The other keys can be found by
+// the lock or the values of the sample keys, then read by GetMVCCByKey.
+func (s *KVStore) GetMVCCSampleByStartTS(ctx context.Context, startTS uint64) ([]*MVCCInfo, error) {
+	var res []*MVCCInfo
+	key := []byte{}
+	bo := retry.NewBackofferWithVars(ctx, mvccInfoMaxBackoff, nil)
+	for {
+		loc, err := s.regionCache.LocateKey(bo, key)
+		if err != nil {
+			return nil, err
+		}
+		req := tikvrpc.NewRequest(tikvrpc.CmdMvccGetByStartTs, &kvrpcpb.MvccGetByStartTsRequest{StartTs: startTS})
+		resp, err := s.sendMVCCReq(bo, req, loc)
+		if err != nil {
+			return nil, err
+		}
+		if resp == nil {
+			continue
+		}
+		mvccResp := resp.(*kvrpcpb.MvccGetByStartTsResponse)
+		if mvccResp.GetError() != "" {
+			return nil, errors.Errorf("unexpected MvccGetByStartTs error: %s", mvccResp.GetError())
+		}
+		if len(mvccResp.GetKey()) > 0 {
+			res = append(res, newMVCCInfo(mvccResp.GetKey(), mvccResp.GetInfo()))
+		}
+		if len(loc.EndKey) == 0 {
+			return res, nil
+		}
+		key = loc.EndKey
+		bo = retry.NewBackofferWithVars(ctx, mvccInfoMaxBackoff, nil)
+	}
+}
+
+// sendMVCCReq sends the request to the region. It returns a nil response if the
+// request should be retried after the region cache is updated.
+func (s *KVStore) sendMVCCReq(bo *retry.Backoffer, req *tikvrpc.Request, loc *locate.KeyLocation) (interface{}, error) {
+	resp, err := s.SendReq(bo, req, loc.Region, ReadTimeoutShort)
+	if err != nil {
+		return nil, err
+	}
+	regionErr, err := resp.GetRegionError()
+	if err != nil {
+		return nil, err
+	}
+	if regionErr != nil {
+		err = bo.Backoff(BoRegionMiss(), errors.New(regionErr.String()))
+		if err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+	if resp.Resp == nil {
+		return nil, errors.WithStack(tikverr.ErrBodyMissing)
+	}
+	return resp.Resp, nil
+}
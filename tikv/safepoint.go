@@ -43,12 +43,15 @@ import (
 	"time"
 
 <<<<<<< HEAD
+	"github.com/JK1Zhang/client-go/v3/config"
 	"github.com/JK1Zhang/client-go/v3/internal/logutil"
 	"github.com/pkg/errors"
 =======
 	"github.com/pkg/errors"
+	"github.com/tikv/client-go/v2/config"
 	"github.com/tikv/client-go/v2/internal/logutil"
 >>>>>>> 7683491695d090758b4274eccd76d6c975704324
+	pd "github.com/tikv/pd/client"
 	"go.etcd.io/etcd/api/v3/mvccpb"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"go.uber.org/zap"
@@ -65,6 +68,7 @@ const (
 	gcCPUTimeInaccuracyBound       = time.Second
 	gcSafePointUpdateInterval      = time.Second * 10
 	gcSafePointQuickRepeatInterval = time.Second
+	etcdProbeTimeout               = time.Second
 )
 
 // SafePointKV is used for a seamingless integration for mockTest and runtime.
@@ -75,6 +79,18 @@ type SafePointKV interface {
 	Close() error
 }
 
+// casSafePointKV is implemented by the SafePointKVs which can update a key atomically.
+type casSafePointKV interface {
+	// CompareAndSwap sets the key to newValue if its value is oldValue, an empty
+	// oldValue means the key doesn't exist. It returns whether the key is set.
+	CompareAndSwap(k string, oldValue string, newValue string) (bool, error)
+}
+
+// deleteSafePointKV is implemented by the SafePointKVs which can delete a key.
+type deleteSafePointKV interface {
+	Delete(k string) error
+}
+
 // MockSafePointKV implements SafePointKV at mock test
 type MockSafePointKV struct {
 	store    map[string]string
@@ -117,6 +133,25 @@ func (w *MockSafePointKV) GetWithPrefix(prefix string) ([]*mvccpb.KeyValue, erro
 	return kvs, nil
 }
 
+// CompareAndSwap sets the key to newValue if its value is oldValue.
+func (w *MockSafePointKV) CompareAndSwap(k string, oldValue string, newValue string) (bool, error) {
+	w.mockLock.Lock()
+	defer w.mockLock.Unlock()
+	if w.store[k] != oldValue {
+		return false, nil
+	}
+	w.store[k] = newValue
+	return true, nil
+}
+
+// Delete deletes the key.
+func (w *MockSafePointKV) Delete(k string) error {
+	w.mockLock.Lock()
+	defer w.mockLock.Unlock()
+	delete(w.store, k)
+	return nil
+}
+
 // Close implements the Close method for SafePointKV
 func (w *MockSafePointKV) Close() error {
 	return nil
@@ -169,11 +204,187 @@ func (w *EtcdSafePointKV) GetWithPrefix(k string) ([]*mvccpb.KeyValue, error) {
 	return resp.Kvs, nil
 }
 
+// CompareAndSwap sets the key to newValue if its value is oldValue, an empty
+// oldValue means the key doesn't exist.
+func (w *EtcdSafePointKV) CompareAndSwap(k string, oldValue string, newValue string) (bool, error) {
+	cmp := clientv3.Compare(clientv3.Value(k), "=", oldValue)
+	if oldValue == "" {
+		cmp = clientv3.Compare(clientv3.CreateRevision(k), "=", 0)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	resp, err := w.cli.Txn(ctx).If(cmp).Then(clientv3.OpPut(k, newValue)).Commit()
+	cancel()
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return resp.Succeeded, nil
+}
+
+// Delete deletes the key.
+func (w *EtcdSafePointKV) Delete(k string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	_, err := w.cli.Delete(ctx, k)
+	cancel()
+	return errors.WithStack(err)
+}
+
+// probe reads the GcSavedSafePoint with a short timeout to check the access to etcd.
+func (w *EtcdSafePointKV) probe() error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdProbeTimeout)
+	_, err := w.cli.Get(ctx, GcSavedSafePoint)
+	cancel()
+	return errors.WithStack(err)
+}
+
 // Close implements the Close for SafePointKV
 func (w *EtcdSafePointKV) Close() error {
 	return errors.WithStack(w.cli.Close())
 }
 
+// PDSafePointKV implements SafePointKV with the GC safepoint APIs of PD, so it
+// doesn't need a separate etcd connection to the PD endpoints. The
+// GcSavedSafePoint is read from PD and is read-only, the GC safepoint of PD is
+// only updated by KVStore.GC after the locks are resolved. The other keys, like
+// the GC leader and the delete range records, are kept in memory and not shared
+// with the other processes, so GCWorker and DeleteRangeLater refuse to work on
+// a PDSafePointKV.
+type PDSafePointKV struct {
+	pdClient pd.Client
+	mu       sync.RWMutex
+	store    map[string]string
+}
+
+// NewPDSafePointKV creates an instance of PDSafePointKV. The pd client is not
+// closed by the PDSafePointKV.
+func NewPDSafePointKV(pdClient pd.Client) *PDSafePointKV {
+	return &PDSafePointKV{
+		pdClient: pdClient,
+		store:    make(map[string]string),
+	}
+}
+
+// Put implements the Put method for SafePointKV. The GcSavedSafePoint can't be
+// put, otherwise the GC safepoint of PD would pass the locks not resolved yet.
+func (w *PDSafePointKV) Put(k string, v string) error {
+	if k == GcSavedSafePoint {
+		return errors.Errorf("put %s is not supported by PD, it's updated by KVStore.GC", k)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.store[k] = v
+	return nil
+}
+
+// Get implements the Get method for SafePointKV
+func (w *PDSafePointKV) Get(k string) (string, error) {
+	if k != GcSavedSafePoint {
+		w.mu.RLock()
+		defer w.mu.RUnlock()
+		return w.store[k], nil
+	}
+	safePoint, err := w.loadGCSafePoint()
+	if err != nil || safePoint == 0 {
+		return "", err
+	}
+	return strconv.FormatUint(safePoint, 10), nil
+}
+
+// GetWithPrefix implements the GetWithPrefix for SafePointKV
+func (w *PDSafePointKV) GetWithPrefix(prefix string) ([]*mvccpb.KeyValue, error) {
+	w.mu.RLock()
+	kvs := make([]*mvccpb.KeyValue, 0, len(w.store)+1)
+	for k, v := range w.store {
+		if strings.HasPrefix(k, prefix) {
+			kvs = append(kvs, &mvccpb.KeyValue{Key: []byte(k), Value: []byte(v)})
+		}
+	}
+	w.mu.RUnlock()
+	if strings.HasPrefix(GcSavedSafePoint, prefix) {
+		v, err := w.Get(GcSavedSafePoint)
+		if err != nil {
+			return nil, err
+		}
+		if v != "" {
+			kvs = append(kvs, &mvccpb.KeyValue{Key: []byte(GcSavedSafePoint), Value: []byte(v)})
+		}
+	}
+	return kvs, nil
+}
+
+// CompareAndSwap sets the key to newValue if its value is oldValue. It's not
+// supported for the GcSavedSafePoint.
+func (w *PDSafePointKV) CompareAndSwap(k string, oldValue string, newValue string) (bool, error) {
+	if k == GcSavedSafePoint {
+		return false, errors.Errorf("compare and swap %s is not supported by PD", k)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.store[k] != oldValue {
+		return false, nil
+	}
+	w.store[k] = newValue
+	return true, nil
+}
+
+// Delete deletes the key. It's not supported for the GcSavedSafePoint.
+func (w *PDSafePointKV) Delete(k string) error {
+	if k == GcSavedSafePoint {
+		return errors.Errorf("delete %s is not supported by PD", k)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.store, k)
+	return nil
+}
+
+// Close implements the Close for SafePointKV
+func (w *PDSafePointKV) Close() error {
+	return nil
+}
+
+// loadGCSafePoint returns the GC safepoint of PD. The pd client has no API to read
+// it, but PD only saves a safepoint greater than the current one and always
+// returns the current one, so updating it to 0 reads it without changing it.
+func (w *PDSafePointKV) loadGCSafePoint() (uint64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	safePoint, err := w.pdClient.UpdateGCSafePoint(ctx, 0)
+	cancel()
+	return safePoint, errors.WithStack(err)
+}
+
+// NewSafePointKV creates an EtcdSafePointKV connected to the PD endpoints, or a
+// PDSafePointKV if the etcd access is disabled by the config or unavailable. The
+// fallback is logged, and GCWorker and DeleteRangeLater return an error on it
+// since it can't share their state with the other processes.
+func NewSafePointKV(pdClient pd.Client, addrs []string, tlsConfig *tls.Config) (SafePointKV, error) {
+	if config.GetGlobalConfig().PDClient.DisableEtcdSafePointKV {
+		return NewPDSafePointKV(pdClient), nil
+	}
+	spkv, err := NewEtcdSafePointKV(addrs, tlsConfig)
+	if err == nil {
+		// The etcd client connects in the background, so read the key once to
+		// find out whether the endpoints are reachable.
+		if err = spkv.probe(); err != nil {
+			spkv.Close()
+		}
+	}
+	if err != nil {
+		logutil.BgLogger().Warn("failed to access etcd, use PD for the safepoint instead",
+			zap.Strings("addrs", addrs), zap.Error(err))
+		return NewPDSafePointKV(pdClient), nil
+	}
+	return spkv, nil
+}
+
+// checkSharedSafePointKV returns an error if the keys other than the
+// GcSavedSafePoint are not shared with the other processes by the SafePointKV.
+func checkSharedSafePointKV(spkv SafePointKV) error {
+	if _, ok := spkv.(*PDSafePointKV); ok {
+		return errors.New("the GC state can't be shared by PDSafePointKV, enable the etcd access to the PD endpoints")
+	}
+	return nil
+}
+
 func saveSafePoint(kv SafePointKV, t uint64) error {
 	s := strconv.FormatUint(t, 10)
 	err := kv.Put(GcSavedSafePoint, s)
@@ -0,0 +1,165 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/JK1Zhang/client-go/v3/internal/logutil"
+	"github.com/JK1Zhang/client-go/v3/kv"
+	"github.com/JK1Zhang/client-go/v3/oracle"
+	"github.com/JK1Zhang/client-go/v3/txnkv/txnlock"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// LockStatus is a lock returned by ScanLocks with the status of the transaction
+// owning it.
+type LockStatus struct {
+	*txnlock.Lock
+	// State is the state of the transaction.
+	State GCTxnState
+	// CommitTS is the commit ts if the transaction is committed.
+	CommitTS uint64
+	// PrimaryTTL is the TTL of the primary lock if the primary key is locked.
+	PrimaryTTL uint64
+	// Expired tells whether the TTL of the lock itself has passed.
+	Expired bool
+}
+
+// IsOwnerDead returns whether the transaction owning the lock has finished or
+// expired, so the lock can be resolved without waiting. A transaction without
+// the primary lock may be still prewriting, so it's dead only if the lock is
+// expired.
+func (l *LockStatus) IsOwnerDead() bool {
+	switch l.State {
+	case GCTxnAlive:
+		return false
+	case GCTxnNotFound:
+		return l.Expired
+	}
+	return true
+}
+
+// ScanLocks returns at most limit locks in [startKey, endKey) whose start ts is
+// not greater than maxTS, in the order of the keys. An empty endKey means the
+// range is unbounded, and a non-positive limit means no limit.
+// The transactions owning the locks are checked like LockResolver.GetTxnStatus,
+// except that they are never rolled back, so ScanLocks doesn't change anything.
+func (s *KVStore) ScanLocks(ctx context.Context, startKey, endKey []byte, maxTS uint64, limit int) ([]*LockStatus, error) {
+	var res []*LockStatus
+	key := startKey
+	bo := NewGcResolveLockMaxBackoffer(ctx)
+	for limit <= 0 || len(res) < limit {
+		locks, loc, err := s.scanLocksInRegionWithStartKey(bo, key, maxTS, gcScanLockLimit)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range locks {
+			if len(endKey) > 0 && bytes.Compare(l.Key, endKey) >= 0 {
+				break
+			}
+			res = append(res, &LockStatus{
+				Lock:    l,
+				Expired: s.GetOracle().IsExpired(l.TxnID, l.TTL, &oracle.Option{TxnScope: oracle.GlobalTxnScope}),
+			})
+			if limit > 0 && len(res) >= limit {
+				break
+			}
+		}
+		if len(locks) < gcScanLockLimit {
+			key = loc.EndKey
+		} else {
+			key = kv.NextKey(locks[len(locks)-1].Key)
+		}
+		if len(key) == 0 || (len(endKey) > 0 && bytes.Compare(key, endKey) >= 0) {
+			break
+		}
+	}
+
+	txns := make(map[uint64]*LockStatus)
+	for _, l := range res {
+		if txn, ok := txns[l.TxnID]; ok {
+			l.State, l.CommitTS, l.PrimaryTTL = txn.State, txn.CommitTS, txn.PrimaryTTL
+			continue
+		}
+		state, status, err := s.peekTxnState(bo, l.TxnID, l.Primary)
+		if err != nil {
+			return nil, err
+		}
+		l.State, l.CommitTS, l.PrimaryTTL = state, status.CommitTS(), status.TTL()
+		txns[l.TxnID] = l
+	}
+	return res, nil
+}
+
+// ResolveDeadLocks resolves the locks returned by ScanLocks whose owners are dead,
+// see LockStatus.IsOwnerDead. The expired transactions are rolled back. It
+// returns the number of the resolved locks, which is also set with an error.
+// The locks of the transactions renewed after ScanLocks are left alone, and an
+// error is returned for them after the other locks are resolved.
+func (s *KVStore) ResolveDeadLocks(ctx context.Context, locks []*LockStatus) (int, error) {
+	// The locks are resolved per transaction, so an alive transaction doesn't
+	// fail the others.
+	var txnIDs []uint64
+	dead := make(map[uint64][]*txnlock.Lock)
+	for _, l := range locks {
+		if !l.IsOwnerDead() {
+			continue
+		}
+		if _, ok := dead[l.TxnID]; !ok {
+			txnIDs = append(txnIDs, l.TxnID)
+		}
+		dead[l.TxnID] = append(dead[l.TxnID], l.Lock)
+	}
+
+	bo := NewGcResolveLockMaxBackoffer(ctx)
+	var resolved int
+	alive := &aliveLocksError{}
+	for _, txnID := range txnIDs {
+		msBeforeExpired, err := s.GetLockResolver().ResolveLocks(bo, 0, dead[txnID])
+		if err != nil {
+			return resolved, err
+		}
+		if msBeforeExpired > 0 {
+			alive.locks += len(dead[txnID])
+			if msBeforeExpired > alive.msBeforeExpired {
+				alive.msBeforeExpired = msBeforeExpired
+			}
+			continue
+		}
+		resolved += len(dead[txnID])
+	}
+	if resolved > 0 {
+		logutil.Logger(ctx).Info("resolved locks of dead transactions", zap.Int("locks", resolved))
+	}
+	if alive.locks > 0 {
+		return resolved, errors.WithStack(alive)
+	}
+	return resolved, nil
+}
+
+// aliveLocksError is returned by ResolveDeadLocks for the locks of the
+// transactions renewed after ScanLocks.
+type aliveLocksError struct {
+	locks           int
+	msBeforeExpired int64
+}
+
+func (e *aliveLocksError) Error() string {
+	return fmt.Sprintf("%d locks of alive transactions are left, retry after %dms", e.locks, e.msBeforeExpired)
+}
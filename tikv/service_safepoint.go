@@ -0,0 +1,198 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	tikverr "github.com/JK1Zhang/client-go/v3/error"
+	"github.com/JK1Zhang/client-go/v3/internal/logutil"
+	"github.com/JK1Zhang/client-go/v3/oracle"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// gcWorkerServiceSafePointID is the service safepoint registered by GC, which
+// keeps the safepoints of other services from falling behind it.
+const gcWorkerServiceSafePointID = "gc_worker"
+
+// gcPlanServiceSafePointID is used by GCPlan to read the minimal service
+// safepoint, it's never registered.
+const gcPlanServiceSafePointID = "gc_plan"
+
+// serviceSafePoint is a service safepoint registered by the store.
+type serviceSafePoint struct {
+	ts  uint64
+	ttl time.Duration
+	// renewedAt is when the service safepoint is registered or renewed successfully
+	// last time. It's protected by the mutex of serviceSafePoints.
+	renewedAt time.Time
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+type serviceSafePoints struct {
+	sync.Mutex
+	m map[string]*serviceSafePoint
+}
+
+// RegisterServiceSafePoint registers the service safepoint of serviceID at ts in
+// PD, which keeps GC from deleting the data of the snapshots at or after ts. The
+// registration is renewed in the background every ttl/3 until it's unregistered
+// or the store is closed, then it expires in ttl.
+// Registering the same serviceID again replaces the previous registration.
+// It returns ErrGCTooEarly if GC may have advanced past ts.
+func (s *KVStore) RegisterServiceSafePoint(ctx context.Context, serviceID string, ts uint64, ttl time.Duration) error {
+	ttlSeconds := int64(math.Ceil(ttl.Seconds()))
+	if ttlSeconds <= 0 {
+		ttlSeconds = 1
+	}
+	s.stopServiceSafePoint(serviceID)
+
+	registeredAt := time.Now()
+	minSafePoint, err := s.pdClient.UpdateServiceGCSafePoint(ctx, serviceID, ttlSeconds, ts)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if minSafePoint > ts {
+		return errors.WithStack(&tikverr.ErrGCTooEarly{
+			TxnStartTS:  oracle.GetTimeFromTS(ts),
+			GCSafePoint: oracle.GetTimeFromTS(minSafePoint),
+		})
+	}
+	s.spMutex.RLock()
+	cachedSafePoint := s.safePoint
+	s.spMutex.RUnlock()
+	if ts < cachedSafePoint {
+		if _, err = s.pdClient.UpdateServiceGCSafePoint(ctx, serviceID, 0, ts); err != nil {
+			logutil.Logger(ctx).Warn("failed to remove service safepoint",
+				zap.String("serviceID", serviceID), zap.Error(err))
+		}
+		return errors.WithStack(&tikverr.ErrGCTooEarly{
+			TxnStartTS:  oracle.GetTimeFromTS(ts),
+			GCSafePoint: oracle.GetTimeFromTS(cachedSafePoint),
+		})
+	}
+
+	renewCtx, cancel := context.WithCancel(s.ctx)
+	ssp := &serviceSafePoint{
+		ts:        ts,
+		ttl:       time.Duration(ttlSeconds) * time.Second,
+		renewedAt: registeredAt,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	s.serviceSafePoints.Lock()
+	if s.serviceSafePoints.m == nil {
+		s.serviceSafePoints.m = make(map[string]*serviceSafePoint)
+	}
+	s.serviceSafePoints.m[serviceID] = ssp
+	s.serviceSafePoints.Unlock()
+
+	s.wg.Add(1)
+	go s.renewServiceSafePoint(renewCtx, serviceID, ssp)
+	return nil
+}
+
+// UnregisterServiceSafePoint stops renewing the service safepoint of serviceID and
+// removes it from PD.
+func (s *KVStore) UnregisterServiceSafePoint(ctx context.Context, serviceID string) error {
+	ssp := s.stopServiceSafePoint(serviceID)
+	if ssp == nil {
+		return nil
+	}
+	_, err := s.pdClient.UpdateServiceGCSafePoint(ctx, serviceID, 0, ssp.ts)
+	return errors.WithStack(err)
+}
+
+// stopServiceSafePoint stops renewing the service safepoint of serviceID and
+// returns it, or nil if it's not registered.
+func (s *KVStore) stopServiceSafePoint(serviceID string) *serviceSafePoint {
+	s.serviceSafePoints.Lock()
+	ssp := s.serviceSafePoints.m[serviceID]
+	delete(s.serviceSafePoints.m, serviceID)
+	s.serviceSafePoints.Unlock()
+	if ssp != nil {
+		ssp.cancel()
+		<-ssp.done
+	}
+	return ssp
+}
+
+func (s *KVStore) renewServiceSafePoint(ctx context.Context, serviceID string, ssp *serviceSafePoint) {
+	defer s.wg.Done()
+	defer close(ssp.done)
+	ticker := time.NewTicker(ssp.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			renewedAt := time.Now()
+			if _, err := s.pdClient.UpdateServiceGCSafePoint(ctx, serviceID, int64(ssp.ttl/time.Second), ssp.ts); err != nil {
+				logutil.Logger(ctx).Warn("failed to renew service safepoint",
+					zap.String("serviceID", serviceID), zap.Error(err))
+				continue
+			}
+			s.serviceSafePoints.Lock()
+			ssp.renewedAt = renewedAt
+			s.serviceSafePoints.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// isProtectedByServiceSafePoint returns whether the data at ts is protected from
+// GC by a service safepoint registered by the store.
+func (s *KVStore) isProtectedByServiceSafePoint(ts uint64) bool {
+	s.serviceSafePoints.Lock()
+	defer s.serviceSafePoints.Unlock()
+	for _, ssp := range s.serviceSafePoints.m {
+		if ts >= ssp.ts && time.Since(ssp.renewedAt) < ssp.ttl {
+			return true
+		}
+	}
+	return false
+}
+
+// adjustSafePointByServiceSafePoints registers the service safepoint of the GC
+// worker at safePoint, and returns the minimal service safepoint if it's smaller
+// than safePoint. The service safepoint of the GC worker never expires, so the
+// service safepoints registered later can't be smaller than it. PD keeps a
+// "gc_worker" service safepoint at 0 if nothing moves it, so it must be moved
+// forward here, otherwise the minimum would always be 0.
+func (s *KVStore) adjustSafePointByServiceSafePoints(ctx context.Context, safePoint uint64) (uint64, error) {
+	minServiceSafePoint, err := s.pdClient.UpdateServiceGCSafePoint(ctx, gcWorkerServiceSafePointID, math.MaxInt64, safePoint)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	if minServiceSafePoint < safePoint {
+		logutil.Logger(ctx).Info("[gc worker] safepoint is blocked by a service safepoint",
+			zap.Uint64("safePoint", safePoint),
+			zap.Uint64("minServiceSafePoint", minServiceSafePoint))
+		return minServiceSafePoint, nil
+	}
+	return safePoint, nil
+}
+
+// loadMinServiceSafePoint reads the minimal service safepoint in PD. Removing a
+// service safepoint which doesn't exist reads it without changing anything.
+func (s *KVStore) loadMinServiceSafePoint(ctx context.Context) (uint64, error) {
+	minServiceSafePoint, err := s.pdClient.UpdateServiceGCSafePoint(ctx, gcPlanServiceSafePointID, 0, 0)
+	return minServiceSafePoint, errors.WithStack(err)
+}
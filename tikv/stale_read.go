@@ -0,0 +1,201 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+
+	"github.com/JK1Zhang/client-go/v3/internal/retry"
+	"github.com/JK1Zhang/client-go/v3/oracle"
+	"github.com/JK1Zhang/client-go/v3/txnkv/transaction"
+	"github.com/JK1Zhang/client-go/v3/txnkv/txnsnapshot"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pkg/errors"
+)
+
+// staleReadMode indicates how the read timestamp of a stale read transaction is chosen.
+type staleReadMode int
+
+const (
+	staleReadExactTS staleReadMode = iota
+	staleReadExactStaleness
+	staleReadBoundedStaleness
+	staleReadMaxStaleness
+	staleReadMaxSafeTS
+)
+
+// staleReadOptions indicates the option when beginning a stale read transaction.
+// staleReadOptions are set by the StaleReadOption values passed to BeginStaleRead.
+type staleReadOptions struct {
+	mode        staleReadMode
+	readTS      uint64
+	minReadTS   uint64
+	maxReadTS   uint64
+	prevSecond  uint64
+	txnScope    string
+	matchLabels []*metapb.StoreLabel
+}
+
+// StaleReadOption configures a stale read transaction.
+type StaleReadOption func(*staleReadOptions)
+
+// WithStaleReadTS reads the data at exactly the given timestamp.
+func WithStaleReadTS(ts uint64) StaleReadOption {
+	return func(o *staleReadOptions) {
+		o.mode = staleReadExactTS
+		o.readTS = ts
+	}
+}
+
+// WithExactStaleness reads the data as of prevSecond seconds ago.
+func WithExactStaleness(prevSecond uint64) StaleReadOption {
+	return func(o *staleReadOptions) {
+		o.mode = staleReadExactStaleness
+		o.prevSecond = prevSecond
+	}
+}
+
+// WithBoundedStaleness reads the freshest data that can be served by all replicas
+// in the txn scope, bounded by [minReadTS, maxReadTS].
+// If the replicas are not ready for minReadTS, the read falls back to the leader.
+func WithBoundedStaleness(minReadTS, maxReadTS uint64) StaleReadOption {
+	return func(o *staleReadOptions) {
+		o.mode = staleReadBoundedStaleness
+		o.minReadTS = minReadTS
+		o.maxReadTS = maxReadTS
+	}
+}
+
+// WithMaxStaleness reads the freshest data that can be served by all replicas
+// in the txn scope, but never data that is older than prevSecond seconds.
+// A zero prevSecond allows no staleness, the data is read at the current timestamp.
+func WithMaxStaleness(prevSecond uint64) StaleReadOption {
+	return func(o *staleReadOptions) {
+		o.mode = staleReadMaxStaleness
+		o.prevSecond = prevSecond
+	}
+}
+
+// WithMaxSafeTS reads the data at the max timestamp that can be served by all
+// replicas in the txn scope, which is the minimal safeTS of the stores.
+func WithMaxSafeTS() StaleReadOption {
+	return func(o *staleReadOptions) {
+		o.mode = staleReadMaxSafeTS
+	}
+}
+
+// WithStaleReadScope sets the TxnScope (DC) of the stale read transaction.
+func WithStaleReadScope(txnScope string) StaleReadOption {
+	return func(o *staleReadOptions) {
+		o.txnScope = txnScope
+	}
+}
+
+// WithStaleReadMatchLabels prefers the replicas on the stores matching the labels,
+// which is usually used to read from the nearest replica.
+func WithStaleReadMatchLabels(labels []*metapb.StoreLabel) StaleReadOption {
+	return func(o *staleReadOptions) {
+		o.matchLabels = labels
+	}
+}
+
+// BeginStaleRead begins a read only transaction that reads stale data from the nearest replica.
+// The read timestamp is decided by the options, it reads at the max safeTS if no timestamp option is given.
+// The requests are sent to the replicas with stale read flag, and fall back to the leader
+// if the replica's data is not ready. Committing writes in the transaction returns ErrWriteInStaleReadTxn.
+func (s *KVStore) BeginStaleRead(ctx context.Context, opts ...StaleReadOption) (*transaction.KVTxn, error) {
+	options := &staleReadOptions{mode: staleReadMaxSafeTS}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.txnScope == "" {
+		options.txnScope = oracle.GlobalTxnScope
+	}
+
+	readTS, err := s.resolveStaleReadTS(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := txnsnapshot.NewTiKVSnapshot(s, readTS, s.nextReplicaReadSeed())
+	snapshot.SetIsStatenessReadOnly(true)
+	snapshot.SetReadReplicaScope(options.txnScope)
+	if len(options.matchLabels) > 0 {
+		snapshot.SetMatchStoreLabels(options.matchLabels)
+	}
+	return transaction.NewTiKVTxn(s, snapshot, readTS, options.txnScope)
+}
+
+func (s *KVStore) resolveStaleReadTS(ctx context.Context, options *staleReadOptions) (uint64, error) {
+	bo := retry.NewBackofferWithVars(ctx, transaction.TsoMaxBackoff, nil)
+	currentTS, err := s.getTimestampWithRetry(bo, options.txnScope)
+	if err != nil {
+		return 0, err
+	}
+
+	switch options.mode {
+	case staleReadExactTS:
+		if options.readTS > currentTS {
+			return 0, errors.Errorf("cannot set stale read timestamp %d to a future time, current ts %d", options.readTS, currentTS)
+		}
+		return options.readTS, nil
+	case staleReadExactStaleness:
+		return s.getStaleTimestamp(ctx, options.txnScope, options.prevSecond)
+	case staleReadBoundedStaleness, staleReadMaxStaleness:
+		minReadTS, maxReadTS := options.minReadTS, options.maxReadTS
+		if options.mode == staleReadMaxStaleness {
+			minReadTS, maxReadTS = currentTS, currentTS
+			if options.prevSecond > 0 {
+				minReadTS, err = s.getStaleTimestamp(ctx, options.txnScope, options.prevSecond)
+				if err != nil {
+					return 0, err
+				}
+			}
+		}
+		if minReadTS > maxReadTS {
+			return 0, errors.Errorf("invalid bounded staleness range [%d, %d]", minReadTS, maxReadTS)
+		}
+		if maxReadTS > currentTS {
+			maxReadTS = currentTS
+		}
+		readTS := s.GetMinSafeTS(options.txnScope)
+		if readTS < minReadTS {
+			readTS = minReadTS
+		}
+		if readTS > maxReadTS {
+			readTS = maxReadTS
+		}
+		return readTS, nil
+	default:
+		readTS := s.GetMinSafeTS(options.txnScope)
+		if readTS == 0 {
+			return 0, errors.Errorf("safeTS of txnScope %s is not available", options.txnScope)
+		}
+		if readTS > currentTS {
+			readTS = currentTS
+		}
+		return readTS, nil
+	}
+}
+
+// getStaleTimestamp returns the timestamp prevSecond seconds ago.
+// The oracle fetches a fresh timestamp on failure, so it is retried once.
+func (s *KVStore) getStaleTimestamp(ctx context.Context, txnScope string, prevSecond uint64) (uint64, error) {
+	ts, err := s.oracle.GetStaleTimestamp(ctx, txnScope, prevSecond)
+	if err != nil {
+		ts, err = s.oracle.GetStaleTimestamp(ctx, txnScope, prevSecond)
+	}
+	return ts, err
+}
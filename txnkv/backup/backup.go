@@ -0,0 +1,226 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package backup takes consistent snapshot backups of key ranges to local
+// files and restores them.
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/JK1Zhang/client-go/v3/internal/logutil"
+	"github.com/JK1Zhang/client-go/v3/kv"
+	"github.com/JK1Zhang/client-go/v3/oracle"
+	"github.com/JK1Zhang/client-go/v3/tikv"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	defaultScanConcurrency = 8
+	defaultWriteWorkers    = 4
+	defaultChunkKVs        = 65536
+	defaultChunkBytes      = 16 * 1024 * 1024
+	defaultSafePointTTL    = 5 * time.Minute
+)
+
+type backupOptions struct {
+	snapshotTS      uint64
+	scanConcurrency int
+	writeWorkers    int
+	chunkKVs        int
+	chunkBytes      int
+	serviceID       string
+	safePointTTL    time.Duration
+}
+
+// Option configures Backup.
+type Option func(*backupOptions)
+
+// WithSnapshotTS sets the ts of the snapshot to back up. By default the current ts is used.
+func WithSnapshotTS(ts uint64) Option {
+	return func(o *backupOptions) {
+		o.snapshotTS = ts
+	}
+}
+
+// WithScanConcurrency sets the number of regions scanned concurrently.
+func WithScanConcurrency(n int) Option {
+	return func(o *backupOptions) {
+		o.scanConcurrency = n
+	}
+}
+
+// WithWriteWorkers sets the number of chunk files compressed and written concurrently.
+func WithWriteWorkers(n int) Option {
+	return func(o *backupOptions) {
+		o.writeWorkers = n
+	}
+}
+
+// WithChunkSize sets the max number of key-value pairs and the max bytes of keys
+// and values in a chunk file.
+func WithChunkSize(kvs, bytes int) Option {
+	return func(o *backupOptions) {
+		o.chunkKVs = kvs
+		o.chunkBytes = bytes
+	}
+}
+
+// WithServiceSafePoint sets the service id and the ttl of the service safepoint
+// registered in PD during the backup.
+func WithServiceSafePoint(serviceID string, ttl time.Duration) Option {
+	return func(o *backupOptions) {
+		o.serviceID = serviceID
+		o.safePointTTL = ttl
+	}
+}
+
+// Backup writes the key-value pairs in [startKey, endKey) of a snapshot to dir.
+// An empty endKey means the range is unbounded.
+//
+// A service safepoint at the snapshot ts is kept in PD during the backup, so the
+// GC can't remove the versions being read. The range is scanned region by region
+// concurrently, the pairs are split into sorted chunks, and each chunk is written
+// to a compressed file with its checksum recorded in the manifest. The manifest is
+// written last, so a backup without a manifest is incomplete.
+func Backup(ctx context.Context, store *tikv.KVStore, dir string, startKey, endKey []byte, opts ...Option) (*Manifest, error) {
+	options := backupOptions{
+		scanConcurrency: defaultScanConcurrency,
+		writeWorkers:    defaultWriteWorkers,
+		chunkKVs:        defaultChunkKVs,
+		chunkBytes:      defaultChunkBytes,
+		safePointTTL:    defaultSafePointTTL,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.writeWorkers <= 0 {
+		options.writeWorkers = defaultWriteWorkers
+	}
+	if options.chunkKVs <= 0 {
+		options.chunkKVs = defaultChunkKVs
+	}
+	if options.chunkBytes <= 0 {
+		options.chunkBytes = defaultChunkBytes
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ManifestName)); err == nil {
+		return nil, errors.Errorf("backup already exists in %s", dir)
+	}
+
+	ts := options.snapshotTS
+	if ts == 0 {
+		var err error
+		ts, err = store.CurrentTimestamp(oracle.GlobalTxnScope)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if options.serviceID == "" {
+		options.serviceID = fmt.Sprintf("backup-%d", ts)
+	}
+	err := store.RegisterServiceSafePoint(ctx, options.serviceID, ts, options.safePointTTL)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := store.UnregisterServiceSafePoint(context.Background(), options.serviceID); err != nil {
+			logutil.Logger(ctx).Warn("failed to remove backup service safepoint",
+				zap.String("serviceID", options.serviceID), zap.Error(err))
+		}
+	}()
+
+	logutil.Logger(ctx).Info("backup start",
+		zap.String("dir", dir),
+		zap.String("startKey", kv.StrKey(startKey)),
+		zap.String("endKey", kv.StrKey(endKey)),
+		zap.Uint64("snapshotTS", ts))
+
+	m := &Manifest{
+		Version:    ManifestVersion,
+		StartKey:   startKey,
+		EndKey:     endKey,
+		SnapshotTS: ts,
+	}
+	var mu sync.Mutex
+	eg, egCtx := errgroup.WithContext(ctx)
+	chunkCh := make(chan *chunk, options.writeWorkers)
+	for i := 0; i < options.writeWorkers; i++ {
+		eg.Go(func() error {
+			for c := range chunkCh {
+				f, err := writeChunk(dir, c)
+				if err != nil {
+					return err
+				}
+				mu.Lock()
+				m.Chunks = append(m.Chunks, f)
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+
+	cur := &chunk{}
+	send := func() error {
+		select {
+		case chunkCh <- cur:
+		case <-egCtx.Done():
+			return egCtx.Err()
+		}
+		cur = &chunk{seq: cur.seq + 1}
+		return nil
+	}
+	snapshot := store.GetSnapshot(ts)
+	scanErr := snapshot.ParallelScan(egCtx, startKey, endKey, options.scanConcurrency, func(key, value []byte) error {
+		cur.add(key, value)
+		m.TotalKVs++
+		m.TotalBytes += int64(len(key) + len(value))
+		if len(cur.keys) >= options.chunkKVs || cur.bytes >= options.chunkBytes {
+			return send()
+		}
+		return nil
+	})
+	if scanErr == nil && len(cur.keys) > 0 {
+		scanErr = send()
+	}
+	close(chunkCh)
+	if err = eg.Wait(); err != nil {
+		return nil, err
+	}
+	if scanErr != nil {
+		return nil, scanErr
+	}
+
+	sort.Slice(m.Chunks, func(i, j int) bool { return m.Chunks[i].Name < m.Chunks[j].Name })
+	if err = writeManifest(dir, m); err != nil {
+		return nil, err
+	}
+	logutil.Logger(ctx).Info("backup finished",
+		zap.String("dir", dir),
+		zap.Uint64("snapshotTS", ts),
+		zap.Int("chunks", len(m.Chunks)),
+		zap.Int64("kvs", m.TotalKVs))
+	return m, nil
+}
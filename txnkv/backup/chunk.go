@@ -0,0 +1,202 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// ManifestName is the name of the manifest file in the backup directory.
+	ManifestName = "backupmeta.json"
+	// ManifestVersion is the version of the manifest written by this package.
+	ManifestVersion = 1
+
+	chunkFileFormat = "chunk_%06d.kv.gz"
+)
+
+// Manifest describes a backup. It's stored as JSON in the backup directory.
+type Manifest struct {
+	Version int `json:"version"`
+	// StartKey and EndKey are the range of the backup, an empty EndKey means unbounded.
+	StartKey []byte `json:"start_key"`
+	EndKey   []byte `json:"end_key"`
+	// SnapshotTS is the ts of the snapshot that the backup is read from.
+	SnapshotTS uint64 `json:"snapshot_ts"`
+	// Chunks are sorted by key, and the keys of different chunks don't overlap.
+	Chunks     []ChunkFile `json:"chunks"`
+	TotalKVs   int64       `json:"total_kvs"`
+	TotalBytes int64       `json:"total_bytes"`
+}
+
+// ChunkFile describes a chunk file of a backup. A chunk file is a gzip compressed
+// sequence of key-value pairs sorted by key, each pair is encoded as the uvarint
+// length of the key, the key, the uvarint length of the value and the value.
+type ChunkFile struct {
+	Name     string `json:"name"`
+	FirstKey []byte `json:"first_key"`
+	LastKey  []byte `json:"last_key"`
+	// KVs is the number of key-value pairs in the chunk.
+	KVs int `json:"kvs"`
+	// Bytes is the total size of the keys and values before compression.
+	Bytes int `json:"bytes"`
+	// Size is the size of the file.
+	Size int64 `json:"size"`
+	// Checksum is the hex encoded sha256 of the file.
+	Checksum string `json:"checksum"`
+}
+
+type chunk struct {
+	seq    int
+	keys   [][]byte
+	values [][]byte
+	bytes  int
+}
+
+func (c *chunk) add(key, value []byte) {
+	c.keys = append(c.keys, append([]byte{}, key...))
+	c.values = append(c.values, append([]byte{}, value...))
+	c.bytes += len(key) + len(value)
+}
+
+// writeChunk writes the chunk to a file in dir.
+func writeChunk(dir string, c *chunk) (ChunkFile, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	var lenBuf [binary.MaxVarintLen64]byte
+	for i, key := range c.keys {
+		for _, b := range [][]byte{key, c.values[i]} {
+			n := binary.PutUvarint(lenBuf[:], uint64(len(b)))
+			if _, err := zw.Write(lenBuf[:n]); err != nil {
+				return ChunkFile{}, errors.WithStack(err)
+			}
+			if _, err := zw.Write(b); err != nil {
+				return ChunkFile{}, errors.WithStack(err)
+			}
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return ChunkFile{}, errors.WithStack(err)
+	}
+	data := buf.Bytes()
+	checksum := sha256.Sum256(data)
+	f := ChunkFile{
+		Name:     fmt.Sprintf(chunkFileFormat, c.seq),
+		FirstKey: c.keys[0],
+		LastKey:  c.keys[len(c.keys)-1],
+		KVs:      len(c.keys),
+		Bytes:    c.bytes,
+		Size:     int64(len(data)),
+		Checksum: hex.EncodeToString(checksum[:]),
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, f.Name), data, 0644); err != nil {
+		return ChunkFile{}, errors.WithStack(err)
+	}
+	return f, nil
+}
+
+// readChunk reads the chunk file and calls fn for each key-value pair in order.
+// The checksum of the file is verified before any pair is handed to fn.
+func readChunk(dir string, f ChunkFile, fn func(key, value []byte) error) error {
+	data, err := ioutil.ReadFile(filepath.Join(dir, f.Name))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	checksum := sha256.Sum256(data)
+	if int64(len(data)) != f.Size || hex.EncodeToString(checksum[:]) != f.Checksum {
+		return errors.Errorf("checksum mismatch of chunk file %s", f.Name)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	r := bufio.NewReader(zr)
+	readBytes := func() ([]byte, error) {
+		l, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, l)
+		_, err = io.ReadFull(r, b)
+		return b, err
+	}
+	var prev []byte
+	for n := 0; ; n++ {
+		key, err := readBytes()
+		if err == io.EOF {
+			if n != f.KVs {
+				return errors.Errorf("chunk file %s has %d kvs, expect %d", f.Name, n, f.KVs)
+			}
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "corrupted chunk file %s", f.Name)
+		}
+		value, err := readBytes()
+		if err != nil {
+			return errors.Wrapf(err, "corrupted chunk file %s", f.Name)
+		}
+		if prev != nil && bytes.Compare(prev, key) >= 0 {
+			return errors.Errorf("keys of chunk file %s are not sorted", f.Name)
+		}
+		prev = key
+		if err = fn(key, value); err != nil {
+			return err
+		}
+	}
+}
+
+// ReadManifest reads the manifest of the backup in dir.
+func ReadManifest(dir string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, ManifestName))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	m := &Manifest{}
+	if err = json.Unmarshal(data, m); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if m.Version != ManifestVersion {
+		return nil, errors.Errorf("unsupported backup manifest version %d", m.Version)
+	}
+	return m, nil
+}
+
+// writeManifest writes the manifest to dir. It writes a temporary file first and
+// renames it, so a manifest exists only if the backup is complete.
+func writeManifest(dir string, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	tmp := filepath.Join(dir, ManifestName+".tmp")
+	if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(tmp, filepath.Join(dir, ManifestName)))
+}
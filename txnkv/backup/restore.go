@@ -0,0 +1,100 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+
+	"github.com/JK1Zhang/client-go/v3/internal/logutil"
+	"github.com/JK1Zhang/client-go/v3/rawkv"
+	"github.com/JK1Zhang/client-go/v3/tikv"
+	"github.com/JK1Zhang/client-go/v3/txnkv"
+	"go.uber.org/zap"
+)
+
+const defaultRawBatchSize = 256
+
+// Restore writes the backup in dir back to the store through transactions.
+// The pairs are committed by a txnkv.BulkWriter configured by opts, so the
+// restore as a whole is not atomic. The checksum of each chunk file is verified
+// before it's written.
+func Restore(ctx context.Context, store *tikv.KVStore, dir string, opts ...txnkv.BulkWriterOption) (*Manifest, error) {
+	m, err := ReadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	w := txnkv.NewBulkWriter(store, opts...)
+	for _, f := range m.Chunks {
+		err = readChunk(dir, f, func(key, value []byte) error {
+			return w.Set(ctx, key, value)
+		})
+		if err != nil {
+			w.Close(ctx)
+			return nil, err
+		}
+	}
+	if err = w.Close(ctx); err != nil {
+		return nil, err
+	}
+	logutil.Logger(ctx).Info("restore finished",
+		zap.String("dir", dir),
+		zap.Uint64("snapshotTS", m.SnapshotTS),
+		zap.Int64("kvs", m.TotalKVs))
+	return m, nil
+}
+
+// RestoreRaw writes the backup in dir to the raw key space through
+// rawkv.Client.BatchPut, batchSize pairs a time.
+// The checksum of each chunk file is verified before it's written.
+func RestoreRaw(ctx context.Context, client *rawkv.Client, dir string, batchSize int, options ...rawkv.RawOption) (*Manifest, error) {
+	if batchSize <= 0 {
+		batchSize = defaultRawBatchSize
+	}
+	m, err := ReadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([][]byte, 0, batchSize)
+	values := make([][]byte, 0, batchSize)
+	flush := func() error {
+		if len(keys) == 0 {
+			return nil
+		}
+		err := client.BatchPut(ctx, keys, values, options...)
+		keys, values = keys[:0], values[:0]
+		return err
+	}
+	for _, f := range m.Chunks {
+		err = readChunk(dir, f, func(key, value []byte) error {
+			keys = append(keys, key)
+			values = append(values, value)
+			if len(keys) >= batchSize {
+				return flush()
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err = flush(); err != nil {
+		return nil, err
+	}
+	logutil.Logger(ctx).Info("raw restore finished",
+		zap.String("dir", dir),
+		zap.Uint64("snapshotTS", m.SnapshotTS),
+		zap.Int64("kvs", m.TotalKVs))
+	return m, nil
+}
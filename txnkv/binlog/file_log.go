@@ -0,0 +1,328 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package binlog implements a transaction.BinlogExecutor that writes the
+// mutations of transactions to a rotating log in a local directory, and a
+// reader of the log.
+package binlog
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/JK1Zhang/client-go/v3/internal/logutil"
+	"github.com/JK1Zhang/client-go/v3/txnkv/transaction"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	segmentFormat         = "binlog-%08d.log"
+	defaultMaxSegmentSize = 64 * 1024 * 1024
+)
+
+type fileLogOptions struct {
+	maxSegmentSize int64
+	maxSegments    int
+	sync           bool
+}
+
+// Option configures a FileLog.
+type Option func(*fileLogOptions)
+
+// WithMaxSegmentSize sets the size of a log file, a new file is created once
+// the current file reaches the size.
+func WithMaxSegmentSize(size int64) Option {
+	return func(o *fileLogOptions) {
+		o.maxSegmentSize = size
+	}
+}
+
+// WithMaxSegments sets the max number of log files kept, the oldest files are
+// removed after rotation. 0 means all files are kept. A file holding the prewrite
+// record of a transaction not finished yet is never removed, neither are the
+// files after it.
+func WithMaxSegments(n int) Option {
+	return func(o *fileLogOptions) {
+		o.maxSegments = n
+	}
+}
+
+// WithSync makes the FileLog sync the file after each write.
+func WithSync(sync bool) Option {
+	return func(o *fileLogOptions) {
+		o.sync = sync
+	}
+}
+
+// FileLog is a rotating log of transactions in a local directory. It's safe to
+// be shared by concurrent transactions.
+type FileLog struct {
+	dir  string
+	opts fileLogOptions
+
+	mu struct {
+		sync.Mutex
+		file   *os.File
+		seq    int
+		size   int64
+		closed bool
+		// pending is the file of the prewrite record of each unfinished transaction.
+		pending map[uint64]int
+	}
+}
+
+// OpenFileLog opens the log in dir. A new log file is always created, so the
+// records are never appended after an incomplete record written before a crash.
+// The transactions prewritten but not finished in the existing files are left
+// by a crashed process, they are rolled back in the new file so that they don't
+// hold back ReadCommitted forever. Their commit results are undetermined, which
+// are treated as rolled back like ReadCommitted does.
+func OpenFileLog(dir string, opts ...Option) (*FileLog, error) {
+	options := fileLogOptions{maxSegmentSize: defaultMaxSegmentSize}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.maxSegmentSize <= 0 {
+		options.maxSegmentSize = defaultMaxSegmentSize
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	segments, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	orphans, err := pendingPrewrites(dir, segments)
+	if err != nil {
+		return nil, err
+	}
+	l := &FileLog{dir: dir, opts: options}
+	l.mu.pending = make(map[uint64]int)
+	seq := 0
+	if len(segments) > 0 {
+		seq = segments[len(segments)-1] + 1
+	}
+	if err = l.openSegment(seq); err != nil {
+		return nil, err
+	}
+	for _, startTS := range orphans {
+		if err = l.write(&record{tp: recordRollback, startTS: startTS}); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	if len(orphans) > 0 {
+		logutil.BgLogger().Warn("rolled back unfinished transactions in binlog",
+			zap.String("dir", dir),
+			zap.Int("count", len(orphans)))
+	}
+	return l, nil
+}
+
+// pendingPrewrites returns the start ts of the transactions prewritten but not
+// finished in the log files, in order.
+func pendingPrewrites(dir string, segments []int) ([]uint64, error) {
+	pending := make(map[uint64]struct{})
+	for _, seq := range segments {
+		err := readSegment(filepath.Join(dir, fmt.Sprintf(segmentFormat, seq)), func(r *record) {
+			if r.tp == recordPrewrite {
+				pending[r.startTS] = struct{}{}
+			} else {
+				delete(pending, r.startTS)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	startTSs := make([]uint64, 0, len(pending))
+	for startTS := range pending {
+		startTSs = append(startTSs, startTS)
+	}
+	sort.Slice(startTSs, func(i, j int) bool { return startTSs[i] < startTSs[j] })
+	return startTSs, nil
+}
+
+// Attach makes the transaction write its binlog to the log. It must be called
+// before the transaction commits.
+func (l *FileLog) Attach(txn *transaction.KVTxn) {
+	e := &fileExecutor{log: l, startTS: txn.StartTS()}
+	txn.AddPrePrewriteHook(func(ctx context.Context, startTS uint64, mutations transaction.CommitterMutations) error {
+		e.mutations = e.mutations[:0]
+		for i := 0; i < mutations.Len(); i++ {
+			op := mutations.GetOp(i)
+			switch op {
+			case kvrpcpb.Op_Put, kvrpcpb.Op_Insert:
+				op = kvrpcpb.Op_Put
+			case kvrpcpb.Op_Del:
+			default:
+				continue
+			}
+			e.mutations = append(e.mutations, Mutation{Op: op, Key: mutations.GetKey(i), Value: mutations.GetValue(i)})
+		}
+		return nil
+	})
+	txn.SetBinlogExecutor(e)
+}
+
+// Close closes the log.
+func (l *FileLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.mu.closed {
+		return nil
+	}
+	l.mu.closed = true
+	return errors.WithStack(l.mu.file.Close())
+}
+
+func (l *FileLog) write(r *record) error {
+	data := r.encode()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.mu.closed {
+		return errors.New("binlog file log is closed")
+	}
+	if l.mu.size > 0 && l.mu.size+int64(len(data)) > l.opts.maxSegmentSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.mu.file.Write(data)
+	l.mu.size += int64(n)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if r.tp == recordPrewrite {
+		l.mu.pending[r.startTS] = l.mu.seq
+	} else {
+		delete(l.mu.pending, r.startTS)
+	}
+	if l.opts.sync {
+		return errors.WithStack(l.mu.file.Sync())
+	}
+	return nil
+}
+
+func (l *FileLog) rotate() error {
+	if err := l.mu.file.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := l.openSegment(l.mu.seq + 1); err != nil {
+		return err
+	}
+	if l.opts.maxSegments <= 0 {
+		return nil
+	}
+	segments, err := listSegments(l.dir)
+	if err != nil {
+		return err
+	}
+	// Keep the file of the oldest unfinished transaction, otherwise its commit
+	// record would be written without the prewrite record.
+	minPending := l.mu.seq
+	for _, seq := range l.mu.pending {
+		if seq < minPending {
+			minPending = seq
+		}
+	}
+	for len(segments) > l.opts.maxSegments && segments[0] < minPending {
+		if err = os.Remove(filepath.Join(l.dir, fmt.Sprintf(segmentFormat, segments[0]))); err != nil {
+			return errors.WithStack(err)
+		}
+		segments = segments[1:]
+	}
+	return nil
+}
+
+func (l *FileLog) openSegment(seq int) error {
+	f, err := os.OpenFile(filepath.Join(l.dir, fmt.Sprintf(segmentFormat, seq)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	l.mu.file, l.mu.seq, l.mu.size = f, seq, 0
+	return nil
+}
+
+// listSegments returns the sequence numbers of the log files in dir in order.
+func listSegments(dir string) ([]int, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var segments []int
+	for _, f := range files {
+		var seq int
+		if _, err := fmt.Sscanf(f.Name(), segmentFormat, &seq); err == nil && f.Name() == fmt.Sprintf(segmentFormat, seq) {
+			segments = append(segments, seq)
+		}
+	}
+	sort.Ints(segments)
+	return segments, nil
+}
+
+// fileExecutor is the transaction.BinlogExecutor of a transaction attached to a FileLog.
+type fileExecutor struct {
+	log       *FileLog
+	startTS   uint64
+	mutations []Mutation
+}
+
+type writeResult struct {
+	skipped bool
+	err     error
+}
+
+func (r writeResult) Skipped() bool   { return r.skipped }
+func (r writeResult) GetError() error { return r.err }
+
+// Prewrite writes the prewrite record. It's skipped if the transaction doesn't
+// write any key.
+func (e *fileExecutor) Prewrite(ctx context.Context, primary []byte) <-chan transaction.BinlogWriteResult {
+	ch := make(chan transaction.BinlogWriteResult, 1)
+	if len(e.mutations) == 0 {
+		ch <- writeResult{skipped: true}
+		return ch
+	}
+	err := e.log.write(&record{tp: recordPrewrite, startTS: e.startTS, mutations: e.mutations})
+	ch <- writeResult{err: err}
+	return ch
+}
+
+// Commit writes the commit record, or the rollback record if commitTS is 0.
+func (e *fileExecutor) Commit(ctx context.Context, commitTS int64) {
+	r := &record{tp: recordCommit, startTS: e.startTS, commitTS: uint64(commitTS)}
+	if commitTS == 0 {
+		r.tp = recordRollback
+	}
+	if err := e.log.write(r); err != nil {
+		logutil.Logger(ctx).Error("failed to write binlog commit record",
+			zap.Uint64("startTS", e.startTS),
+			zap.Int64("commitTS", commitTS),
+			zap.Error(err))
+	}
+	e.mutations = nil
+}
+
+// Skip is called if the prewrite is skipped, nothing is written to the log.
+func (e *fileExecutor) Skip() {
+	e.mutations = nil
+}
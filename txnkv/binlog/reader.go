@@ -0,0 +1,103 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package binlog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Txn is a committed transaction read from the log.
+type Txn struct {
+	StartTS   uint64
+	CommitTS  uint64
+	Mutations []Mutation
+}
+
+// ReadCommitted reads the log in dir and returns the committed transactions
+// whose commit ts is greater than afterTS, sorted by commit ts.
+//
+// A transaction that is prewritten but not committed yet may get a commit ts
+// smaller than the transactions already committed. To keep the result in order
+// across calls, only the transactions committed before the smallest start ts of
+// the pending transactions are returned, the later ones are returned by a later
+// call once the pending transactions finish. So a consumer can read the log
+// repeatedly with afterTS set to the commit ts of the last transaction it got.
+//
+// The transactions whose commit fails are treated as rolled back, including the
+// ones whose commit result is undetermined. The transactions left pending by a
+// crashed process are rolled back once the log is opened again by OpenFileLog.
+func ReadCommitted(dir string, afterTS uint64) ([]*Txn, error) {
+	segments, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	prewrites := make(map[uint64]*record)
+	var committed []*Txn
+	for _, seq := range segments {
+		err = readSegment(filepath.Join(dir, fmt.Sprintf(segmentFormat, seq)), func(r *record) {
+			switch r.tp {
+			case recordPrewrite:
+				prewrites[r.startTS] = r
+			case recordCommit:
+				if p, ok := prewrites[r.startTS]; ok {
+					delete(prewrites, r.startTS)
+					if r.commitTS > afterTS {
+						committed = append(committed, &Txn{StartTS: r.startTS, CommitTS: r.commitTS, Mutations: p.mutations})
+					}
+				}
+			case recordRollback:
+				delete(prewrites, r.startTS)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(committed, func(i, j int) bool { return committed[i].CommitTS < committed[j].CommitTS })
+	for startTS := range prewrites {
+		i := sort.Search(len(committed), func(i int) bool { return committed[i].CommitTS > startTS })
+		committed = committed[:i]
+	}
+	return committed, nil
+}
+
+// readSegment calls fn for each record in the log file. An incomplete record at
+// the end of the file is ignored.
+func readSegment(path string, fn func(r *record)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for {
+		rec, err := readRecord(r)
+		if err == io.EOF || err == errTornRecord {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to read binlog file %s", path)
+		}
+		fn(rec)
+	}
+}
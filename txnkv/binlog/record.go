@@ -0,0 +1,170 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package binlog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pkg/errors"
+)
+
+type recordType byte
+
+const (
+	recordPrewrite recordType = iota + 1
+	recordCommit
+	recordRollback
+)
+
+// recordHeaderSize is the size of the crc32 and the length of the payload.
+const recordHeaderSize = 8
+
+// Mutation is a write of a key in a transaction.
+type Mutation struct {
+	// Op is kvrpcpb.Op_Put or kvrpcpb.Op_Del.
+	Op    kvrpcpb.Op
+	Key   []byte
+	Value []byte
+}
+
+// record is an entry of the log. A transaction has a prewrite record followed by
+// a commit or a rollback record.
+type record struct {
+	tp        recordType
+	startTS   uint64
+	commitTS  uint64
+	mutations []Mutation
+}
+
+// encode encodes the record with a header of the crc32 and the length of the payload.
+func (r *record) encode() []byte {
+	buf := make([]byte, recordHeaderSize, 64)
+	buf = append(buf, byte(r.tp))
+	buf = appendUint64(buf, r.startTS)
+	switch r.tp {
+	case recordCommit:
+		buf = appendUint64(buf, r.commitTS)
+	case recordPrewrite:
+		buf = appendUvarint(buf, uint64(len(r.mutations)))
+		for _, m := range r.mutations {
+			buf = append(buf, byte(m.Op))
+			buf = appendUvarint(buf, uint64(len(m.Key)))
+			buf = append(buf, m.Key...)
+			buf = appendUvarint(buf, uint64(len(m.Value)))
+			buf = append(buf, m.Value...)
+		}
+	}
+	payload := buf[recordHeaderSize:]
+	binary.LittleEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(payload))
+	binary.LittleEndian.PutUint32(buf[4:8], uint32(len(payload)))
+	return buf
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	return append(buf, b[:n]...)
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	return append(buf, b[:]...)
+}
+
+// errTornRecord means the log ends in the middle of a record, which happens if
+// the process crashes when writing the record.
+var errTornRecord = errors.New("torn record")
+
+// readRecord reads a record. It returns io.EOF at the end of the log and
+// errTornRecord if the last record is incomplete.
+func readRecord(r *bufio.Reader) (*record, error) {
+	var header [recordHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errTornRecord
+		}
+		return nil, err
+	}
+	payload := make([]byte, binary.LittleEndian.Uint32(header[4:8]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, errTornRecord
+		}
+		return nil, errors.WithStack(err)
+	}
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[0:4]) {
+		return nil, errors.New("checksum mismatch")
+	}
+	return decodePayload(payload)
+}
+
+func decodePayload(payload []byte) (*record, error) {
+	errCorrupted := errors.New("corrupted record")
+	if len(payload) < 9 {
+		return nil, errCorrupted
+	}
+	r := &record{tp: recordType(payload[0]), startTS: binary.BigEndian.Uint64(payload[1:9])}
+	payload = payload[9:]
+	switch r.tp {
+	case recordCommit:
+		if len(payload) < 8 {
+			return nil, errCorrupted
+		}
+		r.commitTS = binary.BigEndian.Uint64(payload)
+	case recordRollback:
+	case recordPrewrite:
+		readBytes := func() ([]byte, bool) {
+			l, n := binary.Uvarint(payload)
+			if n <= 0 || uint64(len(payload)-n) < l {
+				return nil, false
+			}
+			b := payload[n : n+int(l)]
+			payload = payload[n+int(l):]
+			return b, true
+		}
+		cnt, n := binary.Uvarint(payload)
+		if n <= 0 {
+			return nil, errCorrupted
+		}
+		payload = payload[n:]
+		if cnt > uint64(len(payload)) {
+			return nil, errCorrupted
+		}
+		r.mutations = make([]Mutation, 0, cnt)
+		for i := uint64(0); i < cnt; i++ {
+			if len(payload) == 0 {
+				return nil, errCorrupted
+			}
+			m := Mutation{Op: kvrpcpb.Op(payload[0])}
+			payload = payload[1:]
+			var ok bool
+			if m.Key, ok = readBytes(); !ok {
+				return nil, errCorrupted
+			}
+			if m.Value, ok = readBytes(); !ok {
+				return nil, errCorrupted
+			}
+			r.mutations = append(r.mutations, m)
+		}
+	default:
+		return nil, errors.Errorf("unknown record type %d", r.tp)
+	}
+	return r, nil
+}
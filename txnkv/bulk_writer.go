@@ -0,0 +1,393 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package txnkv
+
+import (
+	"context"
+	"sync"
+
+	tikverr "github.com/JK1Zhang/client-go/v3/error"
+	"github.com/JK1Zhang/client-go/v3/internal/logutil"
+	"github.com/JK1Zhang/client-go/v3/internal/retry"
+	"github.com/JK1Zhang/client-go/v3/tikv"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultBulkMaxKeys     = 4096
+	defaultBulkMaxBytes    = 4 * 1024 * 1024
+	defaultBulkConcurrency = 4
+	defaultBulkMaxRetries  = 10
+	bulkCommitMaxBackoff   = 60000
+)
+
+// BulkChunk describes a chunk of mutations committed by a BulkWriter in one transaction.
+type BulkChunk struct {
+	// Seq is the sequence number of the chunk, starting from 0.
+	Seq uint64
+	// Offset is the position of the first mutation of the chunk in the stream.
+	Offset uint64
+	// Count is the number of mutations in the chunk.
+	Count int
+	// Bytes is the total size of the keys and values in the chunk.
+	Bytes int
+	// FirstKey and LastKey are the first and the last key written in the chunk.
+	FirstKey []byte
+	LastKey  []byte
+	// CommitTS is the commit ts of the transaction.
+	CommitTS uint64
+}
+
+// BulkCheckpoint is the progress of a BulkWriter. All mutations before Offset are
+// committed, so a crashed load can resume by skipping the first Offset mutations
+// of the stream.
+type BulkCheckpoint struct {
+	// Offset is the number of the leading mutations of the stream that are committed.
+	Offset uint64
+	// Chunks is the number of the leading chunks that are committed.
+	Chunks uint64
+	// LastKey is the last key of the last committed chunk counted by Chunks.
+	LastKey []byte
+}
+
+// BulkCommitCallback is called after a chunk is committed, with the checkpoint
+// updated by the chunk. The calls are serialized, and the callback must not call
+// the methods of the BulkWriter.
+type BulkCommitCallback func(chunk BulkChunk, checkpoint BulkCheckpoint)
+
+type bulkWriterOptions struct {
+	maxKeys     int
+	maxBytes    int
+	concurrency int
+	maxRetries  int
+	startOffset uint64
+	onCommitted BulkCommitCallback
+}
+
+// BulkWriterOption configures a BulkWriter.
+type BulkWriterOption func(*bulkWriterOptions)
+
+// WithBulkMaxKeys sets the max number of mutations committed in one transaction.
+func WithBulkMaxKeys(n int) BulkWriterOption {
+	return func(o *bulkWriterOptions) {
+		o.maxKeys = n
+	}
+}
+
+// WithBulkMaxBytes sets the max total size of keys and values committed in one transaction.
+// A chunk is committed once its size reaches the limit, so a chunk is at most one
+// mutation larger than the limit.
+func WithBulkMaxBytes(n int) BulkWriterOption {
+	return func(o *bulkWriterOptions) {
+		o.maxBytes = n
+	}
+}
+
+// WithBulkConcurrency sets the max number of transactions committed concurrently.
+func WithBulkConcurrency(n int) BulkWriterOption {
+	return func(o *bulkWriterOptions) {
+		o.concurrency = n
+	}
+}
+
+// WithBulkMaxRetries sets how many times a chunk is retried in a new transaction
+// when it conflicts with other transactions.
+func WithBulkMaxRetries(n int) BulkWriterOption {
+	return func(o *bulkWriterOptions) {
+		o.maxRetries = n
+	}
+}
+
+// WithBulkStartOffset sets the offset of the first mutation written to the BulkWriter.
+// It's used to resume a load from a checkpoint, so that the offsets reported by
+// the new BulkWriter are positions in the original stream.
+func WithBulkStartOffset(offset uint64) BulkWriterOption {
+	return func(o *bulkWriterOptions) {
+		o.startOffset = offset
+	}
+}
+
+// WithBulkCommitCallback sets the callback called after each chunk is committed.
+func WithBulkCommitCallback(cb BulkCommitCallback) BulkWriterOption {
+	return func(o *bulkWriterOptions) {
+		o.onCommitted = cb
+	}
+}
+
+type bulkChunk struct {
+	BulkChunk
+	keys [][]byte
+	// values[i] is nil if keys[i] is deleted.
+	values [][]byte
+}
+
+// BulkWriter writes an unbounded stream of mutations to TiKV. The mutations are
+// split into chunks bounded by the number of keys and bytes, and each chunk is
+// committed in its own transaction, so the stream as a whole is NOT atomic.
+// Chunks are committed concurrently, so if a key is written in more than one
+// chunk, which write wins is undefined unless the concurrency is 1.
+// BulkWriter is not thread safe, the writes should be called from one goroutine.
+type BulkWriter struct {
+	store *tikv.KVStore
+	opts  bulkWriterOptions
+	// ctx is used to commit the chunks in the background, so that the chunks
+	// already submitted are not canceled with the ctx of a single write.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	cur     *bulkChunk
+	nextSeq uint64
+	offset  uint64
+	closed  bool
+	tokens  chan struct{}
+	wg      sync.WaitGroup
+
+	mu struct {
+		sync.Mutex
+		err        error
+		committed  map[uint64]*bulkChunk
+		checkpoint BulkCheckpoint
+	}
+}
+
+// NewBulkWriter creates a BulkWriter which commits the mutations to the store.
+func NewBulkWriter(store *tikv.KVStore, opts ...BulkWriterOption) *BulkWriter {
+	options := bulkWriterOptions{
+		maxKeys:     defaultBulkMaxKeys,
+		maxBytes:    defaultBulkMaxBytes,
+		concurrency: defaultBulkConcurrency,
+		maxRetries:  defaultBulkMaxRetries,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.maxKeys <= 0 {
+		options.maxKeys = defaultBulkMaxKeys
+	}
+	if options.maxBytes <= 0 {
+		options.maxBytes = defaultBulkMaxBytes
+	}
+	if options.concurrency <= 0 {
+		options.concurrency = defaultBulkConcurrency
+	}
+	if options.maxRetries < 0 {
+		options.maxRetries = 0
+	}
+	ctx, cancel := context.WithCancel(store.Ctx())
+	w := &BulkWriter{
+		store:  store,
+		opts:   options,
+		ctx:    ctx,
+		cancel: cancel,
+		offset: options.startOffset,
+		tokens: make(chan struct{}, options.concurrency),
+	}
+	w.mu.committed = make(map[uint64]*bulkChunk)
+	w.mu.checkpoint.Offset = options.startOffset
+	return w
+}
+
+// Set writes the value of the key. The key and value are copied, so the caller
+// can reuse them after Set returns.
+func (w *BulkWriter) Set(ctx context.Context, key, value []byte) error {
+	if len(value) == 0 {
+		return tikverr.ErrCannotSetNilValue
+	}
+	return w.add(ctx, key, append([]byte{}, value...))
+}
+
+// Delete deletes the key.
+func (w *BulkWriter) Delete(ctx context.Context, key []byte) error {
+	return w.add(ctx, key, nil)
+}
+
+func (w *BulkWriter) add(ctx context.Context, key, value []byte) error {
+	if w.closed {
+		return errors.New("bulk writer is closed")
+	}
+	if err := w.getErr(); err != nil {
+		return err
+	}
+	if w.cur == nil {
+		w.cur = &bulkChunk{BulkChunk: BulkChunk{Seq: w.nextSeq, Offset: w.offset}}
+		w.nextSeq++
+	}
+	key = append([]byte{}, key...)
+	c := w.cur
+	if c.Count == 0 {
+		c.FirstKey = key
+	}
+	c.LastKey = key
+	c.keys = append(c.keys, key)
+	c.values = append(c.values, value)
+	c.Count++
+	c.Bytes += len(key) + len(value)
+	w.offset++
+	if c.Count >= w.opts.maxKeys || c.Bytes >= w.opts.maxBytes {
+		return w.submit(ctx)
+	}
+	return nil
+}
+
+// submit commits the current chunk in the background. It blocks if there are
+// already too many chunks being committed, until ctx is done.
+func (w *BulkWriter) submit(ctx context.Context) error {
+	c := w.cur
+	if c == nil {
+		return nil
+	}
+	w.cur = nil
+	select {
+	case w.tokens <- struct{}{}:
+	case <-ctx.Done():
+		err := errors.WithStack(ctx.Err())
+		w.setErr(err)
+		return err
+	}
+	// Don't commit more chunks once a chunk fails.
+	if err := w.getErr(); err != nil {
+		<-w.tokens
+		return err
+	}
+	w.wg.Add(1)
+	go func() {
+		defer func() {
+			<-w.tokens
+			w.wg.Done()
+		}()
+		commitTS, err := w.commitChunk(w.ctx, c)
+		if err != nil {
+			logutil.Logger(w.ctx).Warn("bulk writer failed to commit chunk",
+				zap.Uint64("seq", c.Seq),
+				zap.Uint64("offset", c.Offset),
+				zap.Int("count", c.Count),
+				zap.Error(err))
+			w.setErr(err)
+			return
+		}
+		c.CommitTS = commitTS
+		w.onCommitted(c)
+	}()
+	return nil
+}
+
+func (w *BulkWriter) commitChunk(ctx context.Context, c *bulkChunk) (uint64, error) {
+	bo := retry.NewBackofferWithVars(ctx, bulkCommitMaxBackoff, nil)
+	for attempt := 0; ; attempt++ {
+		commitTS, err := w.commitChunkOnce(ctx, c)
+		if err == nil {
+			return commitTS, nil
+		}
+		if attempt >= w.opts.maxRetries || !isRetryableCommitErr(err) {
+			return 0, err
+		}
+		logutil.Logger(ctx).Info("bulk writer retries chunk",
+			zap.Uint64("seq", c.Seq),
+			zap.Int("attempt", attempt+1),
+			zap.Error(err))
+		if err = bo.Backoff(retry.BoTxnLock, err); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (w *BulkWriter) commitChunkOnce(ctx context.Context, c *bulkChunk) (uint64, error) {
+	txn, err := w.store.Begin()
+	if err != nil {
+		return 0, err
+	}
+	for i, key := range c.keys {
+		if c.values[i] == nil {
+			err = txn.Delete(key)
+		} else {
+			err = txn.Set(key, c.values[i])
+		}
+		if err != nil {
+			txn.Rollback()
+			return 0, err
+		}
+	}
+	if err = txn.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return txn.GetCommitTS(), nil
+}
+
+func isRetryableCommitErr(err error) bool {
+	category := tikverr.GetCategory(err)
+	return category == tikverr.CategoryConflict || category == tikverr.CategoryRetryable
+}
+
+func (w *BulkWriter) onCommitted(c *bulkChunk) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.mu.committed[c.Seq] = c
+	for {
+		next, ok := w.mu.committed[w.mu.checkpoint.Chunks]
+		if !ok {
+			break
+		}
+		delete(w.mu.committed, next.Seq)
+		w.mu.checkpoint.Chunks++
+		w.mu.checkpoint.Offset = next.Offset + uint64(next.Count)
+		w.mu.checkpoint.LastKey = next.LastKey
+	}
+	if w.opts.onCommitted != nil {
+		w.opts.onCommitted(c.BulkChunk, w.mu.checkpoint)
+	}
+}
+
+func (w *BulkWriter) getErr() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.mu.err
+}
+
+func (w *BulkWriter) setErr(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.mu.err == nil {
+		w.mu.err = err
+	}
+}
+
+// Checkpoint returns the current progress of the writer.
+func (w *BulkWriter) Checkpoint() BulkCheckpoint {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.mu.checkpoint
+}
+
+// Flush commits the buffered mutations and waits for all the chunks to be committed.
+// It returns the first error met when committing the chunks, once a chunk fails,
+// the writer refuses new mutations.
+func (w *BulkWriter) Flush(ctx context.Context) error {
+	if err := w.getErr(); err == nil && !w.closed {
+		if err = w.submit(ctx); err != nil {
+			return err
+		}
+	}
+	w.wg.Wait()
+	return w.getErr()
+}
+
+// Close flushes the writer and rejects later mutations.
+func (w *BulkWriter) Close(ctx context.Context) error {
+	err := w.Flush(ctx)
+	w.closed = true
+	w.cancel()
+	return err
+}
@@ -52,7 +52,7 @@ func NewClient(pdAddrs []string) (*Client, error) {
 		return nil, err
 	}
 
-	spkv, err := tikv.NewEtcdSafePointKV(pdAddrs, tlsConfig)
+	spkv, err := tikv.NewSafePointKV(pdClient, pdAddrs, tlsConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,121 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"context"
+
+	"github.com/JK1Zhang/client-go/v3/txnkv/transaction"
+	"github.com/JK1Zhang/client-go/v3/util/codec"
+	"github.com/pkg/errors"
+)
+
+// Entry is an entry of an index.
+type Entry struct {
+	// Value is the encoded index value, the components added by the IndexFunc.
+	Value []byte
+	// PrimaryKey is the encoded primary key of the row.
+	PrimaryKey []byte
+}
+
+// Lookup returns the entries of the index whose encoded value is in [lower, upper),
+// in the order of the index. lower and upper are built by a codec.KeyBuilder with
+// an empty prefix, an empty upper means there is no upper bound. At most limit
+// entries are returned if limit is positive.
+// The entries written by the transaction are visible.
+func (t *Table) Lookup(ctx context.Context, txn *transaction.KVTxn, name string, lower, upper []byte, limit int) ([]Entry, error) {
+	prefix, ok := t.idxPrefix[name]
+	if !ok {
+		return nil, errors.Errorf("index %s not found", name)
+	}
+	start := append(append([]byte{}, prefix...), lower...)
+	var end []byte
+	if len(upper) > 0 {
+		end = append(append([]byte{}, prefix...), upper...)
+	} else {
+		_, end = codec.PrefixRange(prefix)
+	}
+	it, err := txn.Iter(start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	var entries []Entry
+	for it.Valid() && (limit <= 0 || len(entries) < limit) {
+		key, pk := it.Key(), it.Value()
+		valueEnd := len(key)
+		if !t.isUnique(name) {
+			valueEnd -= len(pk)
+		}
+		entries = append(entries, Entry{
+			Value:      append([]byte{}, key[len(prefix):valueEnd]...),
+			PrimaryKey: append([]byte{}, pk...),
+		})
+		if err = it.Next(); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// LookupPrefix returns the entries of the index whose encoded value starts with
+// prefix. It's useful to find the rows by the leading components of the index value,
+// or by the whole value of a non-unique index.
+func (t *Table) LookupPrefix(ctx context.Context, txn *transaction.KVTxn, name string, prefix []byte, limit int) ([]Entry, error) {
+	// The upper bound is empty if prefix is empty or all 0xFF, which means the
+	// range is unbounded in the index.
+	lower, upper := codec.PrefixRange(prefix)
+	return t.Lookup(ctx, txn, name, lower, upper, limit)
+}
+
+// LookupUnique returns the primary key of the row whose value of the unique
+// index is value. It returns tikverr.ErrNotExist if there is no such row.
+func (t *Table) LookupUnique(ctx context.Context, txn *transaction.KVTxn, name string, value []byte) ([]byte, error) {
+	prefix, ok := t.idxPrefix[name]
+	if !ok {
+		return nil, errors.Errorf("index %s not found", name)
+	}
+	if !t.isUnique(name) {
+		return nil, errors.Errorf("index %s is not unique", name)
+	}
+	return txn.Get(ctx, append(append([]byte{}, prefix...), value...))
+}
+
+// GetRows returns the rows of the entries, keyed by the primary key. The entries
+// whose rows don't exist are skipped.
+func (t *Table) GetRows(ctx context.Context, txn *transaction.KVTxn, entries []Entry) (map[string][]byte, error) {
+	keys := make([][]byte, 0, len(entries))
+	for _, e := range entries {
+		keys = append(keys, t.RowKey(e.PrimaryKey))
+	}
+	values, err := txn.BatchGet(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	rows := make(map[string][]byte, len(values))
+	for key, value := range values {
+		rows[key[len(t.rowPrefix):]] = value
+	}
+	return rows, nil
+}
+
+func (t *Table) isUnique(name string) bool {
+	for _, idx := range t.indexes {
+		if idx.Name == name {
+			return idx.Unique
+		}
+	}
+	return false
+}
@@ -0,0 +1,263 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package index maintains the rows of a table and their secondary indexes in
+// transactions.
+//
+// The keys of a table are encoded by codec.KeyBuilder:
+//
+//   row:              prefix, "r", primary key                -> row
+//   unique index:     prefix, "i", index name, index value    -> primary key
+//   non-unique index: prefix, "i", index name, index value, primary key -> primary key
+package index
+
+import (
+	"bytes"
+	"context"
+
+	tikverr "github.com/JK1Zhang/client-go/v3/error"
+	"github.com/JK1Zhang/client-go/v3/kv"
+	"github.com/JK1Zhang/client-go/v3/txnkv/transaction"
+	"github.com/JK1Zhang/client-go/v3/util/codec"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pkg/errors"
+)
+
+// PrimaryKeyFunc adds the primary key components of the row to the builder.
+type PrimaryKeyFunc func(row []byte, b *codec.KeyBuilder) error
+
+// IndexFunc adds the index value components of the row to the builder. It
+// returns false if the row has no entry in the index, e.g. the indexed field is null.
+type IndexFunc func(row []byte, b *codec.KeyBuilder) (bool, error)
+
+// Index describes a secondary index of a table.
+type Index struct {
+	Name    string
+	Unique  bool
+	Extract IndexFunc
+}
+
+// Table is a table whose rows are stored with a primary key and maintained
+// with their secondary indexes. The rows are opaque bytes, their primary key
+// and index values are extracted by the functions of the table.
+type Table struct {
+	rowPrefix []byte
+	pk        PrimaryKeyFunc
+	indexes   []Index
+	idxPrefix map[string][]byte
+}
+
+// NewTable creates a Table whose keys start with prefix.
+func NewTable(prefix []byte, pk PrimaryKeyFunc, indexes ...Index) (*Table, error) {
+	t := &Table{
+		rowPrefix: codec.NewKeyBuilder(prefix).AddString("r").Key(),
+		pk:        pk,
+		indexes:   indexes,
+		idxPrefix: make(map[string][]byte, len(indexes)),
+	}
+	for _, idx := range indexes {
+		if idx.Name == "" || idx.Extract == nil {
+			return nil, errors.New("index must have a name and an extract function")
+		}
+		if _, ok := t.idxPrefix[idx.Name]; ok {
+			return nil, errors.Errorf("duplicated index %s", idx.Name)
+		}
+		t.idxPrefix[idx.Name] = codec.NewKeyBuilder(prefix).AddString("i").AddString(idx.Name).Key()
+	}
+	return t, nil
+}
+
+// PrimaryKey returns the encoded primary key of the row. It's the same as a key
+// built by a codec.KeyBuilder with an empty prefix and the primary key components.
+func (t *Table) PrimaryKey(row []byte) ([]byte, error) {
+	b := codec.NewKeyBuilder(nil)
+	if err := t.pk(row, b); err != nil {
+		return nil, err
+	}
+	return b.Key(), nil
+}
+
+// RowKey returns the key of the row with the encoded primary key.
+func (t *Table) RowKey(pk []byte) []byte {
+	return append(append([]byte{}, t.rowPrefix...), pk...)
+}
+
+// Get returns the row of the primary key. It returns tikverr.ErrNotExist if the row doesn't exist.
+func (t *Table) Get(ctx context.Context, txn *transaction.KVTxn, pk []byte) ([]byte, error) {
+	return txn.Get(ctx, t.RowKey(pk))
+}
+
+// Insert inserts the row. If a row with the same primary key exists, or the row
+// conflicts with another row on a unique index, the commit fails with tikverr.ErrKeyExist.
+func (t *Table) Insert(ctx context.Context, txn *transaction.KVTxn, row []byte) error {
+	pk, err := t.PrimaryKey(row)
+	if err != nil {
+		return err
+	}
+	if err = t.setPresumeNotExists(txn, t.RowKey(pk), row); err != nil {
+		return err
+	}
+	return t.addIndexes(txn, pk, row, nil)
+}
+
+// Put inserts the row, or replaces the row with the same primary key. The
+// index entries of the replaced row are updated.
+func (t *Table) Put(ctx context.Context, txn *transaction.KVTxn, row []byte) error {
+	pk, err := t.PrimaryKey(row)
+	if err != nil {
+		return err
+	}
+	oldRow, err := t.Get(ctx, txn, pk)
+	if err != nil && !tikverr.IsErrNotFound(err) {
+		return err
+	}
+	if err != nil {
+		return t.Insert(ctx, txn, row)
+	}
+	return t.replace(txn, pk, oldRow, row)
+}
+
+// Update replaces the row with the same primary key. It returns
+// tikverr.ErrNotExist if the row doesn't exist.
+func (t *Table) Update(ctx context.Context, txn *transaction.KVTxn, row []byte) error {
+	pk, err := t.PrimaryKey(row)
+	if err != nil {
+		return err
+	}
+	oldRow, err := t.Get(ctx, txn, pk)
+	if err != nil {
+		return err
+	}
+	return t.replace(txn, pk, oldRow, row)
+}
+
+// Delete deletes the row of the primary key and its index entries. It's a
+// no-op if the row doesn't exist.
+func (t *Table) Delete(ctx context.Context, txn *transaction.KVTxn, pk []byte) error {
+	oldRow, err := t.Get(ctx, txn, pk)
+	if tikverr.IsErrNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	keys, err := t.indexKeys(pk, oldRow)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err = txn.Delete(key); err != nil {
+			return err
+		}
+	}
+	return txn.Delete(t.RowKey(pk))
+}
+
+func (t *Table) replace(txn *transaction.KVTxn, pk, oldRow, row []byte) error {
+	oldKeys, err := t.indexKeys(pk, oldRow)
+	if err != nil {
+		return err
+	}
+	newKeys, err := t.indexKeys(pk, row)
+	if err != nil {
+		return err
+	}
+	// The entries which are not changed are neither deleted nor rewritten, so an
+	// unchanged unique index value is not checked against itself.
+	for _, key := range oldKeys {
+		if !containsKey(newKeys, key) {
+			if err = txn.Delete(key); err != nil {
+				return err
+			}
+		}
+	}
+	if err = txn.Set(t.RowKey(pk), row); err != nil {
+		return err
+	}
+	return t.addIndexes(txn, pk, row, oldKeys)
+}
+
+// addIndexes writes the index entries of the row, except the ones in skip.
+func (t *Table) addIndexes(txn *transaction.KVTxn, pk, row []byte, skip [][]byte) error {
+	for _, idx := range t.indexes {
+		key, ok, err := t.indexKey(idx, pk, row)
+		if err != nil {
+			return err
+		}
+		if !ok || containsKey(skip, key) {
+			continue
+		}
+		if idx.Unique {
+			err = t.setPresumeNotExists(txn, key, pk)
+		} else {
+			err = txn.Set(key, pk)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setPresumeNotExists writes the key which must not exist. The existence is checked
+// when the key is prewritten, unless the key is deleted earlier in the transaction.
+func (t *Table) setPresumeNotExists(txn *transaction.KVTxn, key, value []byte) error {
+	v, err := txn.GetMemBuffer().Get(key)
+	if err == nil {
+		if len(v) > 0 {
+			return errors.WithStack(&tikverr.ErrKeyExist{AlreadyExist: &kvrpcpb.AlreadyExist{Key: key}})
+		}
+		return txn.Set(key, value)
+	}
+	if !tikverr.IsErrNotFound(err) {
+		return err
+	}
+	return txn.GetMemBuffer().SetWithFlags(key, value, kv.SetPresumeKeyNotExists)
+}
+
+func (t *Table) indexKeys(pk, row []byte) ([][]byte, error) {
+	keys := make([][]byte, 0, len(t.indexes))
+	for _, idx := range t.indexes {
+		key, ok, err := t.indexKey(idx, pk, row)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (t *Table) indexKey(idx Index, pk, row []byte) ([]byte, bool, error) {
+	b := codec.NewKeyBuilder(t.idxPrefix[idx.Name])
+	ok, err := idx.Extract(row, b)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	key := b.Key()
+	if !idx.Unique {
+		key = append(key, pk...)
+	}
+	return key, true, nil
+}
+
+func containsKey(keys [][]byte, key []byte) bool {
+	for _, k := range keys {
+		if bytes.Equal(k, key) {
+			return true
+		}
+	}
+	return false
+}
@@ -15,6 +15,8 @@
 package txnkv
 
 import (
+	"time"
+
 <<<<<<< HEAD
 	"github.com/JK1Zhang/client-go/v3/txnkv/txnlock"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
@@ -33,6 +35,15 @@ type LockResolver = txnlock.LockResolver
 // TxnStatus represents a txn's final status. It should be Lock or Commit or Rollback.
 type TxnStatus = txnlock.TxnStatus
 
+// ResolvedCache caches the statuses of the resolved transactions.
+type ResolvedCache = txnlock.ResolvedCache
+
+// NewLRUResolvedCache creates a ResolvedCache which evicts the least recently
+// used transaction when it's full.
+func NewLRUResolvedCache(capacity int, ttl time.Duration) *txnlock.LRUResolvedCache {
+	return txnlock.NewLRUResolvedCache(capacity, ttl)
+}
+
 // NewLock creates a new *Lock.
 func NewLock(l *kvrpcpb.LockInfo) *Lock {
 	return txnlock.NewLock(l)
@@ -0,0 +1,209 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"context"
+	"time"
+
+	"github.com/JK1Zhang/client-go/v3/kv"
+	"github.com/JK1Zhang/client-go/v3/txnkv/transaction"
+	"github.com/JK1Zhang/client-go/v3/util/codec"
+)
+
+// Dequeue leases at most max messages to the caller until the visibility timeout
+// passes. The messages whose leases expired are delivered again before the ready
+// messages. Every message is locked by a pessimistic lock without waiting before
+// it's taken, and the messages locked by other consumers are skipped, so a message
+// is never delivered to two consumers at the same time.
+// The messages delivered too many times are moved to the dead letters instead.
+// Nothing is dequeued if max is not positive.
+func (q *Queue) Dequeue(ctx context.Context, max int) ([]*Message, error) {
+	if max <= 0 {
+		return nil, nil
+	}
+	txn, err := q.beginPessimistic()
+	if err != nil {
+		return nil, err
+	}
+	now := q.opts.clock.Now()
+	deadline := now.Add(q.opts.visibilityTimeout)
+	var (
+		msgs    []*Message
+		changed bool
+	)
+	take := func(key, id []byte) (bool, error) {
+		value, err := q.lockKey(ctx, txn, key, kv.LockNoWait)
+		if isTakenErr(err) {
+			return false, nil
+		}
+		if err != nil || value == nil {
+			return false, err
+		}
+		msg, err := q.decodeMessage(id, value)
+		if err != nil {
+			return false, err
+		}
+		if err = txn.Delete(key); err != nil {
+			return false, err
+		}
+		changed = true
+		if q.opts.maxAttempts > 0 && msg.Attempts >= q.opts.maxAttempts {
+			return false, txn.Set(q.deadKey(id), encodeMessage(msg.Attempts, msg.Payload))
+		}
+		msg.Attempts++
+		msg.Receipt = newReceipt(deadline, id)
+		msg.Deadline = deadline
+		if err = txn.Set(q.inflightKey(msg.Receipt), encodeMessage(msg.Attempts, msg.Payload)); err != nil {
+			return false, err
+		}
+		msgs = append(msgs, msg)
+		return true, nil
+	}
+
+	// The expired leases are at the head of the in-flight messages.
+	start, _ := codec.PrefixRange(q.inflightPrefix)
+	end := codec.NewKeyBuilder(q.inflightPrefix).AddInt64(now.UnixNano()).Key()
+	err = q.scan(txn, start, end, func(key []byte) (bool, error) {
+		_, id, err := parseReceipt(key[len(q.inflightPrefix):])
+		if err != nil {
+			return false, err
+		}
+		ok, err := take(key, id)
+		return ok && len(msgs) >= max, err
+	})
+	if err == nil && len(msgs) < max {
+		start, end = codec.PrefixRange(q.readyPrefix)
+		err = q.scan(txn, start, end, func(key []byte) (bool, error) {
+			ok, err := take(key, key[len(q.readyPrefix):])
+			return ok && len(msgs) >= max, err
+		})
+	}
+	if err != nil || !changed {
+		txn.Rollback()
+		return nil, err
+	}
+	if err = txn.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return msgs, nil
+}
+
+// scan calls f with the keys in [start, end) until f returns true. The keys are
+// collected before f is called, so f can write the transaction.
+func (q *Queue) scan(txn *transaction.KVTxn, start, end []byte, f func(key []byte) (bool, error)) error {
+	for {
+		it, err := txn.Iter(start, end)
+		if err != nil {
+			return err
+		}
+		var keys [][]byte
+		for it.Valid() && len(keys) < scanBatchSize {
+			keys = append(keys, append([]byte{}, it.Key()...))
+			if err = it.Next(); err != nil {
+				it.Close()
+				return err
+			}
+		}
+		it.Close()
+		for _, key := range keys {
+			stop, err := f(key)
+			if err != nil || stop {
+				return err
+			}
+		}
+		if len(keys) < scanBatchSize {
+			return nil
+		}
+		start = kv.NextKey(keys[len(keys)-1])
+	}
+}
+
+// Ack acknowledges the deliveries of the receipts, the messages are removed from
+// the queue. It returns ErrInvalidReceipt and acknowledges none of them if any
+// message is not in flight with the receipt. A receipt is still valid after its
+// deadline until the message is delivered again.
+func (q *Queue) Ack(ctx context.Context, receipts ...[]byte) error {
+	return q.updateInflight(ctx, receipts, func(txn *transaction.KVTxn, receipt []byte, attempts int, payload []byte) error {
+		return nil
+	})
+}
+
+// Nack gives up the deliveries of the receipts, the messages are ready to be
+// delivered again at their original positions. It returns ErrInvalidReceipt and
+// changes none of them if any message is not in flight with the receipt.
+func (q *Queue) Nack(ctx context.Context, receipts ...[]byte) error {
+	return q.updateInflight(ctx, receipts, func(txn *transaction.KVTxn, receipt []byte, attempts int, payload []byte) error {
+		_, id, err := parseReceipt(receipt)
+		if err != nil {
+			return err
+		}
+		return txn.Set(q.readyKey(id), encodeMessage(attempts, payload))
+	})
+}
+
+// Extend extends the lease of the receipt to timeout from now, and returns the new
+// receipt which replaces the old one.
+func (q *Queue) Extend(ctx context.Context, receipt []byte, timeout time.Duration) ([]byte, error) {
+	var newReceiptKey []byte
+	err := q.updateInflight(ctx, [][]byte{receipt}, func(txn *transaction.KVTxn, receipt []byte, attempts int, payload []byte) error {
+		_, id, err := parseReceipt(receipt)
+		if err != nil {
+			return err
+		}
+		newReceiptKey = newReceipt(q.opts.clock.Now().Add(timeout), id)
+		return txn.Set(q.inflightKey(newReceiptKey), encodeMessage(attempts, payload))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newReceiptKey, nil
+}
+
+// updateInflight removes the in-flight messages of the receipts, and calls f to
+// write them back in the same transaction.
+func (q *Queue) updateInflight(ctx context.Context, receipts [][]byte, f func(txn *transaction.KVTxn, receipt []byte, attempts int, payload []byte) error) error {
+	txn, err := q.beginPessimistic()
+	if err != nil {
+		return err
+	}
+	for _, receipt := range receipts {
+		if _, _, err = parseReceipt(receipt); err != nil {
+			txn.Rollback()
+			return err
+		}
+		key := q.inflightKey(receipt)
+		value, err := q.lockKey(ctx, txn, key, kv.LockAlwaysWait)
+		if err == nil && value == nil {
+			err = ErrInvalidReceipt
+		}
+		if err != nil {
+			txn.Rollback()
+			return err
+		}
+		attempts, payload, err := decodeValue(value)
+		if err == nil {
+			err = txn.Delete(key)
+		}
+		if err == nil {
+			err = f(txn, receipt, attempts, payload)
+		}
+		if err != nil {
+			txn.Rollback()
+			return err
+		}
+	}
+	return txn.Commit(ctx)
+}
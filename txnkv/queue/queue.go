@@ -0,0 +1,345 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package queue implements durable FIFO queues in transactions.
+//
+// A message is stored in one of the three parts of a queue, and moved between
+// them by Dequeue, Ack, Nack and Redrive:
+//
+//   ready:       prefix, name, "r", id           -> message
+//   in flight:   prefix, name, "l", deadline, id -> message
+//   dead letter: prefix, name, "d", id           -> message
+//
+// The id of a message is the start ts of the transaction which enqueues it and a
+// sequence number, so the messages are delivered in the order of the start ts.
+// A dequeued message is leased to the consumer until the deadline, then it's
+// delivered again unless it's acknowledged.
+package queue
+
+import (
+	"context"
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+
+	tikverr "github.com/JK1Zhang/client-go/v3/error"
+	"github.com/JK1Zhang/client-go/v3/kv"
+	"github.com/JK1Zhang/client-go/v3/oracle"
+	"github.com/JK1Zhang/client-go/v3/tikv"
+	"github.com/JK1Zhang/client-go/v3/txnkv/transaction"
+	"github.com/JK1Zhang/client-go/v3/util/codec"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultVisibilityTimeout = 30 * time.Second
+	// scanBatchSize is the number of keys scanned at a time to find the messages to dequeue.
+	scanBatchSize = 64
+)
+
+var defaultKeyPrefix = []byte("_queue_")
+
+// ErrInvalidReceipt means the message of the receipt is acknowledged, or its lease
+// expired and it's delivered again.
+var ErrInvalidReceipt = errors.New("queue: message of the receipt is not in flight")
+
+// idSeq makes the ids of the messages enqueued by one transaction unique.
+var idSeq uint64
+
+// Clock provides the current time to decide the deadlines of the leases.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+type options struct {
+	keyPrefix         []byte
+	visibilityTimeout time.Duration
+	maxAttempts       int
+	clock             Clock
+}
+
+// Option configures a Queue.
+type Option func(*options)
+
+// WithKeyPrefix sets the prefix of the keys of the queues.
+func WithKeyPrefix(prefix []byte) Option {
+	return func(o *options) {
+		o.keyPrefix = prefix
+	}
+}
+
+// WithVisibilityTimeout sets how long a dequeued message is invisible to other
+// consumers. The message is delivered again if it's not acknowledged in time.
+func WithVisibilityTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.visibilityTimeout = timeout
+	}
+}
+
+// WithMaxAttempts sets how many times a message is delivered at most. A message
+// is moved to the dead letters instead of being delivered again once it's
+// delivered n times. 0 means no limit, which is the default.
+func WithMaxAttempts(n int) Option {
+	return func(o *options) {
+		o.maxAttempts = n
+	}
+}
+
+// WithClock sets the clock deciding the deadlines of the leases.
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// Message is a message of a queue.
+type Message struct {
+	// ID identifies the message in the queue.
+	ID []byte
+	// Payload is the content of the message.
+	Payload []byte
+	// Attempts is the number of times the message is delivered, including the
+	// current delivery.
+	Attempts int
+	// EnqueuedAt is the start time of the transaction which enqueued the message.
+	EnqueuedAt time.Time
+	// Receipt is the handle to Ack, Nack or Extend the delivery. It's nil if the
+	// message is not in flight.
+	Receipt []byte
+	// Deadline is when the lease of the delivery expires.
+	Deadline time.Time
+}
+
+// Queue is a durable FIFO queue stored in TiKV. Queue is thread safe.
+type Queue struct {
+	store *tikv.KVStore
+	opts  options
+
+	readyPrefix    []byte
+	inflightPrefix []byte
+	deadPrefix     []byte
+}
+
+// NewQueue creates the Queue of the name.
+func NewQueue(store *tikv.KVStore, name string, opts ...Option) *Queue {
+	o := options{
+		keyPrefix:         defaultKeyPrefix,
+		visibilityTimeout: defaultVisibilityTimeout,
+		clock:             systemClock{},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.visibilityTimeout <= 0 {
+		o.visibilityTimeout = defaultVisibilityTimeout
+	}
+	base := codec.NewKeyBuilder(o.keyPrefix).AddString(name).Key()
+	return &Queue{
+		store:          store,
+		opts:           o,
+		readyPrefix:    codec.NewKeyBuilder(base).AddString("r").Key(),
+		inflightPrefix: codec.NewKeyBuilder(base).AddString("l").Key(),
+		deadPrefix:     codec.NewKeyBuilder(base).AddString("d").Key(),
+	}
+}
+
+// Enqueue enqueues the payloads in a new transaction and returns the ids of the messages.
+func (q *Queue) Enqueue(ctx context.Context, payloads ...[]byte) ([][]byte, error) {
+	txn, err := q.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	ids, err := q.EnqueueTxn(txn, payloads...)
+	if err != nil {
+		txn.Rollback()
+		return nil, err
+	}
+	if err = txn.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// EnqueueTxn enqueues the payloads in the transaction, so the messages are
+// visible only if the transaction commits.
+func (q *Queue) EnqueueTxn(txn *transaction.KVTxn, payloads ...[]byte) ([][]byte, error) {
+	ids := make([][]byte, 0, len(payloads))
+	for _, payload := range payloads {
+		id := codec.NewKeyBuilder(nil).AddUint64(txn.StartTS()).AddUint64(atomic.AddUint64(&idSeq, 1)).Key()
+		if err := txn.Set(q.readyKey(id), encodeMessage(0, payload)); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// DeadLetters returns at most limit messages moved to the dead letters.
+func (q *Queue) DeadLetters(ctx context.Context, limit int) ([]*Message, error) {
+	txn, err := q.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txn.Rollback()
+	start, end := codec.PrefixRange(q.deadPrefix)
+	it, err := txn.Iter(start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	var msgs []*Message
+	for it.Valid() && len(msgs) < limit {
+		msg, err := q.decodeMessage(it.Key()[len(q.deadPrefix):], it.Value())
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+		if err = it.Next(); err != nil {
+			return nil, err
+		}
+	}
+	return msgs, nil
+}
+
+// Redrive moves the dead letters of the ids back to the queue, with the attempts
+// reset. The ids which are not dead letters are ignored.
+func (q *Queue) Redrive(ctx context.Context, ids ...[]byte) error {
+	txn, err := q.beginPessimistic()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		value, err := q.lockKey(ctx, txn, q.deadKey(id), kv.LockAlwaysWait)
+		if err != nil {
+			txn.Rollback()
+			return err
+		}
+		if value == nil {
+			continue
+		}
+		_, payload, err := decodeValue(value)
+		if err != nil {
+			txn.Rollback()
+			return err
+		}
+		if err = txn.Delete(q.deadKey(id)); err != nil {
+			txn.Rollback()
+			return err
+		}
+		if err = txn.Set(q.readyKey(id), encodeMessage(0, payload)); err != nil {
+			txn.Rollback()
+			return err
+		}
+	}
+	return txn.Commit(ctx)
+}
+
+func (q *Queue) beginPessimistic() (*transaction.KVTxn, error) {
+	txn, err := q.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	txn.SetPessimistic(true)
+	return txn, nil
+}
+
+// lockKey locks the key and returns its latest value, or nil if the key doesn't exist.
+func (q *Queue) lockKey(ctx context.Context, txn *transaction.KVTxn, key []byte, lockWaitTime int64) ([]byte, error) {
+	bo := tikv.NewBackofferWithVars(ctx, transaction.TsoMaxBackoff, nil)
+	forUpdateTS, err := q.store.GetTimestampWithRetry(bo, txn.GetScope())
+	if err != nil {
+		return nil, err
+	}
+	lockCtx := kv.NewLockCtx(forUpdateTS, lockWaitTime, time.Now())
+	lockCtx.InitReturnValues(1)
+	if err = txn.LockKeys(ctx, lockCtx, key); err != nil {
+		return nil, err
+	}
+	value := lockCtx.Values[string(key)]
+	if !value.Exists {
+		return nil, nil
+	}
+	return value.Value, nil
+}
+
+// isTakenErr returns whether locking a message failed because another consumer
+// is taking or has taken it.
+func isTakenErr(err error) bool {
+	return errors.Cause(err) == tikverr.ErrLockAcquireFailAndNoWaitSet || tikverr.GetCategory(err) == tikverr.CategoryConflict
+}
+
+func (q *Queue) readyKey(id []byte) []byte {
+	return append(append([]byte{}, q.readyPrefix...), id...)
+}
+
+func (q *Queue) deadKey(id []byte) []byte {
+	return append(append([]byte{}, q.deadPrefix...), id...)
+}
+
+func (q *Queue) inflightKey(receipt []byte) []byte {
+	return append(append([]byte{}, q.inflightPrefix...), receipt...)
+}
+
+func newReceipt(deadline time.Time, id []byte) []byte {
+	return append(codec.NewKeyBuilder(nil).AddInt64(deadline.UnixNano()).Key(), id...)
+}
+
+func parseReceipt(receipt []byte) (time.Time, []byte, error) {
+	d := codec.NewKeyDecoder(receipt)
+	deadline, err := d.DecodeInt64()
+	if err != nil {
+		return time.Time{}, nil, errors.Wrap(err, "queue: invalid receipt")
+	}
+	return time.Unix(0, deadline), d.Remaining(), nil
+}
+
+// decodeMessage decodes the message of the id stored in the value.
+func (q *Queue) decodeMessage(id, value []byte) (*Message, error) {
+	startTS, err := codec.NewKeyDecoder(id).DecodeUint64()
+	if err != nil {
+		return nil, errors.Wrapf(err, "queue: invalid message id %x", id)
+	}
+	attempts, payload, err := decodeValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{
+		ID:         append([]byte{}, id...),
+		Payload:    payload,
+		Attempts:   attempts,
+		EnqueuedAt: oracle.GetTimeFromTS(startTS),
+	}, nil
+}
+
+func encodeMessage(attempts int, payload []byte) []byte {
+	value := make([]byte, 0, binary.MaxVarintLen64+len(payload))
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(attempts))
+	value = append(value, buf[:n]...)
+	return append(value, payload...)
+}
+
+func decodeValue(value []byte) (int, []byte, error) {
+	attempts, n := binary.Uvarint(value)
+	if n <= 0 {
+		return 0, nil, errors.Errorf("queue: invalid message value %x", value)
+	}
+	return int(attempts), append([]byte{}, value[n:]...), nil
+}
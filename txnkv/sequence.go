@@ -0,0 +1,370 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package txnkv
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/JK1Zhang/client-go/v3/internal/logutil"
+	"github.com/JK1Zhang/client-go/v3/internal/retry"
+	"github.com/JK1Zhang/client-go/v3/kv"
+	"github.com/JK1Zhang/client-go/v3/tikv"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultSequenceStep       = 1000
+	defaultSequenceStart      = 1
+	defaultSequenceMaxRetries = 10
+	sequenceReserveMaxBackoff = 20000
+)
+
+var defaultSequenceKeyPrefix = []byte("_sequence_")
+
+type sequenceOptions struct {
+	keyPrefix   []byte
+	step        uint64
+	start       uint64
+	strict      bool
+	prefetch    uint64
+	prefetchSet bool
+	maxRetries  int
+}
+
+// SequenceOption configures a SequenceAllocator.
+type SequenceOption func(*sequenceOptions)
+
+// WithSequenceKeyPrefix sets the prefix of the keys storing the sequences. The key
+// of a sequence is the prefix followed by the name of the sequence.
+func WithSequenceKeyPrefix(prefix []byte) SequenceOption {
+	return func(o *sequenceOptions) {
+		o.keyPrefix = prefix
+	}
+}
+
+// WithSequenceStep sets the number of IDs reserved in one transaction.
+func WithSequenceStep(step uint64) SequenceOption {
+	return func(o *sequenceOptions) {
+		o.step = step
+	}
+}
+
+// WithSequenceStart sets the first ID of a sequence which has not been stored yet.
+func WithSequenceStart(start uint64) SequenceOption {
+	return func(o *sequenceOptions) {
+		o.start = start
+	}
+}
+
+// WithSequenceStrict makes the IDs strictly increasing across all clients: an ID
+// is greater than all the IDs returned, by any allocator, before it's requested.
+// IDs are not cached in strict mode, concurrent requests of an allocator are
+// batched into one transaction instead, and the step and prefetch are ignored.
+func WithSequenceStrict(strict bool) SequenceOption {
+	return func(o *sequenceOptions) {
+		o.strict = strict
+	}
+}
+
+// WithSequencePrefetch sets when the next block is reserved in the background: it
+// starts when fewer than threshold IDs are left in the current block. 0 disables
+// prefetch. The default threshold is half of the step.
+func WithSequencePrefetch(threshold uint64) SequenceOption {
+	return func(o *sequenceOptions) {
+		o.prefetch = threshold
+		o.prefetchSet = true
+	}
+}
+
+// WithSequenceMaxRetries sets how many times a reservation is retried in a new
+// transaction when it conflicts with other transactions.
+func WithSequenceMaxRetries(n int) SequenceOption {
+	return func(o *sequenceOptions) {
+		o.maxRetries = n
+	}
+}
+
+// SequenceAllocator allocates increasing uint64 IDs from named sequences stored in
+// TiKV. Each sequence stores the next ID not reserved yet. An allocator reserves a
+// block of IDs in one transaction and hands them out locally, so the IDs from an
+// allocator are increasing, but IDs from different allocators interleave unless
+// the allocators are strict. The IDs left in the blocks are lost when the allocator
+// is closed, so a sequence may have gaps.
+// SequenceAllocator is thread safe.
+type SequenceAllocator struct {
+	store *tikv.KVStore
+	opts  sequenceOptions
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu struct {
+		sync.Mutex
+		closed bool
+		seqs   map[string]*sequence
+	}
+}
+
+// sequence is the state of a named sequence in the allocator, guarded by the mutex
+// of the allocator.
+type sequence struct {
+	key []byte
+	// [cur, end) is the current block.
+	cur, end uint64
+	// next is the block reserved by prefetch.
+	next *idBlock
+	// fetching is the reservation in progress, if any.
+	fetching *idBlock
+	// pending is the batch of strict requests waiting for the next reservation.
+	pending *idBlock
+}
+
+type idBlock struct {
+	start, count uint64
+	err          error
+	done         chan struct{}
+}
+
+// NewSequenceAllocator creates a SequenceAllocator storing the sequences in the store.
+func NewSequenceAllocator(store *tikv.KVStore, opts ...SequenceOption) *SequenceAllocator {
+	options := sequenceOptions{
+		keyPrefix:  defaultSequenceKeyPrefix,
+		step:       defaultSequenceStep,
+		start:      defaultSequenceStart,
+		maxRetries: defaultSequenceMaxRetries,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.step == 0 {
+		options.step = defaultSequenceStep
+	}
+	if !options.prefetchSet {
+		options.prefetch = options.step / 2
+	}
+	if options.maxRetries < 0 {
+		options.maxRetries = 0
+	}
+	a := &SequenceAllocator{
+		store: store,
+		opts:  options,
+	}
+	a.ctx, a.cancel = context.WithCancel(store.Ctx())
+	a.mu.seqs = make(map[string]*sequence)
+	return a
+}
+
+// Next returns the next ID of the named sequence. It only waits for a transaction
+// if the reserved IDs are used up.
+func (a *SequenceAllocator) Next(ctx context.Context, name string) (uint64, error) {
+	a.mu.Lock()
+	if a.mu.closed {
+		a.mu.Unlock()
+		return 0, errors.New("sequence allocator is closed")
+	}
+	seq, ok := a.mu.seqs[name]
+	if !ok {
+		seq = &sequence{key: append(append([]byte{}, a.opts.keyPrefix...), name...)}
+		a.mu.seqs[name] = seq
+	}
+	if a.opts.strict {
+		return a.nextStrict(ctx, seq)
+	}
+	for {
+		if seq.cur < seq.end {
+			id := seq.cur
+			seq.cur++
+			if a.opts.prefetch > 0 && seq.next == nil && seq.fetching == nil && seq.end-seq.cur < a.opts.prefetch {
+				a.startReserve(seq, a.opts.step)
+			}
+			a.mu.Unlock()
+			return id, nil
+		}
+		if seq.next != nil {
+			seq.cur, seq.end = seq.next.start, seq.next.start+seq.next.count
+			seq.next = nil
+			continue
+		}
+		if seq.fetching == nil {
+			a.startReserve(seq, a.opts.step)
+		}
+		b := seq.fetching
+		a.mu.Unlock()
+		select {
+		case <-b.done:
+		case <-ctx.Done():
+			return 0, errors.WithStack(ctx.Err())
+		}
+		if b.err != nil {
+			return 0, b.err
+		}
+		a.mu.Lock()
+		if a.mu.closed {
+			a.mu.Unlock()
+			return 0, errors.New("sequence allocator is closed")
+		}
+	}
+}
+
+// nextStrict adds the request to the pending batch of the sequence and waits for
+// the batch to be reserved. The batch is reserved after the current reservation
+// finishes, so the reservation starts after the request. It's called with the
+// mutex held and releases it.
+func (a *SequenceAllocator) nextStrict(ctx context.Context, seq *sequence) (uint64, error) {
+	if seq.pending == nil {
+		seq.pending = &idBlock{done: make(chan struct{})}
+	}
+	b := seq.pending
+	idx := b.count
+	b.count++
+	if seq.fetching == nil {
+		a.startPending(seq)
+	}
+	a.mu.Unlock()
+	select {
+	case <-b.done:
+	case <-ctx.Done():
+		return 0, errors.WithStack(ctx.Err())
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.start + idx, nil
+}
+
+// startPending starts reserving the pending batch of a strict sequence.
+func (a *SequenceAllocator) startPending(seq *sequence) {
+	b := seq.pending
+	seq.pending = nil
+	seq.fetching = b
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		b.start, b.err = a.reserve(a.ctx, seq.key, b.count)
+		a.mu.Lock()
+		seq.fetching = nil
+		if seq.pending != nil {
+			a.startPending(seq)
+		}
+		a.mu.Unlock()
+		close(b.done)
+	}()
+}
+
+// startReserve reserves a block of the sequence in the background. The reserved
+// block becomes the next block of the sequence.
+func (a *SequenceAllocator) startReserve(seq *sequence, count uint64) {
+	b := &idBlock{count: count, done: make(chan struct{})}
+	seq.fetching = b
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		b.start, b.err = a.reserve(a.ctx, seq.key, b.count)
+		if b.err != nil {
+			logutil.Logger(a.ctx).Warn("sequence allocator failed to reserve ids",
+				zap.String("key", string(seq.key)),
+				zap.Error(b.err))
+		}
+		a.mu.Lock()
+		seq.fetching = nil
+		if b.err == nil {
+			seq.next = b
+		}
+		a.mu.Unlock()
+		close(b.done)
+	}()
+}
+
+// reserve reserves count IDs of the sequence and returns the first one.
+func (a *SequenceAllocator) reserve(ctx context.Context, key []byte, count uint64) (uint64, error) {
+	bo := retry.NewBackofferWithVars(ctx, sequenceReserveMaxBackoff, nil)
+	for attempt := 0; ; attempt++ {
+		start, err := a.reserveOnce(ctx, key, count)
+		if err == nil {
+			return start, nil
+		}
+		if attempt >= a.opts.maxRetries || !isRetryableCommitErr(err) {
+			return 0, err
+		}
+		logutil.Logger(ctx).Info("sequence allocator retries reservation",
+			zap.String("key", string(key)),
+			zap.Int("attempt", attempt+1),
+			zap.Error(err))
+		if err = bo.Backoff(retry.BoTxnLock, err); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (a *SequenceAllocator) reserveOnce(ctx context.Context, key []byte, count uint64) (uint64, error) {
+	txn, err := a.store.Begin()
+	if err != nil {
+		return 0, err
+	}
+	txn.SetPessimistic(true)
+	// The value is read by the pessimistic lock, which returns the latest committed
+	// value rather than the one in the snapshot of the transaction.
+	bo := retry.NewBackofferWithVars(ctx, sequenceReserveMaxBackoff, nil)
+	forUpdateTS, err := a.store.GetTimestampWithRetry(bo, txn.GetScope())
+	if err != nil {
+		txn.Rollback()
+		return 0, err
+	}
+	lockCtx := kv.NewLockCtx(forUpdateTS, kv.LockAlwaysWait, time.Now())
+	lockCtx.InitReturnValues(1)
+	if err = txn.LockKeys(ctx, lockCtx, key); err != nil {
+		txn.Rollback()
+		return 0, err
+	}
+	start := a.opts.start
+	if value := lockCtx.Values[string(key)].Value; len(value) > 0 {
+		if len(value) != 8 {
+			txn.Rollback()
+			return 0, errors.Errorf("invalid sequence value %x of key %q", value, key)
+		}
+		start = binary.BigEndian.Uint64(value)
+	}
+	if start > math.MaxUint64-count {
+		txn.Rollback()
+		return 0, errors.Errorf("sequence %q is exhausted", key)
+	}
+	var value [8]byte
+	binary.BigEndian.PutUint64(value[:], start+count)
+	if err = txn.Set(key, value[:]); err != nil {
+		txn.Rollback()
+		return 0, err
+	}
+	if err = txn.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return start, nil
+}
+
+// Close stops the allocator and waits for the reservations in progress. The IDs
+// reserved but not returned are lost. The reservations in the background are
+// also stopped when the store is closed.
+func (a *SequenceAllocator) Close() {
+	a.mu.Lock()
+	a.mu.closed = true
+	a.mu.Unlock()
+	a.cancel()
+	a.wg.Wait()
+}
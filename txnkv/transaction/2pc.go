@@ -1428,7 +1428,8 @@ func (c *twoPhaseCommitter) execute(ctx context.Context) (err error) {
 	err = c.prewriteMutations(bo, c.mutations)
 
 	if err != nil {
-		if assertionFailed, ok := errors.Cause(err).(*tikverr.ErrAssertionFailed); ok {
+		var assertionFailed *tikverr.ErrAssertionFailed
+		if errors.As(err, &assertionFailed) {
 			err = c.checkSchemaOnAssertionFail(ctx, assertionFailed)
 		}
 
@@ -1477,6 +1478,7 @@ func (c *twoPhaseCommitter) execute(ctx context.Context) (err error) {
 
 	// strip check_not_exists keys that no need to commit.
 	c.stripNoNeedCommitKeys()
+	c.txn.runPostPrewriteHooks(ctx, c.mutations)
 
 	var commitTS uint64
 
@@ -1615,7 +1617,10 @@ func (c *twoPhaseCommitter) execute(ctx context.Context) (err error) {
 }
 
 func (c *twoPhaseCommitter) commitTxn(ctx context.Context, commitDetail *util.CommitDetails) error {
-	c.txn.GetMemBuffer().DiscardValues()
+	// The values are still needed by the post commit hooks.
+	if len(c.txn.postCommitHooks) == 0 {
+		c.txn.GetMemBuffer().DiscardValues()
+	}
 	start := time.Now()
 
 	// Use the VeryLongMaxBackoff to commit the primary key.
@@ -1711,7 +1716,7 @@ func (c *twoPhaseCommitter) amendPessimisticLock(ctx context.Context, addMutatio
 			err = c.pessimisticLockMutations(pessimisticLockBo, lCtx, &keysNeedToLock)
 			if err != nil {
 				// KeysNeedToLock won't change, so don't async rollback pessimistic locks here for write conflict.
-				if _, ok := errors.Cause(err).(*tikverr.ErrWriteConflict); ok {
+				if tikverr.IsErrWriteConflict(err) {
 					newForUpdateTSVer, err := c.store.CurrentTimestamp(oracle.GlobalTxnScope)
 					if err != nil {
 						return err
@@ -2066,7 +2071,8 @@ func (batchExe *batchExecutor) process(batches []batchMutations) error {
 				zap.Stringer("action type", batchExe.action),
 				zap.Error(e),
 				zap.Uint64("txnStartTS", batchExe.committer.startTS))
-			if _, isAssertionFailed := errors.Cause(e).(*tikverr.ErrAssertionFailed); isAssertionFailed {
+			var assertionFailed *tikverr.ErrAssertionFailed
+			if errors.As(e, &assertionFailed) {
 				if assertionFailedErr == nil {
 					assertionFailedErr = e
 				}
@@ -0,0 +1,122 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transaction
+
+import (
+	"context"
+)
+
+// CommitProtocol is the protocol used to commit a transaction.
+type CommitProtocol int
+
+const (
+	// CommitProtocol2PC is the two phase commit protocol.
+	CommitProtocol2PC CommitProtocol = iota
+	// CommitProtocolAsyncCommit is the async commit protocol.
+	CommitProtocolAsyncCommit
+	// CommitProtocol1PC is the one phase commit protocol.
+	CommitProtocol1PC
+)
+
+func (p CommitProtocol) String() string {
+	switch p {
+	case CommitProtocolAsyncCommit:
+		return "async_commit"
+	case CommitProtocol1PC:
+		return "1pc"
+	default:
+		return "2pc"
+	}
+}
+
+// CommitInfo is the information of a committed transaction passed to the PostCommitHook.
+type CommitInfo struct {
+	StartTS  uint64
+	CommitTS uint64
+	// Mutations are the mutations written by the transaction.
+	Mutations CommitterMutations
+	Protocol  CommitProtocol
+}
+
+// PrePrewriteHook is called before the mutations of a transaction are prewritten.
+// Returning an error aborts the commit, and the error is returned by Commit.
+// The mutations must not be modified by the hook.
+type PrePrewriteHook func(ctx context.Context, startTS uint64, mutations CommitterMutations) error
+
+// PostPrewriteHook is called after all mutations of a transaction are prewritten successfully.
+// For 1PC transactions, the transaction has been committed when it is called.
+type PostPrewriteHook func(ctx context.Context, startTS uint64, mutations CommitterMutations)
+
+// PostCommitHook is called after a transaction is committed successfully.
+// For async commit transactions, the secondary keys may still be committing when it is called.
+type PostCommitHook func(ctx context.Context, info CommitInfo)
+
+// AddPrePrewriteHook registers a hook that is called before prewrite.
+// The hooks are called in the order they are added, and the first error stops the commit.
+func (txn *KVTxn) AddPrePrewriteHook(hook PrePrewriteHook) {
+	txn.prePrewriteHooks = append(txn.prePrewriteHooks, hook)
+}
+
+// AddPostPrewriteHook registers a hook that is called after prewrite succeeds.
+// The hooks are called in the order they are added.
+func (txn *KVTxn) AddPostPrewriteHook(hook PostPrewriteHook) {
+	txn.postPrewriteHooks = append(txn.postPrewriteHooks, hook)
+}
+
+// AddPostCommitHook registers a hook that is called after the transaction is committed.
+// The hooks are called in the order they are added.
+func (txn *KVTxn) AddPostCommitHook(hook PostCommitHook) {
+	txn.postCommitHooks = append(txn.postCommitHooks, hook)
+}
+
+func (txn *KVTxn) runPrePrewriteHooks(ctx context.Context, mutations CommitterMutations) error {
+	for _, hook := range txn.prePrewriteHooks {
+		if err := hook(ctx, txn.startTS, mutations); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (txn *KVTxn) runPostPrewriteHooks(ctx context.Context, mutations CommitterMutations) {
+	for _, hook := range txn.postPrewriteHooks {
+		hook(ctx, txn.startTS, mutations)
+	}
+}
+
+func (txn *KVTxn) runPostCommitHooks(ctx context.Context) {
+	if len(txn.postCommitHooks) == 0 {
+		return
+	}
+	info := CommitInfo{
+		StartTS:   txn.startTS,
+		CommitTS:  txn.commitTS,
+		Mutations: txn.committer.mutations,
+		Protocol:  txn.committer.commitProtocol(),
+	}
+	for _, hook := range txn.postCommitHooks {
+		hook(ctx, info)
+	}
+}
+
+func (c *twoPhaseCommitter) commitProtocol() CommitProtocol {
+	if c.isOnePC() {
+		return CommitProtocol1PC
+	}
+	if c.isAsyncCommit() {
+		return CommitProtocolAsyncCommit
+	}
+	return CommitProtocol2PC
+}
@@ -259,8 +259,15 @@ func (action actionPessimisticLock) handleSingleBatch(c *twoPhaseCommitter, bo *
 				// do nothing but keep wait
 			} else {
 				// the lockWaitTime is set, we should return wait timeout if we are still blocked by a lock
-				if time.Since(lockWaitStartTime).Milliseconds() >= action.LockWaitTime() {
-					return errors.WithStack(tikverr.ErrLockWaitTimeout)
+				if waited := time.Since(lockWaitStartTime); waited.Milliseconds() >= action.LockWaitTime() {
+					// The detail is returned without a stack, so errors.Unwrap returns
+					// ErrLockWaitTimeout like errors.WithStack(ErrLockWaitTimeout) does.
+					return &tikverr.ErrLockWaitTimeoutDetail{
+						Key:            locks[0].Key,
+						Primary:        locks[0].Primary,
+						BlockerStartTS: locks[0].TxnID,
+						WaitDuration:   waited,
+					}
 				}
 			}
 			if action.LockCtx.PessimisticLockWaited != nil {
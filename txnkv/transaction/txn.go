@@ -117,6 +117,10 @@ type KVTxn struct {
 	schemaAmender SchemaAmender
 	// commitCallback is called after current transaction gets committed
 	commitCallback func(info string, err error)
+	// commit hooks, see hooks.go.
+	prePrewriteHooks  []PrePrewriteHook
+	postPrewriteHooks []PostPrewriteHook
+	postCommitHooks   []PostCommitHook
 
 	binlog                  BinlogExecutor
 	schemaLeaseChecker      SchemaLeaseChecker
@@ -202,6 +206,39 @@ func (txn *KVTxn) Set(k []byte, v []byte) error {
 	return txn.us.GetMemBuffer().Set(k, v)
 }
 
+// SetWithAssertion sets the value for key k, and asserts whether the key exists before
+// the transaction writes it. The assertion must be kv.SetAssertExist or kv.SetAssertNotExist.
+// If the assertion is not satisfied, Commit returns *tikverr.ErrAssertionFailed.
+// The assertion is checked at the level set by SetAssertionLevel, it returns an
+// error if the level is Off, in which case the assertion would be ignored.
+func (txn *KVTxn) SetWithAssertion(k []byte, v []byte, assertion tikv.FlagsOp) error {
+	if err := txn.prepareAssertion(assertion); err != nil {
+		return err
+	}
+	txn.setCnt++
+	return txn.us.GetMemBuffer().SetWithFlags(k, v, assertion)
+}
+
+// DeleteWithAssertion removes the entry for key k, and asserts whether the key exists before
+// the transaction deletes it. See SetWithAssertion for the details of the assertion.
+func (txn *KVTxn) DeleteWithAssertion(k []byte, assertion tikv.FlagsOp) error {
+	if err := txn.prepareAssertion(assertion); err != nil {
+		return err
+	}
+	return txn.us.GetMemBuffer().DeleteWithFlags(k, assertion)
+}
+
+func (txn *KVTxn) prepareAssertion(assertion tikv.FlagsOp) error {
+	if assertion != tikv.SetAssertExist && assertion != tikv.SetAssertNotExist {
+		return errors.Errorf("invalid assertion %v, it must be SetAssertExist or SetAssertNotExist", assertion)
+	}
+	// The assertions are ignored if the assertion level is off.
+	if txn.assertionLevel == kvrpcpb.AssertionLevel_Off {
+		return errors.New("assertion level is off, call SetAssertionLevel before asserting")
+	}
+	return nil
+}
+
 // String implements fmt.Stringer interface.
 func (txn *KVTxn) String() string {
 	return fmt.Sprintf("%d", txn.StartTS())
@@ -379,6 +416,10 @@ func (txn *KVTxn) Commit(ctx context.Context) error {
 	}
 	defer txn.close()
 
+	if txn.snapshot.IsStatenessReadOnly() && !txn.IsReadOnly() {
+		return errors.WithStack(tikverr.ErrWriteInStaleReadTxn)
+	}
+
 	if val, err := util.EvalFailpoint("mockCommitError"); err == nil && val.(bool) {
 		if _, err := util.EvalFailpoint("mockCommitErrorOpt"); err == nil {
 			failpoint.Disable("tikvclient/mockCommitErrorOpt")
@@ -430,6 +471,12 @@ func (txn *KVTxn) Commit(ctx context.Context) error {
 	if committer.mutations.Len() == 0 {
 		return nil
 	}
+	if err = txn.runPrePrewriteHooks(ctx, committer.mutations); err != nil {
+		if txn.IsPessimistic() {
+			txn.asyncPessimisticRollback(ctx, committer.mutations.GetKeys())
+		}
+		return err
+	}
 
 	defer func() {
 		detail := committer.getDetail()
@@ -455,6 +502,9 @@ func (txn *KVTxn) Commit(ctx context.Context) error {
 		if val == nil || sessionID > 0 {
 			txn.onCommitted(err)
 		}
+		if err == nil {
+			txn.runPostCommitHooks(ctx)
+		}
 		logutil.Logger(ctx).Debug("[kv] txnLatches disabled, 2pc directly", zap.Error(err))
 		return err
 	}
@@ -478,6 +528,7 @@ func (txn *KVTxn) Commit(ctx context.Context) error {
 	}
 	if err == nil {
 		lock.SetCommitTS(committer.commitTS)
+		txn.runPostCommitHooks(ctx)
 	}
 	logutil.Logger(ctx).Debug("[kv] txnLatches enabled while txn retryable", zap.Error(err))
 	return err
@@ -551,13 +602,7 @@ func (txn *KVTxn) onCommitted(err error) {
 	if txn.commitCallback != nil {
 		isAsyncCommit := txn.committer.isAsyncCommit()
 		isOnePC := txn.committer.isOnePC()
-
-		commitMode := "2pc"
-		if isOnePC {
-			commitMode = "1pc"
-		} else if isAsyncCommit {
-			commitMode = "async_commit"
-		}
+		commitMode := txn.committer.commitProtocol().String()
 
 		info := TxnInfo{
 			TxnScope:            txn.GetScope(),
@@ -709,7 +754,8 @@ func (txn *KVTxn) LockKeys(ctx context.Context, lockCtx *tikv.LockCtx, keysInput
 			keyMayBeLocked := !(tikverr.IsErrWriteConflict(err) || tikverr.IsErrKeyExist(err))
 			// If there is only 1 key and lock fails, no need to do pessimistic rollback.
 			if len(keys) > 1 || keyMayBeLocked {
-				dl, isDeadlock := errors.Cause(err).(*tikverr.ErrDeadlock)
+				var dl *tikverr.ErrDeadlock
+				isDeadlock := errors.As(err, &dl)
 				if isDeadlock {
 					if hashInKeys(dl.DeadlockKeyHash, keys) {
 						dl.IsRetryable = true
@@ -839,6 +885,12 @@ func (txn *KVTxn) StartTS() uint64 {
 	return txn.startTS
 }
 
+// GetCommitTS returns the commit ts of the transaction, it's 0 if the transaction
+// is not committed.
+func (txn *KVTxn) GetCommitTS() uint64 {
+	return txn.commitTS
+}
+
 // Valid returns if the transaction is valid.
 // A transaction become invalid after commit or rollback.
 func (txn *KVTxn) Valid() bool {
@@ -16,7 +16,6 @@ package txnlock
 
 import (
 	"bytes"
-	"container/list"
 	"context"
 	"encoding/hex"
 	"fmt"
@@ -54,7 +53,7 @@ import (
 	"go.uber.org/zap"
 )
 
-// ResolvedCacheSize is max number of cached txn status.
+// ResolvedCacheSize is the default max number of cached txn status.
 const ResolvedCacheSize = 2048
 
 const (
@@ -75,11 +74,11 @@ type storage interface {
 type LockResolver struct {
 	store                    storage
 	resolveLockLiteThreshold uint64
+	asyncResolveForRead      bool
 	mu                       struct {
 		sync.RWMutex
-		// resolved caches resolved txns (FIFO, txn id -> txnStatus).
-		resolved       map[uint64]TxnStatus
-		recentResolved *list.List
+		// resolved caches resolved txns (txn id -> txnStatus).
+		resolved ResolvedCache
 	}
 	testingKnobs struct {
 		meetLock func(locks []*Lock)
@@ -96,13 +95,28 @@ func NewLockResolver(store storage) *LockResolver {
 	r := &LockResolver{
 		store:                    store,
 		resolveLockLiteThreshold: config.GetGlobalConfig().TiKVClient.ResolveLockLiteThreshold,
+		asyncResolveForRead:      config.GetGlobalConfig().TiKVClient.AsyncResolveLockForRead,
 	}
-	r.mu.resolved = make(map[uint64]TxnStatus)
-	r.mu.recentResolved = list.New()
+	r.mu.resolved = newResolvedCacheByConfig()
 	r.asyncResolveCtx, r.asyncResolveCancel = context.WithCancel(context.Background())
 	return r
 }
 
+// SetResolvedCache replaces the cache of the resolved txn status, so that it can
+// be shared with other LockResolvers connected to the same cluster.
+func (lr *LockResolver) SetResolvedCache(cache ResolvedCache) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	lr.mu.resolved = cache
+}
+
+// GetResolvedCache returns the cache of the resolved txn status.
+func (lr *LockResolver) GetResolvedCache() ResolvedCache {
+	lr.mu.RLock()
+	defer lr.mu.RUnlock()
+	return lr.mu.resolved
+}
+
 // Close cancels all background goroutines.
 func (lr *LockResolver) Close() {
 	lr.asyncResolveCancel()
@@ -194,26 +208,16 @@ func NewLock(l *kvrpcpb.LockInfo) *Lock {
 }
 
 func (lr *LockResolver) saveResolved(txnID uint64, status TxnStatus) {
-	lr.mu.Lock()
-	defer lr.mu.Unlock()
-
-	if _, ok := lr.mu.resolved[txnID]; ok {
-		return
-	}
-	lr.mu.resolved[txnID] = status
-	lr.mu.recentResolved.PushBack(txnID)
-	if len(lr.mu.resolved) > ResolvedCacheSize {
-		front := lr.mu.recentResolved.Front()
-		delete(lr.mu.resolved, front.Value.(uint64))
-		lr.mu.recentResolved.Remove(front)
-	}
+	lr.GetResolvedCache().Put(txnID, status)
 }
 
 func (lr *LockResolver) getResolved(txnID uint64) (TxnStatus, bool) {
-	lr.mu.RLock()
-	defer lr.mu.RUnlock()
-
-	s, ok := lr.mu.resolved[txnID]
+	s, ok := lr.GetResolvedCache().Get(txnID)
+	if ok {
+		metrics.ResolvedCacheCountWithHit.Inc()
+	} else {
+		metrics.ResolvedCacheCountWithMiss.Inc()
+	}
 	return s, ok
 }
 
@@ -322,9 +326,9 @@ func (lr *LockResolver) BatchResolveLocks(bo *retry.Backoffer, locks []*Lock, lo
 //    are expired then all locks will be resolved so the returned `ok` will be
 //    true, otherwise caller should sleep a while before retry.
 // 2) For each lock, query the primary key to get txn(which left the lock)'s
-//    commit status.
+//    commit status. The status of different txns are queried concurrently.
 // 3) Send `ResolveLock` cmd to the lock's region to resolve all locks belong to
-//    the same transaction.
+//    the same transaction. The locks in the same region are resolved in a batch.
 func (lr *LockResolver) ResolveLocks(bo *retry.Backoffer, callerStartTS uint64, locks []*Lock) (int64, error) {
 	ttl, _, _, err := lr.resolveLocks(bo, callerStartTS, locks, false, false)
 	return ttl, err
@@ -333,6 +337,7 @@ func (lr *LockResolver) ResolveLocks(bo *retry.Backoffer, callerStartTS uint64,
 // ResolveLocksForRead is essentially the same as ResolveLocks, except with some optimizations for read.
 // Read operations needn't wait for resolve secondary locks and can read through(the lock's transaction is committed
 // and its commitTS is less than or equal to callerStartTS) or ignore(the lock's transaction is rolled back or its minCommitTS is pushed) the lock .
+// The locks are resolved in the background unless AsyncResolveLockForRead is disabled.
 func (lr *LockResolver) ResolveLocksForRead(bo *retry.Backoffer, callerStartTS uint64, locks []*Lock, lite bool) (int64, []uint64 /* canIgnore */, []uint64 /* canAccess */, error) {
 	return lr.resolveLocks(bo, callerStartTS, locks, true, lite)
 }
@@ -347,14 +352,28 @@ func (lr *LockResolver) resolveLocks(bo *retry.Backoffer, callerStartTS uint64,
 	}
 	metrics.LockResolverCountWithResolve.Inc()
 
-	// TxnID -> []Region, record resolved Regions.
-	// TODO: Maybe put it in LockResolver and share by all txns.
-	cleanTxns := make(map[uint64]map[locate.RegionVerID]struct{})
+	// Read operations needn't wait for the locks to be resolved if it's enabled.
+	async := forRead && lr.asyncResolveForRead
+	// The status of the first lock of each txn is checked concurrently in advance.
+	checked, err := lr.checkTxnStatusesConcurrently(bo, callerStartTS, locks)
+	if err != nil {
+		msBeforeTxnExpired.update(0)
+		return msBeforeTxnExpired.value(), nil, nil, err
+	}
+	// The committed or rolled back locks which are resolved in batches per region.
+	var pending []resolvingLock
+	// TxnID -> struct{}, record the async commit txns being resolved.
+	asyncCommitTxns := make(map[uint64]struct{})
 	var resolve func(*Lock, bool) (TxnStatus, error)
 	resolve = func(l *Lock, forceSyncCommit bool) (TxnStatus, error) {
-		status, err := lr.getTxnStatusFromLock(bo, l, callerStartTS, forceSyncCommit)
-		if err != nil {
-			return TxnStatus{}, err
+		status, ok := checked[l]
+		if ok && !forceSyncCommit {
+			delete(checked, l)
+		} else {
+			status, err = lr.getTxnStatusFromLock(bo, l, callerStartTS, forceSyncCommit)
+			if err != nil {
+				return TxnStatus{}, err
+			}
 		}
 		if status.ttl != 0 {
 			return status, nil
@@ -362,19 +381,15 @@ func (lr *LockResolver) resolveLocks(bo *retry.Backoffer, callerStartTS uint64,
 
 		// If the lock is committed or rollbacked, resolve lock.
 		metrics.LockResolverCountWithExpired.Inc()
-		cleanRegions, exists := cleanTxns[l.TxnID]
-		if !exists {
-			cleanRegions = make(map[locate.RegionVerID]struct{})
-			cleanTxns[l.TxnID] = cleanRegions
-		}
 		if status.primaryLock != nil && status.primaryLock.UseAsyncCommit && !forceSyncCommit {
 			// resolveAsyncCommitLock will resolve all locks of the transaction, so we needn't resolve
 			// it again if it has been resolved once.
-			if exists {
+			if _, exists := asyncCommitTxns[l.TxnID]; exists {
 				return status, nil
 			}
+			asyncCommitTxns[l.TxnID] = struct{}{}
 			// status of async-commit transaction is determined by resolveAsyncCommitLock.
-			status, err = lr.resolveAsyncCommitLock(bo, l, status, forRead)
+			status, err = lr.resolveAsyncCommitLock(bo, l, status, async)
 			if _, ok := errors.Cause(err).(*nonAsyncCommitLock); ok {
 				status, err = resolve(l, true)
 			}
@@ -382,24 +397,10 @@ func (lr *LockResolver) resolveLocks(bo *retry.Backoffer, callerStartTS uint64,
 		}
 		if l.LockType == kvrpcpb.Op_PessimisticLock {
 			// pessimistic locks don't block read so it needn't be async.
-			err = lr.resolvePessimisticLock(bo, l)
-		} else {
-			if forRead {
-				asyncBo := retry.NewBackoffer(lr.asyncResolveCtx, asyncResolveLockMaxBackoff)
-				go func() {
-					// Pass an empty cleanRegions here to avoid data race and
-					// let `reqCollapse` deduplicate identical resolve requests.
-					err := lr.resolveLock(asyncBo, l, status, lite, map[locate.RegionVerID]struct{}{})
-					if err != nil {
-						logutil.BgLogger().Info("failed to resolve lock asynchronously",
-							zap.String("lock", l.String()), zap.Uint64("commitTS", status.CommitTS()), zap.Error(err))
-					}
-				}()
-			} else {
-				err = lr.resolveLock(bo, l, status, lite, cleanRegions)
-			}
+			return status, lr.resolvePessimisticLock(bo, l)
 		}
-		return status, err
+		pending = append(pending, resolvingLock{Lock: l, status: status})
+		return status, nil
 	}
 
 	var canIgnore, canAccess []uint64
@@ -434,6 +435,21 @@ func (lr *LockResolver) resolveLocks(bo *retry.Backoffer, callerStartTS uint64,
 			msBeforeTxnExpired.update(msBeforeLockExpired)
 		}
 	}
+
+	if len(pending) > 0 {
+		if async {
+			asyncBo := retry.NewBackoffer(lr.asyncResolveCtx, asyncResolveLockMaxBackoff)
+			go func() {
+				if err := lr.resolveLocksByRegion(asyncBo, pending, lite); err != nil {
+					logutil.BgLogger().Info("failed to resolve locks asynchronously",
+						zap.Int("locks", len(pending)), zap.Error(err))
+				}
+			}()
+		} else if err := lr.resolveLocksByRegion(bo, pending, lite); err != nil {
+			msBeforeTxnExpired.update(0)
+			return msBeforeTxnExpired.value(), nil, nil, err
+		}
+	}
 	if msBeforeTxnExpired.value() > 0 {
 		metrics.LockResolverCountWithWaitExpired.Inc()
 	}
@@ -480,6 +496,23 @@ func (lr *LockResolver) GetTxnStatus(txnID uint64, callerStartTS uint64, primary
 	return lr.getTxnStatus(bo, txnID, primary, callerStartTS, currentTS, true, false, nil)
 }
 
+// PeekTxnStatus queries tikv-server for a txn's status like GetTxnStatus, but it
+// never changes the txn: the primary lock is not rolled back even if it's expired,
+// and no rollback record is written if the txn is not found. The returned bool is
+// false if the txn is not found.
+func (lr *LockResolver) PeekTxnStatus(bo *retry.Backoffer, txnID uint64, primary []byte) (TxnStatus, bool, error) {
+	// A zero currentTS never expires the primary lock. forceSyncCommit makes the
+	// TTL of an async commit lock returned as is.
+	status, err := lr.getTxnStatus(bo, txnID, primary, 0, 0, false, true, nil)
+	if _, ok := errors.Cause(err).(txnNotFoundErr); ok {
+		return status, false, nil
+	}
+	if err != nil {
+		return status, false, err
+	}
+	return status, true, nil
+}
+
 func (lr *LockResolver) getTxnStatusFromLock(bo *retry.Backoffer, l *Lock, callerStartTS uint64, forceSyncCommit bool) (TxnStatus, error) {
 	var currentTS uint64
 	var err error
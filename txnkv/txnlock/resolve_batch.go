@@ -0,0 +1,206 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package txnlock
+
+import (
+	"bytes"
+	"sync"
+
+	tikverr "github.com/JK1Zhang/client-go/v3/error"
+	"github.com/JK1Zhang/client-go/v3/internal/client"
+	"github.com/JK1Zhang/client-go/v3/internal/locate"
+	"github.com/JK1Zhang/client-go/v3/internal/retry"
+	"github.com/JK1Zhang/client-go/v3/metrics"
+	"github.com/JK1Zhang/client-go/v3/tikvrpc"
+	"github.com/JK1Zhang/client-go/v3/util"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pkg/errors"
+)
+
+// checkTxnStatusConcurrency is the max number of the txns whose status are
+// checked at the same time by a resolveLocks call.
+const checkTxnStatusConcurrency = 16
+
+// resolvingLock is a lock to resolve with the status of its txn.
+type resolvingLock struct {
+	*Lock
+	status TxnStatus
+}
+
+// checkTxnStatusesConcurrently checks the status of the txns of the locks
+// concurrently, like getTxnStatusFromLock does for the first lock of each txn.
+// It returns the first lock of each txn with the status. Nothing is checked if
+// all the locks belong to the same txn.
+func (lr *LockResolver) checkTxnStatusesConcurrently(bo *retry.Backoffer, callerStartTS uint64, locks []*Lock) (map[*Lock]TxnStatus, error) {
+	txns := make(map[uint64]struct{})
+	firstLocks := make([]*Lock, 0, len(locks))
+	for _, l := range locks {
+		if _, ok := txns[l.TxnID]; ok {
+			continue
+		}
+		txns[l.TxnID] = struct{}{}
+		firstLocks = append(firstLocks, l)
+	}
+	if len(firstLocks) <= 1 {
+		return nil, nil
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		statuses = make(map[*Lock]TxnStatus, len(firstLocks))
+		tokens   = make(chan struct{}, checkTxnStatusConcurrency)
+	)
+	for _, l := range firstLocks {
+		wg.Add(1)
+		tokens <- struct{}{}
+		go func(l *Lock) {
+			defer func() {
+				<-tokens
+				wg.Done()
+			}()
+			forkedBo, cancel := bo.Fork()
+			defer cancel()
+			status, err := lr.getTxnStatusFromLock(forkedBo, l, callerStartTS, false)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			statuses[l] = status
+		}(l)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return statuses, nil
+}
+
+// resolveLocksByRegion resolves the locks of the committed or rolled back txns,
+// with a ResolveLock request per region for the txns resolved in the whole
+// region, and a ResolveLockLite request per region and txn for the others.
+func (lr *LockResolver) resolveLocksByRegion(bo *retry.Backoffer, locks []resolvingLock, lite bool) error {
+	util.EvalFailpoint("resolveLock")
+
+	for len(locks) > 0 {
+		groups := make(map[locate.RegionVerID][]resolvingLock)
+		var regions []locate.RegionVerID
+		for _, l := range locks {
+			loc, err := lr.store.GetRegionCache().LocateKey(bo, l.Key)
+			if err != nil {
+				return err
+			}
+			if _, ok := groups[loc.Region]; !ok {
+				regions = append(regions, loc.Region)
+			}
+			groups[loc.Region] = append(groups[loc.Region], l)
+		}
+
+		var retryLocks []resolvingLock
+		var regionErr error
+		for _, region := range regions {
+			ok, err := lr.resolveLocksInRegion(bo, region, groups[region], lite)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				regionErr = errors.Errorf("region %v changed when resolving locks", region)
+				retryLocks = append(retryLocks, groups[region]...)
+			}
+		}
+		if len(retryLocks) > 0 {
+			if err := bo.Backoff(retry.BoRegionMiss, regionErr); err != nil {
+				return err
+			}
+		}
+		locks = retryLocks
+	}
+	return nil
+}
+
+// resolveLocksInRegion resolves the locks in the region. It returns false if the
+// region has changed, then the locks should be located again.
+func (lr *LockResolver) resolveLocksInRegion(bo *retry.Backoffer, region locate.RegionVerID, locks []resolvingLock, lite bool) (bool, error) {
+	var txnInfos []*kvrpcpb.TxnInfo
+	wholeTxns := make(map[uint64]struct{})
+	liteTxns := make(map[uint64]*kvrpcpb.ResolveLockRequest)
+	var liteReqs []*kvrpcpb.ResolveLockRequest
+	for _, l := range locks {
+		if _, ok := wholeTxns[l.TxnID]; ok {
+			continue
+		}
+		if lite || l.TxnSize < lr.resolveLockLiteThreshold {
+			// The lock has been resolved by getTxnStatusFromLock.
+			if bytes.Equal(l.Key, l.Primary) {
+				continue
+			}
+			req, ok := liteTxns[l.TxnID]
+			if !ok {
+				req = &kvrpcpb.ResolveLockRequest{StartVersion: l.TxnID, CommitVersion: l.status.CommitTS()}
+				liteTxns[l.TxnID] = req
+				liteReqs = append(liteReqs, req)
+			}
+			req.Keys = append(req.Keys, l.Key)
+			continue
+		}
+		wholeTxns[l.TxnID] = struct{}{}
+		txnInfos = append(txnInfos, &kvrpcpb.TxnInfo{Txn: l.TxnID, Status: l.status.CommitTS()})
+	}
+
+	if len(txnInfos) > 0 {
+		metrics.LockResolverCountWithResolveLocks.Inc()
+		ok, err := lr.sendResolveLock(bo, region, &kvrpcpb.ResolveLockRequest{TxnInfos: txnInfos})
+		if err != nil || !ok {
+			return ok, err
+		}
+	}
+	for _, req := range liteReqs {
+		metrics.LockResolverCountWithResolveLocks.Inc()
+		metrics.LockResolverCountWithResolveLockLite.Inc()
+		ok, err := lr.sendResolveLock(bo, region, req)
+		if err != nil || !ok {
+			return ok, err
+		}
+	}
+	return true, nil
+}
+
+func (lr *LockResolver) sendResolveLock(bo *retry.Backoffer, region locate.RegionVerID, lreq *kvrpcpb.ResolveLockRequest) (bool, error) {
+	req := tikvrpc.NewRequest(tikvrpc.CmdResolveLock, lreq)
+	req.MaxExecutionDurationMs = uint64(client.MaxWriteExecutionTime.Milliseconds())
+	resp, err := lr.store.SendReq(bo, req, region, client.ReadTimeoutShort)
+	if err != nil {
+		return false, err
+	}
+	regionErr, err := resp.GetRegionError()
+	if err != nil {
+		return false, err
+	}
+	if regionErr != nil {
+		return false, nil
+	}
+	if resp.Resp == nil {
+		return false, errors.WithStack(tikverr.ErrBodyMissing)
+	}
+	if keyErr := resp.Resp.(*kvrpcpb.ResolveLockResponse).GetError(); keyErr != nil {
+		return false, errors.Errorf("unexpected resolve err: %s, region: %v", keyErr, region)
+	}
+	return true, nil
+}
@@ -0,0 +1,145 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package txnlock
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/JK1Zhang/client-go/v3/config"
+	"github.com/JK1Zhang/client-go/v3/metrics"
+)
+
+// ResolvedCache caches the statuses of the resolved transactions, see
+// TxnStatus.StatusCacheable. The transaction ids are unique in a cluster, so a
+// cache can be shared by the LockResolvers of the stores connected to the same
+// cluster. It must be safe for concurrent use.
+type ResolvedCache interface {
+	// Get returns the status of the transaction if it's cached.
+	Get(txnID uint64) (TxnStatus, bool)
+	// Put caches the status of the transaction.
+	Put(txnID uint64, status TxnStatus)
+}
+
+type resolvedEntry struct {
+	txnID    uint64
+	status   TxnStatus
+	expireAt time.Time
+}
+
+// LRUResolvedCache is a ResolvedCache which evicts the least recently used
+// transaction when it's full, and drops the transactions cached for longer than
+// the TTL.
+type LRUResolvedCache struct {
+	capacity int
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	entries map[uint64]*list.Element
+	lru     *list.List
+}
+
+// NewLRUResolvedCache creates a LRUResolvedCache holding at most capacity
+// transactions. A non-positive ttl means the transactions are never expired.
+func NewLRUResolvedCache(capacity int, ttl time.Duration) *LRUResolvedCache {
+	if capacity <= 0 {
+		capacity = ResolvedCacheSize
+	}
+	return &LRUResolvedCache{
+		capacity: capacity,
+		ttl:      ttl,
+		entries:  make(map[uint64]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// Get implements ResolvedCache.
+func (c *LRUResolvedCache) Get(txnID uint64) (TxnStatus, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[txnID]
+	if !ok {
+		return TxnStatus{}, false
+	}
+	entry := e.Value.(*resolvedEntry)
+	if c.ttl > 0 && time.Now().After(entry.expireAt) {
+		c.removeElement(e)
+		metrics.ResolvedCacheCountWithExpire.Inc()
+		return TxnStatus{}, false
+	}
+	c.lru.MoveToFront(e)
+	return entry.status, true
+}
+
+// Put implements ResolvedCache.
+func (c *LRUResolvedCache) Put(txnID uint64, status TxnStatus) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var expireAt time.Time
+	if c.ttl > 0 {
+		expireAt = time.Now().Add(c.ttl)
+	}
+	if e, ok := c.entries[txnID]; ok {
+		entry := e.Value.(*resolvedEntry)
+		entry.status, entry.expireAt = status, expireAt
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[txnID] = c.lru.PushFront(&resolvedEntry{txnID: txnID, status: status, expireAt: expireAt})
+	for c.lru.Len() > c.capacity {
+		c.removeElement(c.lru.Back())
+		metrics.ResolvedCacheCountWithEvict.Inc()
+	}
+}
+
+// Len returns the number of the cached transactions, including the expired ones
+// not dropped yet.
+func (c *LRUResolvedCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+func (c *LRUResolvedCache) removeElement(e *list.Element) {
+	c.lru.Remove(e)
+	delete(c.entries, e.Value.(*resolvedEntry).txnID)
+}
+
+var sharedResolvedCaches = struct {
+	sync.Mutex
+	caches map[uint64]ResolvedCache
+}{caches: make(map[uint64]ResolvedCache)}
+
+// SharedResolvedCache returns the ResolvedCache shared by the stores connected to
+// the cluster. It's created by the global config at the first call.
+func SharedResolvedCache(clusterID uint64) ResolvedCache {
+	sharedResolvedCaches.Lock()
+	defer sharedResolvedCaches.Unlock()
+
+	cache, ok := sharedResolvedCaches.caches[clusterID]
+	if !ok {
+		cache = newResolvedCacheByConfig()
+		sharedResolvedCaches.caches[clusterID] = cache
+	}
+	return cache
+}
+
+func newResolvedCacheByConfig() ResolvedCache {
+	cfg := config.GetGlobalConfig().TiKVClient.ResolvedCache
+	return NewLRUResolvedCache(int(cfg.Capacity), cfg.TTL)
+}
@@ -0,0 +1,240 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package txnsnapshot
+
+import (
+	"bytes"
+	"context"
+	"sync"
+
+	"github.com/JK1Zhang/client-go/v3/internal/logutil"
+	"github.com/JK1Zhang/client-go/v3/internal/retry"
+	"github.com/JK1Zhang/client-go/v3/kv"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	defaultParallelScanConcurrency = 8
+	parallelScanLocateMaxBackoff   = 20000
+	// parallelScanResultBuffer is the number of batches a region scanner can buffer
+	// before the caller consumes them in ordered mode.
+	parallelScanResultBuffer = 4
+)
+
+// ParallelScanHandler is called for each key-value pair read by ParallelScan.
+// The calls are serialized, so the handler does not need to be thread safe.
+// Returning an error stops the scan and the error is returned by ParallelScan.
+type ParallelScanHandler func(key, value []byte) error
+
+// parallelScanOptions indicates the option of ParallelScan.
+type parallelScanOptions struct {
+	unordered bool
+}
+
+// ParallelScanOption configures ParallelScan.
+type ParallelScanOption func(*parallelScanOptions)
+
+// WithUnordered makes ParallelScan hand the key-value pairs to the handler as soon as
+// they are read, instead of in key order. It avoids buffering results of regions that
+// are read ahead of the caller.
+func WithUnordered() ParallelScanOption {
+	return func(o *parallelScanOptions) {
+		o.unordered = true
+	}
+}
+
+type scanBatch struct {
+	keys   [][]byte
+	values [][]byte
+}
+
+type parallelScanTask struct {
+	kv.KeyRange
+	results chan scanBatch
+}
+
+// ParallelScan reads all key-value pairs in [startKey, endKey) of the snapshot.
+// The range is split by regions and each region is read by a scanner in one of
+// the concurrency workers, the encountered locks are resolved by the LockResolver.
+// By default the pairs are handed to the handler in key order, use WithUnordered
+// to receive them in the order they are read.
+// An empty endKey means the range is unbounded.
+func (s *KVSnapshot) ParallelScan(ctx context.Context, startKey, endKey []byte, concurrency int, handler ParallelScanHandler, opts ...ParallelScanOption) error {
+	options := &parallelScanOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if concurrency <= 0 {
+		concurrency = defaultParallelScanConcurrency
+	}
+
+	bo := retry.NewBackofferWithVars(ctx, parallelScanLocateMaxBackoff, s.vars)
+	ranges, err := s.splitRangeByRegions(bo, startKey, endKey)
+	if err != nil {
+		return err
+	}
+	if len(ranges) == 0 {
+		return nil
+	}
+	if concurrency > len(ranges) {
+		concurrency = len(ranges)
+	}
+	logutil.Logger(ctx).Debug("parallel scan",
+		zap.String("startKey", kv.StrKey(startKey)),
+		zap.String("endKey", kv.StrKey(endKey)),
+		zap.Int("regions", len(ranges)),
+		zap.Int("concurrency", concurrency),
+		zap.Uint64("txnStartTS", s.version))
+
+	tasks := make([]*parallelScanTask, 0, len(ranges))
+	for _, r := range ranges {
+		task := &parallelScanTask{KeyRange: r}
+		if !options.unordered {
+			task.results = make(chan scanBatch, parallelScanResultBuffer)
+		}
+		tasks = append(tasks, task)
+	}
+
+	eg, egCtx := errgroup.WithContext(ctx)
+	taskCh := make(chan *parallelScanTask, len(tasks))
+	for _, task := range tasks {
+		taskCh <- task
+	}
+	close(taskCh)
+
+	var handlerMu sync.Mutex
+	for i := 0; i < concurrency; i++ {
+		eg.Go(func() error {
+			for task := range taskCh {
+				var err error
+				if options.unordered {
+					err = s.scanRange(egCtx, task.KeyRange, func(batch scanBatch) error {
+						handlerMu.Lock()
+						defer handlerMu.Unlock()
+						return batch.handle(handler)
+					})
+				} else {
+					err = s.scanRange(egCtx, task.KeyRange, func(batch scanBatch) error {
+						select {
+						case task.results <- batch:
+							return nil
+						case <-egCtx.Done():
+							return egCtx.Err()
+						}
+					})
+					close(task.results)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	if !options.unordered {
+		eg.Go(func() error {
+			for _, task := range tasks {
+				if err := task.consume(egCtx, handler); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	return eg.Wait()
+}
+
+// consume hands the results of the task to the handler until the task is finished.
+func (t *parallelScanTask) consume(ctx context.Context, handler ParallelScanHandler) error {
+	for {
+		select {
+		case batch, ok := <-t.results:
+			if !ok {
+				return nil
+			}
+			if err := batch.handle(handler); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		}
+	}
+}
+
+func (b scanBatch) handle(handler ParallelScanHandler) error {
+	for i := range b.keys {
+		if err := handler(b.keys[i], b.values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanRange reads the range with a Scanner, and calls f for each batch of pairs.
+func (s *KVSnapshot) scanRange(ctx context.Context, r kv.KeyRange, f func(scanBatch) error) error {
+	scanner, err := newScanner(s, r.StartKey, r.EndKey, s.scanBatchSize, false)
+	if err != nil {
+		return err
+	}
+	defer scanner.Close()
+
+	batch := scanBatch{}
+	for scanner.Valid() {
+		batch.keys = append(batch.keys, scanner.Key())
+		batch.values = append(batch.values, scanner.Value())
+		if len(batch.keys) >= scanner.batchSize {
+			if err = f(batch); err != nil {
+				return err
+			}
+			batch = scanBatch{}
+		}
+		if err = ctx.Err(); err != nil {
+			return errors.WithStack(err)
+		}
+		if err = scanner.Next(); err != nil {
+			return err
+		}
+	}
+	if len(batch.keys) > 0 {
+		return f(batch)
+	}
+	return nil
+}
+
+// splitRangeByRegions splits [startKey, endKey) into the key ranges of the regions.
+func (s *KVSnapshot) splitRangeByRegions(bo *retry.Backoffer, startKey, endKey []byte) ([]kv.KeyRange, error) {
+	var ranges []kv.KeyRange
+	for {
+		if len(endKey) > 0 && bytes.Compare(startKey, endKey) >= 0 {
+			return ranges, nil
+		}
+		loc, err := s.store.GetRegionCache().LocateKey(bo, startKey)
+		if err != nil {
+			return nil, err
+		}
+		r := kv.KeyRange{StartKey: startKey, EndKey: endKey}
+		if len(loc.EndKey) > 0 && (len(endKey) == 0 || bytes.Compare(loc.EndKey, endKey) < 0) {
+			r.EndKey = loc.EndKey
+		}
+		ranges = append(ranges, r)
+		if len(loc.EndKey) == 0 || len(r.EndKey) == 0 || bytes.Equal(r.EndKey, endKey) {
+			return ranges, nil
+		}
+		startKey = loc.EndKey
+	}
+}
@@ -757,6 +757,13 @@ func (s *KVSnapshot) SetIsStatenessReadOnly(b bool) {
 	s.mu.isStaleness = b
 }
 
+// IsStatenessReadOnly returns whether the snapshot is used by a staleness read only transaction.
+func (s *KVSnapshot) IsStatenessReadOnly() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.mu.isStaleness
+}
+
 // SetMatchStoreLabels sets up labels to filter target stores.
 func (s *KVSnapshot) SetMatchStoreLabels(labels []*metapb.StoreLabel) {
 	s.mu.Lock()
@@ -143,6 +143,21 @@ func DecodeBytes(b []byte, buf []byte) ([]byte, []byte, error) {
 	return decodeBytes(b, buf, false)
 }
 
+// EncodeBytesDesc first encodes bytes using EncodeBytes, then bitwise reverses
+// encoded value to guarantee the encoded value is in descending order for comparison.
+func EncodeBytesDesc(b []byte, data []byte) []byte {
+	n := len(b)
+	b = EncodeBytes(b, data)
+	reverseBytes(b[n:])
+	return b
+}
+
+// DecodeBytesDesc decodes bytes which is encoded by EncodeBytesDesc before,
+// returns the leftover bytes and decoded value if no error.
+func DecodeBytesDesc(b []byte, buf []byte) ([]byte, []byte, error) {
+	return decodeBytes(b, buf, true)
+}
+
 // See https://golang.org/src/crypto/cipher/xor.go
 const wordSize = int(unsafe.Sizeof(uintptr(0)))
 const supportsUnaligned = runtime.GOARCH == "386" || runtime.GOARCH == "amd64"
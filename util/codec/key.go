@@ -0,0 +1,376 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/JK1Zhang/client-go/v3/kv"
+	"github.com/pkg/errors"
+)
+
+// The flags prefixed to the components of a tuple key. A descending component
+// has its own flag instead of the reversed flag of the ascending one, so the
+// tuples still compare component by component when the order is mixed.
+const (
+	bytesFlag     byte = 0x01
+	intFlag       byte = 0x03
+	uintFlag      byte = 0x04
+	floatFlag     byte = 0x05
+	boolFlag      byte = 0x06
+	timeFlag      byte = 0x07
+	bytesDescFlag byte = 0x81
+	intDescFlag   byte = 0x83
+	uintDescFlag  byte = 0x84
+	floatDescFlag byte = 0x85
+	boolDescFlag  byte = 0x86
+	timeDescFlag  byte = 0x87
+)
+
+// KeyBuilder builds a key from a tuple of typed components. The key is
+// memcomparable: for tuples of the same types, comparing the keys as bytes
+// gives the same order as comparing the tuples component by component, and
+// the key of a tuple is a prefix of the keys of the tuples it's a prefix of.
+type KeyBuilder struct {
+	buf []byte
+}
+
+// NewKeyBuilder creates a KeyBuilder whose keys start with prefix.
+func NewKeyBuilder(prefix []byte) *KeyBuilder {
+	return &KeyBuilder{buf: append([]byte{}, prefix...)}
+}
+
+// AddBytes appends a bytes component.
+func (b *KeyBuilder) AddBytes(v []byte) *KeyBuilder {
+	b.buf = EncodeBytes(append(b.buf, bytesFlag), v)
+	return b
+}
+
+// AddBytesDesc appends a bytes component in descending order.
+func (b *KeyBuilder) AddBytesDesc(v []byte) *KeyBuilder {
+	b.buf = EncodeBytesDesc(append(b.buf, bytesDescFlag), v)
+	return b
+}
+
+// AddString appends a string component, it's encoded as bytes.
+func (b *KeyBuilder) AddString(v string) *KeyBuilder {
+	return b.AddBytes([]byte(v))
+}
+
+// AddStringDesc appends a string component in descending order.
+func (b *KeyBuilder) AddStringDesc(v string) *KeyBuilder {
+	return b.AddBytesDesc([]byte(v))
+}
+
+// AddInt64 appends an int64 component.
+func (b *KeyBuilder) AddInt64(v int64) *KeyBuilder {
+	b.buf = EncodeInt(append(b.buf, intFlag), v)
+	return b
+}
+
+// AddInt64Desc appends an int64 component in descending order.
+func (b *KeyBuilder) AddInt64Desc(v int64) *KeyBuilder {
+	b.buf = EncodeIntDesc(append(b.buf, intDescFlag), v)
+	return b
+}
+
+// AddUint64 appends a uint64 component.
+func (b *KeyBuilder) AddUint64(v uint64) *KeyBuilder {
+	b.buf = EncodeUint(append(b.buf, uintFlag), v)
+	return b
+}
+
+// AddUint64Desc appends a uint64 component in descending order.
+func (b *KeyBuilder) AddUint64Desc(v uint64) *KeyBuilder {
+	b.buf = EncodeUintDesc(append(b.buf, uintDescFlag), v)
+	return b
+}
+
+// AddFloat64 appends a float64 component. NaN is not supported.
+func (b *KeyBuilder) AddFloat64(v float64) *KeyBuilder {
+	b.buf = EncodeFloat(append(b.buf, floatFlag), v)
+	return b
+}
+
+// AddFloat64Desc appends a float64 component in descending order. NaN is not supported.
+func (b *KeyBuilder) AddFloat64Desc(v float64) *KeyBuilder {
+	b.buf = EncodeFloatDesc(append(b.buf, floatDescFlag), v)
+	return b
+}
+
+// AddBool appends a bool component, false is ordered before true.
+func (b *KeyBuilder) AddBool(v bool) *KeyBuilder {
+	b.buf = append(b.buf, boolFlag, boolToByte(v))
+	return b
+}
+
+// AddBoolDesc appends a bool component in descending order.
+func (b *KeyBuilder) AddBoolDesc(v bool) *KeyBuilder {
+	b.buf = append(b.buf, boolDescFlag, ^boolToByte(v))
+	return b
+}
+
+// AddTime appends a timestamp component with nanosecond precision.
+// The location of the time is not encoded, the decoded time is in UTC.
+func (b *KeyBuilder) AddTime(v time.Time) *KeyBuilder {
+	b.buf = EncodeInt(append(b.buf, timeFlag), v.Unix())
+	b.buf = appendUint32(b.buf, uint32(v.Nanosecond()))
+	return b
+}
+
+// AddTimeDesc appends a timestamp component in descending order.
+func (b *KeyBuilder) AddTimeDesc(v time.Time) *KeyBuilder {
+	b.buf = EncodeIntDesc(append(b.buf, timeDescFlag), v.Unix())
+	b.buf = appendUint32(b.buf, ^uint32(v.Nanosecond()))
+	return b
+}
+
+// Key returns a copy of the key built so far. The builder can be used to
+// append more components after that.
+func (b *KeyBuilder) Key() []byte {
+	return append([]byte{}, b.buf...)
+}
+
+// PrefixRange returns the range of the keys that start with the key built so far.
+// See PrefixRange.
+func (b *KeyBuilder) PrefixRange() (startKey, endKey []byte) {
+	return PrefixRange(b.buf)
+}
+
+// Reset resets the builder to build a new key with prefix.
+func (b *KeyBuilder) Reset(prefix []byte) {
+	b.buf = append(b.buf[:0], prefix...)
+}
+
+// PrefixRange returns the range [startKey, endKey) of the keys that start with
+// prefix, it can be used to scan in rawkv and txnkv. The endKey is empty if the
+// range is unbounded, which happens only if the prefix is empty or all 0xFF.
+func PrefixRange(prefix []byte) (startKey, endKey []byte) {
+	return append([]byte{}, prefix...), kv.PrefixNextKey(prefix)
+}
+
+// KeyDecoder decodes the components of a key built by KeyBuilder in order.
+// The types of the components must be decoded in the same order as they are added.
+type KeyDecoder struct {
+	b []byte
+}
+
+// NewKeyDecoder creates a KeyDecoder of the key. The prefix of the key passed
+// to NewKeyBuilder must be stripped by the caller, or be skipped by SkipPrefix.
+func NewKeyDecoder(key []byte) *KeyDecoder {
+	return &KeyDecoder{b: key}
+}
+
+// SkipPrefix skips the prefix of the key.
+func (d *KeyDecoder) SkipPrefix(prefix []byte) error {
+	if !bytes.HasPrefix(d.b, prefix) {
+		return errors.Errorf("key %q doesn't have prefix %q", d.b, prefix)
+	}
+	d.b = d.b[len(prefix):]
+	return nil
+}
+
+// Done returns whether all components are decoded.
+func (d *KeyDecoder) Done() bool {
+	return len(d.b) == 0
+}
+
+// Remaining returns the part of the key not decoded yet.
+func (d *KeyDecoder) Remaining() []byte {
+	return d.b
+}
+
+func (d *KeyDecoder) expectFlag(flag byte) error {
+	if len(d.b) == 0 {
+		return errors.WithStack(errDecodeInsufficient)
+	}
+	if d.b[0] != flag {
+		return errors.Errorf("unexpected key component flag %#x, expect %#x", d.b[0], flag)
+	}
+	d.b = d.b[1:]
+	return nil
+}
+
+// DecodeBytes decodes a bytes component.
+func (d *KeyDecoder) DecodeBytes() ([]byte, error) {
+	return d.decodeBytes(bytesFlag, DecodeBytes)
+}
+
+// DecodeBytesDesc decodes a bytes component in descending order.
+func (d *KeyDecoder) DecodeBytesDesc() ([]byte, error) {
+	return d.decodeBytes(bytesDescFlag, DecodeBytesDesc)
+}
+
+// DecodeString decodes a string component.
+func (d *KeyDecoder) DecodeString() (string, error) {
+	v, err := d.DecodeBytes()
+	return string(v), err
+}
+
+// DecodeStringDesc decodes a string component in descending order.
+func (d *KeyDecoder) DecodeStringDesc() (string, error) {
+	v, err := d.DecodeBytesDesc()
+	return string(v), err
+}
+
+func (d *KeyDecoder) decodeBytes(flag byte, decode func(b []byte, buf []byte) ([]byte, []byte, error)) ([]byte, error) {
+	if err := d.expectFlag(flag); err != nil {
+		return nil, err
+	}
+	b, v, err := decode(d.b, nil)
+	if err != nil {
+		return nil, err
+	}
+	d.b = b
+	return v, nil
+}
+
+// DecodeInt64 decodes an int64 component.
+func (d *KeyDecoder) DecodeInt64() (int64, error) {
+	return d.decodeInt(intFlag, DecodeInt)
+}
+
+// DecodeInt64Desc decodes an int64 component in descending order.
+func (d *KeyDecoder) DecodeInt64Desc() (int64, error) {
+	return d.decodeInt(intDescFlag, DecodeIntDesc)
+}
+
+func (d *KeyDecoder) decodeInt(flag byte, decode func(b []byte) ([]byte, int64, error)) (int64, error) {
+	if err := d.expectFlag(flag); err != nil {
+		return 0, err
+	}
+	b, v, err := decode(d.b)
+	if err != nil {
+		return 0, err
+	}
+	d.b = b
+	return v, nil
+}
+
+// DecodeUint64 decodes a uint64 component.
+func (d *KeyDecoder) DecodeUint64() (uint64, error) {
+	return d.decodeUint(uintFlag, DecodeUint)
+}
+
+// DecodeUint64Desc decodes a uint64 component in descending order.
+func (d *KeyDecoder) DecodeUint64Desc() (uint64, error) {
+	return d.decodeUint(uintDescFlag, DecodeUintDesc)
+}
+
+func (d *KeyDecoder) decodeUint(flag byte, decode func(b []byte) ([]byte, uint64, error)) (uint64, error) {
+	if err := d.expectFlag(flag); err != nil {
+		return 0, err
+	}
+	b, v, err := decode(d.b)
+	if err != nil {
+		return 0, err
+	}
+	d.b = b
+	return v, nil
+}
+
+// DecodeFloat64 decodes a float64 component.
+func (d *KeyDecoder) DecodeFloat64() (float64, error) {
+	return d.decodeFloat(floatFlag, DecodeFloat)
+}
+
+// DecodeFloat64Desc decodes a float64 component in descending order.
+func (d *KeyDecoder) DecodeFloat64Desc() (float64, error) {
+	return d.decodeFloat(floatDescFlag, DecodeFloatDesc)
+}
+
+func (d *KeyDecoder) decodeFloat(flag byte, decode func(b []byte) ([]byte, float64, error)) (float64, error) {
+	if err := d.expectFlag(flag); err != nil {
+		return 0, err
+	}
+	b, v, err := decode(d.b)
+	if err != nil {
+		return 0, err
+	}
+	d.b = b
+	return v, nil
+}
+
+// DecodeBool decodes a bool component.
+func (d *KeyDecoder) DecodeBool() (bool, error) {
+	return d.decodeBool(boolFlag, false)
+}
+
+// DecodeBoolDesc decodes a bool component in descending order.
+func (d *KeyDecoder) DecodeBoolDesc() (bool, error) {
+	return d.decodeBool(boolDescFlag, true)
+}
+
+func (d *KeyDecoder) decodeBool(flag byte, desc bool) (bool, error) {
+	if err := d.expectFlag(flag); err != nil {
+		return false, err
+	}
+	if len(d.b) == 0 {
+		return false, errors.WithStack(errDecodeInsufficient)
+	}
+	v := d.b[0]
+	if desc {
+		v = ^v
+	}
+	if v > 1 {
+		return false, errors.WithStack(errDecodeInvalid)
+	}
+	d.b = d.b[1:]
+	return v == 1, nil
+}
+
+// DecodeTime decodes a timestamp component.
+func (d *KeyDecoder) DecodeTime() (time.Time, error) {
+	return d.decodeTime(timeFlag, false)
+}
+
+// DecodeTimeDesc decodes a timestamp component in descending order.
+func (d *KeyDecoder) DecodeTimeDesc() (time.Time, error) {
+	return d.decodeTime(timeDescFlag, true)
+}
+
+func (d *KeyDecoder) decodeTime(flag byte, desc bool) (time.Time, error) {
+	if err := d.expectFlag(flag); err != nil {
+		return time.Time{}, err
+	}
+	if len(d.b) < 12 {
+		return time.Time{}, errors.WithStack(errDecodeInsufficient)
+	}
+	var sec int64
+	nsec := binary.BigEndian.Uint32(d.b[8:12])
+	if desc {
+		_, sec, _ = DecodeIntDesc(d.b)
+		nsec = ^nsec
+	} else {
+		_, sec, _ = DecodeInt(d.b)
+	}
+	d.b = d.b[12:]
+	return time.Unix(sec, int64(nsec)).UTC(), nil
+}
+
+func boolToByte(v bool) byte {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var data [4]byte
+	binary.BigEndian.PutUint32(data[:], v)
+	return append(b, data[:]...)
+}
@@ -0,0 +1,190 @@
+// Copyright 2021 TiKV Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testTuple struct {
+	s  string
+	i  int64
+	u  uint64
+	f  float64
+	b  bool
+	t  time.Time
+	sd string
+	id int64
+}
+
+func (t testTuple) key() []byte {
+	return NewKeyBuilder([]byte("p_")).
+		AddString(t.s).AddInt64(t.i).AddUint64(t.u).AddFloat64(t.f).AddBool(t.b).AddTime(t.t).
+		AddStringDesc(t.sd).AddInt64Desc(t.id).Key()
+}
+
+func compareTuple(a, b testTuple) int {
+	cmps := []int{
+		compareOrdered(a.s < b.s, a.s > b.s),
+		compareOrdered(a.i < b.i, a.i > b.i),
+		compareOrdered(a.u < b.u, a.u > b.u),
+		compareOrdered(a.f < b.f, a.f > b.f),
+		compareOrdered(!a.b && b.b, a.b && !b.b),
+		compareOrdered(a.t.Before(b.t), a.t.After(b.t)),
+		compareOrdered(a.sd > b.sd, a.sd < b.sd),
+		compareOrdered(a.id > b.id, a.id < b.id),
+	}
+	for _, c := range cmps {
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func compareOrdered(less, greater bool) int {
+	if less {
+		return -1
+	}
+	if greater {
+		return 1
+	}
+	return 0
+}
+
+func randTuple(r *rand.Rand) testTuple {
+	strs := []string{"", "a", "a\x00", "ab", "abcdefgh", "abcdefghi", "b", "\xff"}
+	ints := []int64{math.MinInt64, -100, -1, 0, 1, 100, math.MaxInt64}
+	uints := []uint64{0, 1, 255, 256, math.MaxUint64}
+	floats := []float64{math.Inf(-1), -1.5, -math.SmallestNonzeroFloat64, 0, 0.25, 1e100, math.Inf(1)}
+	times := []time.Time{time.Unix(-100, 5), time.Unix(0, 0), time.Unix(0, 1), time.Unix(1600000000, 999999999), time.Unix(1600000001, 0)}
+	return testTuple{
+		s:  strs[r.Intn(len(strs))],
+		i:  ints[r.Intn(len(ints))],
+		u:  uints[r.Intn(len(uints))],
+		f:  floats[r.Intn(len(floats))],
+		b:  r.Intn(2) == 0,
+		t:  times[r.Intn(len(times))],
+		sd: strs[r.Intn(len(strs))],
+		id: ints[r.Intn(len(ints))],
+	}
+}
+
+func TestKeyOrder(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	tuples := make([]testTuple, 2000)
+	for i := range tuples {
+		tuples[i] = randTuple(r)
+	}
+	sort.Slice(tuples, func(i, j int) bool { return compareTuple(tuples[i], tuples[j]) < 0 })
+	for i := 1; i < len(tuples); i++ {
+		cmp := bytes.Compare(tuples[i-1].key(), tuples[i].key())
+		require.Equal(t, compareTuple(tuples[i-1], tuples[i]), cmp, "%+v %+v", tuples[i-1], tuples[i])
+	}
+}
+
+func TestKeyDecode(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for i := 0; i < 100; i++ {
+		tuple := randTuple(r)
+		d := NewKeyDecoder(tuple.key())
+		require.Nil(t, d.SkipPrefix([]byte("p_")))
+		var decoded testTuple
+		var err error
+		decoded.s, err = d.DecodeString()
+		require.Nil(t, err)
+		decoded.i, err = d.DecodeInt64()
+		require.Nil(t, err)
+		decoded.u, err = d.DecodeUint64()
+		require.Nil(t, err)
+		decoded.f, err = d.DecodeFloat64()
+		require.Nil(t, err)
+		decoded.b, err = d.DecodeBool()
+		require.Nil(t, err)
+		decoded.t, err = d.DecodeTime()
+		require.Nil(t, err)
+		decoded.sd, err = d.DecodeStringDesc()
+		require.Nil(t, err)
+		decoded.id, err = d.DecodeInt64Desc()
+		require.Nil(t, err)
+		assert.True(t, d.Done())
+		assert.True(t, tuple.t.Equal(decoded.t))
+		decoded.t = tuple.t
+		assert.Equal(t, tuple, decoded)
+	}
+
+	key := NewKeyBuilder(nil).AddUint64Desc(10).AddFloat64Desc(-2.5).AddBoolDesc(true).AddBytesDesc([]byte("x")).
+		AddTimeDesc(time.Unix(5, 6)).Key()
+	d := NewKeyDecoder(key)
+	u, err := d.DecodeUint64Desc()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(10), u)
+	f, err := d.DecodeFloat64Desc()
+	assert.Nil(t, err)
+	assert.Equal(t, -2.5, f)
+	b, err := d.DecodeBoolDesc()
+	assert.Nil(t, err)
+	assert.True(t, b)
+	bs, err := d.DecodeBytesDesc()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("x"), bs)
+	tm, err := d.DecodeTimeDesc()
+	assert.Nil(t, err)
+	assert.True(t, time.Unix(5, 6).Equal(tm))
+	assert.True(t, d.Done())
+
+	// Decoding a component of a wrong type fails.
+	d = NewKeyDecoder(NewKeyBuilder(nil).AddInt64(1).Key())
+	_, err = d.DecodeUint64()
+	assert.NotNil(t, err)
+	_, err = d.DecodeInt64Desc()
+	assert.NotNil(t, err)
+	assert.NotNil(t, NewKeyDecoder([]byte("ab")).SkipPrefix([]byte("b")))
+}
+
+func TestPrefixRange(t *testing.T) {
+	b := NewKeyBuilder([]byte("t")).AddString("user").AddInt64(-1)
+	start, end := b.PrefixRange()
+	assert.Equal(t, b.Key(), start)
+	for _, key := range [][]byte{
+		b.Key(),
+		NewKeyBuilder([]byte("t")).AddString("user").AddInt64(-1).AddString("").Key(),
+		NewKeyBuilder([]byte("t")).AddString("user").AddInt64(-1).AddUint64(math.MaxUint64).Key(),
+	} {
+		assert.True(t, bytes.Compare(start, key) <= 0 && bytes.Compare(key, end) < 0)
+	}
+	for _, key := range [][]byte{
+		NewKeyBuilder([]byte("t")).AddString("user").AddInt64(-2).Key(),
+		NewKeyBuilder([]byte("t")).AddString("user").AddInt64(0).Key(),
+		NewKeyBuilder([]byte("t")).AddString("user\x00").Key(),
+	} {
+		assert.False(t, bytes.Compare(start, key) <= 0 && bytes.Compare(key, end) < 0)
+	}
+
+	start, end = PrefixRange([]byte{0xff, 0xff})
+	assert.Equal(t, []byte{0xff, 0xff}, start)
+	assert.Empty(t, end)
+	start, end = PrefixRange([]byte{0x01, 0xff})
+	assert.Equal(t, []byte{0x01, 0xff}, start)
+	assert.Equal(t, []byte{0x02, 0x00}, end)
+}